@@ -1,4 +1,210 @@
+// Command api serves the HTTP API.
+//
+// Usage:
+//
+//	api [-config FILE] [-print-config]
 package main
 
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gorm-reference/internal/cache"
+	"gorm-reference/internal/config"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/handler"
+	"gorm-reference/internal/health"
+	"gorm-reference/internal/logging"
+	"gorm-reference/internal/metrics"
+	"gorm-reference/internal/repository"
+	"gorm-reference/internal/service"
+	"gorm-reference/internal/tenant"
+	"gorm-reference/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shutdownTimeout bounds how long in-flight requests get to finish
+const shutdownTimeout = 15 * time.Second
+
 func main() {
+	configFile := flag.String("config", "", "YAML configuration file (default $CONFIG_FILE)")
+	printConfig := flag.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(config.Options{ConfigFile: *configFile})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		fmt.Print(cfg)
+		return
+	}
+
+	if err := run(cfg); err != nil {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg *config.Config) error {
+	if err := logging.Setup(logging.Config{
+		Format: cfg.Log.Format,
+		Level:  cfg.Log.Level,
+		Levels: cfg.Log.Levels,
+	}); err != nil {
+		return err
+	}
+	logger := logging.For("main")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName:    "gorm-reference",
+		ServiceVersion: cfg.App.Version,
+		Exporter:       cfg.Tracing.Exporter,
+		Endpoint:       cfg.Tracing.Endpoint,
+		File:           cfg.Tracing.File,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	reg := metrics.NewRegistry()
+	gormDB, err := db.Open(cfg, reg)
+	if err != nil {
+		return err
+	}
+
+	replicas, err := db.OpenReplicas(ctx, cfg, gormDB, reg)
+	if err != nil {
+		return err
+	}
+	defer replicas.Close()
+	go replicas.Watch(ctx, cfg.DB.ReplicaCheckInterval)
+
+	ready := health.NewChecker(cfg.Health.Timeout, cfg.Health.CacheTTL,
+		db.ReadinessChecks(gormDB, cfg.Health.MaxPoolUsage)...)
+	ready.Add(replicas.Checks()...)
+
+	repo := repository.NewRepository(gormDB)
+	if cfg.Cache.Size > 0 {
+		// Cached users hold decrypted personal data, so the cache seals them
+		// with the same keys as the database
+		keys, err := db.Keyring(cfg)
+		if err != nil {
+			return err
+		}
+		repo = repository.WithCache(repo, cache.New(cache.NewLRU(cfg.Cache.Size), keys), repository.CacheTTLs{
+			User: cfg.Cache.UserTTL,
+			Post: cfg.Cache.PostTTL,
+		})
+	}
+
+	svc := service.NewService(repo)
+	if cfg.Trash.Retention > 0 {
+		go purgeTrash(ctx, svc.Trash, cfg.Trash.Retention, cfg.Trash.PurgeInterval)
+	}
+	if cfg.Encryption.ReencryptInterval > 0 {
+		go reencrypt(ctx, svc.Encryption, cfg.Encryption.ReencryptBatchSize, cfg.Encryption.ReencryptInterval)
+	}
+	h := handler.NewHandler(svc, ready, reg, tenant.Resolver{
+		Header: cfg.Tenant.Header,
+		Domain: cfg.Tenant.Domain,
+	})
+
+	if cfg.App.Env == "production" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	r.Use(gin.Recovery())
+	h.Register(r)
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.App.Port),
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	logger.Info("listening", "addr", srv.Addr, "config", cfg)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// purgeTrash purges the records that outlived the trash retention every
+// interval until ctx is done
+func purgeTrash(ctx context.Context, trash service.TrashService, retention, interval time.Duration) {
+	logger := logging.For("trash")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := trash.PurgeExpired(ctx, retention)
+			if err != nil {
+				logger.Error("failed to purge trash", "error", err)
+				continue
+			}
+			if purged > 0 {
+				logger.Info("purged trash", "records", purged)
+			}
+		}
+	}
+}
+
+// reencrypt moves encrypted values to the primary key and fills in missing
+// blind indexes at start, then every interval until ctx is done
+func reencrypt(ctx context.Context, encryption service.EncryptionService, batchSize int, interval time.Duration) {
+	logger := logging.For("encryption")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rewritten, err := encryption.Reencrypt(ctx, batchSize)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Error("failed to re-encrypt", "error", err)
+		case rewritten > 0:
+			logger.Info("re-encrypted", "rows", rewritten)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,368 @@
+// Command migrate manages the versioned SQL migrations embedded in the
+// application.
+//
+// Usage:
+//
+//	migrate up              apply all pending migrations, then encrypt the rows
+//	                        written before their columns were encrypted
+//	migrate down [N]        roll back the last N migrations (default 1)
+//	migrate to VERSION      migrate up or down to VERSION (0 rolls back everything)
+//	migrate status          list applied and pending migrations
+//	migrate drift [-strict] compare the models with the database schema
+//	migrate generate NAME   write a migration for the differences found by drift
+//	migrate indexes         create missing declared indexes and report unused ones
+//	migrate create NAME     add an empty up/down pair to the migrations directory
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorm-reference/internal/config"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/db/drift"
+	"gorm-reference/internal/db/indexes"
+	"gorm-reference/internal/db/migrate"
+	"gorm-reference/internal/db/migrations"
+	"gorm-reference/internal/metrics"
+	"gorm-reference/internal/models"
+	"gorm-reference/internal/repository"
+	"gorm-reference/internal/service"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// errDrift makes the process exit non-zero so CI fails on schema drift
+var errDrift = errors.New("schema drift detected")
+
+func main() {
+	dir := flag.String("dir", "internal/db/migrations", "migrations directory used by create")
+	configFile := flag.String("config", "", "YAML configuration file (default $CONFIG_FILE)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(*dir, *configFile, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(flag.CommandLine.Output(), `usage: migrate [-dir DIR] [-config FILE] <command> [args]
+
+commands:
+  up              apply all pending migrations, then encrypt the rows written
+                  before their columns were encrypted
+  down [N]        roll back the last N migrations (default 1)
+  to VERSION      migrate up or down to VERSION (0 rolls back everything)
+  status          list applied and pending migrations
+  drift [-strict] compare the models with the database schema; exits 1 on
+                  drift, and with -strict also on objects the models don't declare
+  create NAME     add an empty up/down pair to the migrations directory
+  generate [-from-migrations] [-include-extras] NAME
+                  write a migration for the differences between the models and
+                  the database, or with -from-migrations the schema produced by
+                  the migrations in -dir; changes that may lose data are
+                  written commented out for review
+  indexes [-report-only]
+                  create the indexes and constraints the models declare that
+                  are missing, then report unused and duplicate indexes
+`)
+}
+
+func run(dir, configFile, command string, args []string) error {
+	// create only touches the filesystem, so it works without a database
+	if command == "create" {
+		if len(args) != 1 {
+			return fmt.Errorf("create: expected a migration name")
+		}
+		up, down, err := migrate.Create(dir, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println("created", up)
+		fmt.Println("created", down)
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg, err := config.Load(config.Options{ConfigFile: configFile})
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("pgx", cfg.DB.DSN())
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		applied, err := m.Up(ctx)
+		report("applied", applied)
+		if err != nil {
+			return err
+		}
+		return reencrypt(ctx, cfg)
+
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			if steps, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("down: invalid step count %q", args[0])
+			}
+		}
+		rolledBack, err := m.Down(ctx, steps)
+		report("rolled back", rolledBack)
+		return err
+
+	case "to":
+		if len(args) != 1 {
+			return fmt.Errorf("to: expected a target version")
+		}
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("to: invalid version %q", args[0])
+		}
+		changed, err := m.To(ctx, version)
+		report("migrated", changed)
+		return err
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(statuses)
+		return nil
+
+	case "drift":
+		return checkDrift(ctx, db, args)
+
+	case "generate":
+		return generate(ctx, db, cfg.DB.DSN(), dir, args)
+
+	case "indexes":
+		return reconcileIndexes(ctx, db, args)
+
+	default:
+		usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// reencrypt encrypts the values written before their columns were encrypted
+// and fills in their blind indexes, which lookups by email and the
+// uniqueness of emails depend on. It also moves values to the primary key.
+// up runs it so that this is done before the application serves traffic.
+func reencrypt(ctx context.Context, cfg *config.Config) error {
+	gormDB, err := db.Open(cfg, metrics.NewRegistry())
+	if err != nil {
+		return err
+	}
+	defer db.Close(gormDB)
+
+	svc := service.NewService(repository.NewRepository(gormDB))
+	rewritten, err := svc.Encryption.Reencrypt(ctx, cfg.Encryption.ReencryptBatchSize)
+	if rewritten > 0 {
+		fmt.Printf("re-encrypted %d rows\n", rewritten)
+	}
+	return err
+}
+
+func checkDrift(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("drift", flag.ContinueOnError)
+	strict := flags.Bool("strict", false, "also fail on tables, columns, indexes and constraints the models don't declare")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := models.SetupJoinTable(gormDB); err != nil {
+		return err
+	}
+
+	report, err := drift.Check(ctx, gormDB, models.All()...)
+	if err != nil {
+		return err
+	}
+	if err := report.Print(os.Stdout); err != nil {
+		return err
+	}
+
+	if report.HasDrift(*strict) {
+		return errDrift
+	}
+	return nil
+}
+
+func generate(ctx context.Context, db *sql.DB, dsn, dir string, args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fromMigrations := flags.Bool("from-migrations", false, "diff against the schema produced by the migrations in -dir instead of the database")
+	includeExtras := flags.Bool("include-extras", false, "also drop columns, indexes and constraints the models don't declare")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("generate: expected a migration name")
+	}
+
+	target := db
+	if *fromMigrations {
+		scratch, cleanup, err := migrationState(ctx, db, dsn, dir)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		target = scratch
+	}
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: target}), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := models.SetupJoinTable(gormDB); err != nil {
+		return err
+	}
+
+	report, err := drift.Check(ctx, gormDB, models.All()...)
+	if err != nil {
+		return err
+	}
+	plan, err := migrate.NewPlan(gormDB, report, *includeExtras)
+	if err != nil {
+		return err
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Println("models match the schema; nothing to generate")
+		return nil
+	}
+
+	up, down, err := migrate.Generate(dir, flags.Arg(0), plan)
+	if err != nil {
+		return err
+	}
+	fmt.Println("created", up)
+	fmt.Println("created", down)
+
+	for _, c := range plan.NeedsReview() {
+		fmt.Printf("review: %s: %s\n", c.Description, c.Review)
+	}
+	return nil
+}
+
+func reconcileIndexes(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("indexes", flag.ContinueOnError)
+	reportOnly := flags.Bool("report-only", false, "only report unused and duplicate indexes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: db}), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if !*reportOnly {
+		results, err := indexes.Reconcile(ctx, gormDB, models.All()...)
+		for _, r := range results {
+			if r.Action != indexes.Unchanged {
+				fmt.Printf("%s %s %s on %s\n", r.Action, r.Kind, r.Name, r.Table)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d declared indexes and constraints in place\n", len(results))
+	}
+
+	usage, err := indexes.Usage(ctx, gormDB)
+	if err != nil {
+		return err
+	}
+	return usage.Print(os.Stdout)
+}
+
+// migrationState applies the migrations in dir to a scratch schema and
+// returns a connection to it. cleanup drops the schema.
+func migrationState(ctx context.Context, db *sql.DB, dsn, dir string) (*sql.DB, func(), error) {
+	schema := fmt.Sprintf("migrate_generate_%d", time.Now().UnixNano())
+	if _, err := db.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		return nil, nil, fmt.Errorf("failed to create scratch schema: %w", err)
+	}
+
+	scratch, err := sql.Open("pgx", dsn+" search_path="+schema)
+	cleanup := func() {
+		if scratch != nil {
+			scratch.Close()
+		}
+		_, _ = db.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to open scratch schema: %w", err)
+	}
+
+	m, err := migrate.New(scratch, os.DirFS(dir))
+	if err == nil {
+		_, err = m.Up(ctx)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return scratch, cleanup, nil
+}
+
+func report(verb string, changed []migrate.Migration) {
+	if len(changed) == 0 {
+		fmt.Println("no change")
+		return
+	}
+	for _, mig := range changed {
+		fmt.Printf("%s %05d_%s\n", verb, mig.Version, mig.Name)
+	}
+}
+
+func printStatus(statuses []migrate.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		if s.Missing {
+			applied += " (missing file)"
+		}
+		fmt.Fprintf(w, "%05d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+}
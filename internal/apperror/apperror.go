@@ -0,0 +1,169 @@
+// Package apperror defines the error taxonomy shared by the repository,
+// service and handler layers.
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Kind groups errors by how a client should react to them. Each kind maps to
+// exactly one HTTP status.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindInvalidInput
+	KindTimeout
+	KindPreconditionFailed
+)
+
+// HTTPStatus returns the HTTP status code used to report errors of this kind
+func (k Kind) HTTPStatus() int {
+	switch k {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindValidation:
+		return http.StatusUnprocessableEntity
+	case KindInvalidInput:
+		return http.StatusBadRequest
+	case KindTimeout:
+		return http.StatusGatewayTimeout
+	case KindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Code is a stable, machine-readable error identifier. Clients may switch on
+// it, so existing values must never be renamed.
+type Code string
+
+const (
+	CodeInternal           Code = "internal_error"
+	CodeNotFound           Code = "not_found"
+	CodeUserNotFound       Code = "user_not_found"
+	CodePostNotFound       Code = "post_not_found"
+	CodePreferenceNotFound Code = "preference_not_found"
+	CodeConflict           Code = "conflict"
+	CodeDuplicateEmail     Code = "duplicate_email"
+	CodeDuplicateUsername  Code = "duplicate_username"
+	CodeValidation         Code = "validation_failed"
+	CodeInvalidInput       Code = "invalid_input"
+	CodeTimeout            Code = "timeout"
+	CodePreconditionFailed Code = "precondition_failed"
+	CodeVersionConflict    Code = "version_conflict"
+	CodeTenantRequired     Code = "tenant_required"
+	CodeInvalidTenant      Code = "invalid_tenant"
+	CodeUnknownEntity      Code = "unknown_entity"
+	CodeNotInTrash         Code = "not_in_trash"
+	CodeParentDeleted      Code = "parent_deleted"
+	CodeReferenced         Code = "referenced"
+)
+
+// kindCodes holds the generic code of each kind. A sentinel carrying a
+// generic code matches every error of its kind in errors.Is.
+var kindCodes = map[Kind]Code{
+	KindInternal:           CodeInternal,
+	KindNotFound:           CodeNotFound,
+	KindConflict:           CodeConflict,
+	KindValidation:         CodeValidation,
+	KindInvalidInput:       CodeInvalidInput,
+	KindTimeout:            CodeTimeout,
+	KindPreconditionFailed: CodePreconditionFailed,
+}
+
+// Generic sentinels, one per kind
+var (
+	ErrInternal           = New(KindInternal, CodeInternal, "internal server error")
+	ErrNotFound           = New(KindNotFound, CodeNotFound, "resource not found")
+	ErrConflict           = New(KindConflict, CodeConflict, "resource already exists")
+	ErrValidation         = New(KindValidation, CodeValidation, "validation failed")
+	ErrInvalidInput       = New(KindInvalidInput, CodeInvalidInput, "invalid request body")
+	ErrTimeout            = New(KindTimeout, CodeTimeout, "operation timed out")
+	ErrPreconditionFailed = New(KindPreconditionFailed, CodePreconditionFailed, "precondition failed")
+)
+
+// Domain sentinels
+var (
+	ErrUserNotFound       = New(KindNotFound, CodeUserNotFound, "user not found")
+	ErrPostNotFound       = New(KindNotFound, CodePostNotFound, "post not found")
+	ErrPreferenceNotFound = New(KindNotFound, CodePreferenceNotFound, "preference not found")
+	ErrDuplicateEmail     = New(KindConflict, CodeDuplicateEmail, "email already exists")
+	ErrDuplicateUsername  = New(KindConflict, CodeDuplicateUsername, "username already exists")
+	ErrTenantRequired     = New(KindInvalidInput, CodeTenantRequired, "tenant is required")
+	ErrInvalidTenant      = New(KindInvalidInput, CodeInvalidTenant, "invalid tenant")
+	ErrVersionConflict    = New(KindConflict, CodeVersionConflict, "record was modified by another request")
+	ErrUnknownEntity      = New(KindNotFound, CodeUnknownEntity, "unknown entity")
+	ErrNotInTrash         = New(KindNotFound, CodeNotInTrash, "record is not in the trash")
+	ErrParentDeleted      = New(KindConflict, CodeParentDeleted, "record belongs to a deleted record; restore that first")
+	ErrReferenced         = New(KindConflict, CodeReferenced, "record is still referenced by other records")
+)
+
+// FieldError describes a single invalid input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is the application error type. Message is safe to show to clients;
+// Err holds the underlying cause and is only meant for logs.
+type Error struct {
+	Kind    Kind
+	Code    Code
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// New creates an error without an underlying cause
+func New(kind Kind, code Code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the same sentinel as e, or the generic
+// sentinel of e's kind (so ErrUserNotFound also matches ErrNotFound).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	if t.Code == e.Code {
+		return true
+	}
+	return t.Kind == e.Kind && t.Code == kindCodes[t.Kind]
+}
+
+// Wrap returns a copy of e that carries err as its cause
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// As returns the *Error in err's chain. Errors outside the taxonomy are
+// reported as internal errors.
+func As(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return ErrInternal.Wrap(err)
+}
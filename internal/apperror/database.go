@@ -0,0 +1,68 @@
+package apperror
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// SQLSTATEs Postgres reports for constraint violations
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// FromDB converts GORM and driver errors into the taxonomy. notFound is
+// returned for gorm.ErrRecordNotFound so each repository can report which
+// entity was missing. Errors that are already part of the taxonomy are
+// returned unchanged.
+func FromDB(err error, notFound *Error) error {
+	if err == nil {
+		return nil
+	}
+
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return notFound
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout.Wrap(err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			return duplicateKey(pgErr.ConstraintName, err)
+		case pgForeignKeyViolation:
+			return ErrReferenced.Wrap(err)
+		}
+	}
+
+	// Fall back to message matching for drivers that don't expose SQLSTATE
+	if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+		return duplicateKey(err.Error(), err)
+	}
+
+	return ErrInternal.Wrap(err)
+}
+
+// duplicateKey picks the conflict sentinel for the violated constraint
+func duplicateKey(constraint string, err error) error {
+	switch {
+	case strings.Contains(constraint, "email"):
+		return ErrDuplicateEmail.Wrap(err)
+	case strings.Contains(constraint, "username"):
+		return ErrDuplicateUsername.Wrap(err)
+	default:
+		return ErrConflict.Wrap(err)
+	}
+}
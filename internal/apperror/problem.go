@@ -0,0 +1,35 @@
+package apperror
+
+import "net/http"
+
+// ProblemContentType is the media type defined by RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Code and Errors are
+// extension members carrying the stable error code and per-field details.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     Code         `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem builds the problem details for err. The underlying cause is
+// never included, so internal errors don't leak to clients.
+func NewProblem(err error, instance string) Problem {
+	appErr := As(err)
+	status := appErr.Kind.HTTPStatus()
+
+	return Problem{
+		Type:     "/problems/" + string(appErr.Code),
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   appErr.Message,
+		Instance: instance,
+		Code:     appErr.Code,
+		Errors:   appErr.Fields,
+	}
+}
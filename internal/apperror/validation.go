@@ -0,0 +1,54 @@
+package apperror
+
+import (
+	"errors"
+	"sort"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Validation converts the result of an ozzo-validation call into a
+// validation error with one FieldError per invalid field. Nested errors are
+// flattened into dotted paths such as "profile.website".
+func Validation(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var internal validation.InternalError
+	if errors.As(err, &internal) {
+		return ErrInternal.Wrap(internal.InternalError())
+	}
+
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return ErrValidation.Wrap(err)
+	}
+
+	appErr := ErrValidation.Wrap(err)
+	appErr.Fields = flattenFieldErrors("", errs)
+	return appErr
+}
+
+func flattenFieldErrors(prefix string, errs validation.Errors) []FieldError {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields []FieldError
+	for _, key := range keys {
+		field := key
+		if prefix != "" {
+			field = prefix + "." + key
+		}
+
+		if nested, ok := errs[key].(validation.Errors); ok {
+			fields = append(fields, flattenFieldErrors(field, nested)...)
+			continue
+		}
+		fields = append(fields, FieldError{Field: field, Message: errs[key].Error()})
+	}
+	return fields
+}
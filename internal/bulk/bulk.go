@@ -0,0 +1,121 @@
+// Package bulk reads and writes users as CSV or NDJSON, one user per row, for
+// bulk imports and exports. Both directions stream: a Decoder reads one row
+// at a time and an Encoder writes each user as it is given.
+//
+// Columns are named by the JSON names of the fields of a user. Imports take
+// email, username, firstName, lastName and password; exports write id,
+// email, username, firstName, lastName, isActive, lastLoginAt, createdAt and
+// updatedAt, never the password. CSV files start with a header row naming
+// their columns, in any order; NDJSON rows are JSON objects.
+package bulk
+
+import (
+	"fmt"
+	"time"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/models"
+)
+
+// Format is the encoding of a bulk import or export
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// ParseFormat returns the format named name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case CSV, NDJSON:
+		return f, nil
+	default:
+		return "", apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "format must be csv or ndjson")
+	}
+}
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// RowError is a row that can't be decoded. The rows after it can still be
+// read.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// importRecord is a user as imported
+type importRecord struct {
+	Email     *string `json:"email"`
+	Username  *string `json:"username"`
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Password  string  `json:"password"`
+}
+
+// importColumns sets the fields of an import record from CSV, by column.
+// Empty cells leave fields unset.
+var importColumns = map[string]func(r *importRecord, value string){
+	"email":     func(r *importRecord, value string) { r.Email = &value },
+	"username":  func(r *importRecord, value string) { r.Username = &value },
+	"firstName": func(r *importRecord, value string) { r.FirstName = &value },
+	"lastName":  func(r *importRecord, value string) { r.LastName = &value },
+	"password":  func(r *importRecord, value string) { r.Password = value },
+}
+
+func (r *importRecord) user() *models.NewUser {
+	return &models.NewUser{
+		Email:     r.Email,
+		Username:  r.Username,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Password:  r.Password,
+	}
+}
+
+// exportRecord is a user as exported. Its fields are in the order of the CSV
+// columns.
+type exportRecord struct {
+	ID          uint       `json:"id"`
+	Email       *string    `json:"email"`
+	Username    *string    `json:"username"`
+	FirstName   *string    `json:"firstName"`
+	LastName    *string    `json:"lastName"`
+	IsActive    bool       `json:"isActive"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// exportColumns is the CSV header of exports
+var exportColumns = []string{
+	"id", "email", "username", "firstName", "lastName", "isActive", "lastLoginAt", "createdAt", "updatedAt",
+}
+
+func newExportRecord(user *models.User) exportRecord {
+	return exportRecord{
+		ID:          user.ID,
+		Email:       user.Email,
+		Username:    user.Username,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		IsActive:    user.IsActive,
+		LastLoginAt: user.LastLoginAt,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+	}
+}
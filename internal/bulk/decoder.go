@@ -0,0 +1,153 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/models"
+)
+
+// MaxLineSize is the longest NDJSON row a Decoder reads
+const MaxLineSize = 1 << 20
+
+// Decoder reads users from CSV or NDJSON, one row at a time
+type Decoder struct {
+	format Format
+	csv    *csv.Reader
+	lines  *bufio.Scanner
+
+	// header maps the CSV columns to the fields they set, nil until read
+	header []func(r *importRecord, value string)
+	row    int
+}
+
+// NewDecoder returns a decoder reading from r in format f
+func NewDecoder(r io.Reader, f Format) *Decoder {
+	d := &Decoder{format: f}
+	if f == CSV {
+		d.csv = csv.NewReader(r)
+		d.csv.ReuseRecord = true
+	} else {
+		d.lines = bufio.NewScanner(r)
+		d.lines.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+	}
+	return d
+}
+
+// Row returns the number of the row last read. Rows are numbered from 1, not
+// counting the CSV header or blank NDJSON lines.
+func (d *Decoder) Row() int {
+	return d.row
+}
+
+// Next reads the next user. It returns io.EOF after the last row, and a
+// *RowError for a row that can't be decoded, after which reading can go on.
+// Any other error, such as a CSV header naming an unknown column, ends the
+// input.
+func (d *Decoder) Next() (*models.NewUser, error) {
+	if d.format == CSV {
+		return d.nextCSV()
+	}
+	return d.nextNDJSON()
+}
+
+func (d *Decoder) nextCSV() (*models.NewUser, error) {
+	if d.header == nil {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	fields, err := d.csv.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		d.row++
+		return nil, &RowError{Row: d.row, Err: parseErr.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.row++
+
+	var record importRecord
+	for i, value := range fields {
+		if value != "" {
+			d.header[i](&record, value)
+		}
+	}
+	return record.user(), nil
+}
+
+// readHeader reads the CSV header, which the rows must match in length
+func (d *Decoder) readHeader() error {
+	names, err := d.csv.Read()
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return apperror.ErrInvalidInput.Wrap(err)
+	}
+
+	header := make([]func(r *importRecord, value string), len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if i == 0 {
+			// Spreadsheets save UTF-8 with a byte order mark
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		set, ok := importColumns[name]
+		if !ok {
+			return apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput,
+				fmt.Sprintf("unknown column %q", name))
+		}
+		if seen[name] {
+			return apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput,
+				fmt.Sprintf("duplicate column %q", name))
+		}
+		seen[name] = true
+		header[i] = set
+	}
+	d.header = header
+	return nil
+}
+
+func (d *Decoder) nextNDJSON() (*models.NewUser, error) {
+	var line []byte
+	for len(line) == 0 {
+		if !d.lines.Scan() {
+			if errors.Is(d.lines.Err(), bufio.ErrTooLong) {
+				return nil, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput,
+					fmt.Sprintf("row %d is longer than %d bytes", d.row+1, MaxLineSize))
+			}
+			if err := d.lines.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		line = bytes.TrimSpace(d.lines.Bytes())
+	}
+	d.row++
+
+	// Unknown members are errors rather than ignored, so that misspelt ones
+	// aren't silently dropped
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	var record importRecord
+	if err := decoder.Decode(&record); err != nil {
+		return nil, &RowError{Row: d.row, Err: err}
+	}
+	if decoder.More() {
+		return nil, &RowError{Row: d.row, Err: errors.New("row holds more than one JSON value")}
+	}
+	return record.user(), nil
+}
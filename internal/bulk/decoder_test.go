@@ -0,0 +1,132 @@
+package bulk_test
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"gorm-reference/internal/bulk"
+)
+
+func TestCSVHeaderWithByteOrderMark(t *testing.T) {
+	d := bulk.NewDecoder(strings.NewReader("\ufeffemail,username\nalice@example.com,alice\n"), bulk.CSV)
+
+	user, err := d.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email == nil || *user.Email != "alice@example.com" {
+		t.Errorf("Email = %v, want alice@example.com", user.Email)
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Next after the last row = %v, want io.EOF", err)
+	}
+}
+
+func TestCSVHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"unknown column", "email,nickname", `unknown column "nickname"`},
+		{"duplicate column", "email,username,email", `duplicate column "email"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := bulk.NewDecoder(strings.NewReader(tt.header+"\nalice@example.com,alice\n"), bulk.CSV)
+
+			_, err := d.Next()
+			var rowErr *bulk.RowError
+			if err == nil || errors.As(err, &rowErr) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Next error = %v, want an error ending the input with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVRowsOfTheWrongLengthAreSkipped(t *testing.T) {
+	d := bulk.NewDecoder(strings.NewReader("email,username\na@example.com,a\nb@example.com\nc@example.com,c\n"), bulk.CSV)
+
+	if _, err := d.Next(); err != nil {
+		t.Fatalf("row 1: %v", err)
+	}
+
+	_, err := d.Next()
+	var rowErr *bulk.RowError
+	if !errors.As(err, &rowErr) || rowErr.Row != 2 || !errors.Is(err, csv.ErrFieldCount) {
+		t.Fatalf("row 2 error = %v, want a RowError for row 2 wrapping csv.ErrFieldCount", err)
+	}
+
+	user, err := d.Next()
+	if err != nil || user.Email == nil || *user.Email != "c@example.com" || d.Row() != 3 {
+		t.Fatalf("row after the short one = %v, %v at row %d", user, err, d.Row())
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Next after the last row = %v, want io.EOF", err)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	input := strings.Join([]string{
+		`{"email":"a@example.com","firstName":"Ann","password":"secret"}`,
+		``,
+		`{"email":"b@example.com","nickname":"bee"}`,
+		`{"email":"c@example.com"} {"email":"d@example.com"}`,
+		`not json`,
+		`{"email":"e@example.com"}`,
+	}, "\n")
+	d := bulk.NewDecoder(strings.NewReader(input), bulk.NDJSON)
+
+	user, err := d.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *user.Email != "a@example.com" || *user.FirstName != "Ann" || user.Password != "secret" {
+		t.Errorf("row 1 = %+v", user)
+	}
+
+	// Blank lines aren't rows, so the failures are rows 2 to 4
+	for row := 2; row <= 4; row++ {
+		_, err := d.Next()
+		var rowErr *bulk.RowError
+		if !errors.As(err, &rowErr) || rowErr.Row != row {
+			t.Errorf("row %d error = %v, want a RowError", row, err)
+		}
+	}
+
+	user, err = d.Next()
+	if err != nil || *user.Email != "e@example.com" || d.Row() != 5 {
+		t.Errorf("row after the failures = %v, %v at row %d", user, err, d.Row())
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Next after the last row = %v, want io.EOF", err)
+	}
+}
+
+func TestNDJSONUnknownMember(t *testing.T) {
+	d := bulk.NewDecoder(strings.NewReader(`{"email":"a@example.com","emial":"typo"}`), bulk.NDJSON)
+
+	_, err := d.Next()
+	var rowErr *bulk.RowError
+	if !errors.As(err, &rowErr) || !strings.Contains(err.Error(), `unknown field "emial"`) {
+		t.Errorf("Next error = %v, want a RowError naming the unknown member", err)
+	}
+}
+
+func TestNDJSONLineTooLong(t *testing.T) {
+	long := `{"email":"` + strings.Repeat("a", bulk.MaxLineSize) + `"}`
+	d := bulk.NewDecoder(strings.NewReader(`{"email":"a@example.com"}`+"\n"+long+"\n"), bulk.NDJSON)
+
+	if _, err := d.Next(); err != nil {
+		t.Fatalf("row 1: %v", err)
+	}
+
+	_, err := d.Next()
+	var rowErr *bulk.RowError
+	if err == nil || errors.As(err, &rowErr) || !strings.Contains(err.Error(), "row 2 is longer than") {
+		t.Errorf("Next error = %v, want an error ending the input at row 2", err)
+	}
+}
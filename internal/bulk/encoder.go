@@ -0,0 +1,94 @@
+package bulk
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm-reference/internal/models"
+)
+
+// Encoder writes users as CSV or NDJSON
+type Encoder struct {
+	format Format
+	csv    *csv.Writer
+	json   *json.Encoder
+
+	// wroteHeader is whether the CSV header is written. It is written with
+	// the first row, so that nothing is written before there is something
+	// to write.
+	wroteHeader bool
+}
+
+// NewEncoder returns an encoder writing to w in format f. Call Flush after
+// the last user.
+func NewEncoder(w io.Writer, f Format) *Encoder {
+	e := &Encoder{format: f}
+	if f == CSV {
+		e.csv = csv.NewWriter(w)
+	} else {
+		e.json = json.NewEncoder(w)
+	}
+	return e
+}
+
+// Encode writes a user
+func (e *Encoder) Encode(user *models.User) error {
+	record := newExportRecord(user)
+	if e.format != CSV {
+		return e.json.Encode(record)
+	}
+
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.csv.Write([]string{
+		strconv.FormatUint(uint64(record.ID), 10),
+		deref(record.Email),
+		deref(record.Username),
+		deref(record.FirstName),
+		deref(record.LastName),
+		strconv.FormatBool(record.IsActive),
+		formatTime(record.LastLoginAt),
+		formatTime(&record.CreatedAt),
+		formatTime(&record.UpdatedAt),
+	})
+}
+
+// Flush writes what is buffered, and the CSV header of an export without
+// rows
+func (e *Encoder) Flush() error {
+	if e.format != CSV {
+		return nil
+	}
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+func (e *Encoder) writeHeader() error {
+	if e.wroteHeader {
+		return nil
+	}
+	e.wroteHeader = true
+	return e.csv.Write(exportColumns)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// formatTime formats t as in JSON, or "" for nil
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,87 @@
+package bulk_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm-reference/internal/bulk"
+	"gorm-reference/internal/models"
+)
+
+func TestCSVEncoder(t *testing.T) {
+	var out strings.Builder
+	e := bulk.NewEncoder(&out, bulk.CSV)
+
+	if err := e.Encode(exportedUser()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id,email,username,firstName,lastName,isActive,lastLoginAt,createdAt,updatedAt\n" +
+		"7,alice@example.com,alice,\"Alice, Jr\",,true,,2024-05-01T12:00:00Z,2024-05-01T12:00:00Z\n"
+	if out.String() != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCSVEncoderWritesHeaderWithoutRows(t *testing.T) {
+	var out strings.Builder
+	e := bulk.NewEncoder(&out, bulk.CSV)
+
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,email,username,firstName,lastName,isActive,lastLoginAt,createdAt,updatedAt\n"; out.String() != want {
+		t.Errorf("CSV = %q, want only the header", out.String())
+	}
+}
+
+func TestNDJSONEncoder(t *testing.T) {
+	var out strings.Builder
+	e := bulk.NewEncoder(&out, bulk.NDJSON)
+
+	for range 2 {
+		if err := e.Encode(exportedUser()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, want 2:\n%s", len(lines), out.String())
+	}
+	var row map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+		t.Fatal(err)
+	}
+	if row["email"] != "alice@example.com" || row["id"] != float64(7) || row["lastName"] != nil {
+		t.Errorf("row = %v", row)
+	}
+	if _, ok := row["password"]; ok {
+		t.Error("export holds the password")
+	}
+}
+
+func exportedUser() *models.User {
+	email, username, firstName := "alice@example.com", "alice", "Alice, Jr"
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	user := &models.User{
+		Email:        &email,
+		Username:     &username,
+		FirstName:    &firstName,
+		IsActive:     true,
+		PasswordHash: "hash",
+	}
+	user.ID = 7
+	user.CreatedAt = created
+	user.UpdatedAt = created
+	return user
+}
@@ -0,0 +1,164 @@
+// Package cache keeps query results close to the application. Store is the
+// storage interface, with an in-memory LRU as the default implementation;
+// Cache adds cache-aside loading on top of any Store, with concurrent misses
+// for the same key sharing one load.
+//
+// Values are stored JSON encoded, so that external stores can hold them and
+// every caller decodes a copy of its own. A Sealer encrypts them on the way
+// to the store, so that personal data decrypted from the database is never
+// kept in the clear outside the process.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"gorm-reference/internal/logging"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var logger = logging.For("cache")
+
+// Store holds encoded values under string keys. Implementations must be safe
+// for concurrent use. A ttl of zero means the value never expires, though
+// the store may still evict it.
+type Store interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Sealer encrypts the values of a Cache before they reach its store. The
+// cache key is passed along so that a value only opens under the key it was
+// stored under.
+type Sealer interface {
+	Seal(value []byte, key string) ([]byte, error)
+	Open(sealed []byte, key string) ([]byte, error)
+}
+
+// Cache loads values through a Store. Store failures are logged and treated
+// as misses, so an unavailable store slows reads down but never fails them.
+// A nil *Cache caches nothing.
+type Cache struct {
+	store  Store
+	sealer Sealer
+	group  singleflight.Group
+}
+
+// New returns a cache backed by store whose values are sealed by sealer. A
+// nil sealer stores them as they are, which only suits stores that hold
+// nothing sensitive.
+func New(store Store, sealer Sealer) *Cache {
+	return &Cache{store: store, sealer: sealer}
+}
+
+// Load returns the value cached under key, or calls load and caches its
+// result for ttl. Concurrent misses for the same key wait for a single call
+// to load, which runs with the context of the first caller. Errors are not
+// cached. With a nil cache or a ttl of zero, Load just calls load.
+func Load[T any](ctx context.Context, c *Cache, key string, ttl time.Duration, load func(context.Context) (T, error)) (T, error) {
+	if c == nil || ttl <= 0 {
+		return load(ctx)
+	}
+
+	var value T
+	if data, ok := c.get(ctx, key); ok {
+		if err := json.Unmarshal(data, &value); err == nil {
+			return value, nil
+		}
+		logger.WarnContext(ctx, "dropping undecodable cache entry", "key", key)
+	}
+
+	data, err, _ := c.group.Do(key, func() (any, error) {
+		loaded, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(loaded)
+		if err != nil {
+			return nil, err
+		}
+		c.set(ctx, key, data, ttl)
+		return data, nil
+	})
+	if err != nil {
+		return value, err
+	}
+
+	// Every caller decodes its own copy of the shared result
+	err = json.Unmarshal(data.([]byte), &value)
+	return value, err
+}
+
+// Delete removes keys, e.g. after the rows they were loaded from changed
+func (c *Cache) Delete(ctx context.Context, keys ...string) {
+	if c == nil {
+		return
+	}
+	if err := c.store.Delete(ctx, keys...); err != nil {
+		logger.WarnContext(ctx, "failed to invalidate cache", "keys", keys, "error", err)
+	}
+}
+
+// Generation returns the current generation of name. Keys that include it
+// are all invalidated at once by Invalidate, which suits cached lists that
+// any write may change.
+func (c *Cache) Generation(ctx context.Context, name string) string {
+	if c == nil {
+		return ""
+	}
+
+	key := generationKey(name)
+	if data, ok := c.get(ctx, key); ok {
+		return string(data)
+	}
+
+	// A fresh value rather than a counter, so a generation that was evicted
+	// never comes back with stale entries still keyed under it
+	gen := strconv.FormatInt(time.Now().UnixNano(), 36)
+	c.set(ctx, key, []byte(gen), 0)
+	return gen
+}
+
+// Invalidate starts a new generation of name
+func (c *Cache) Invalidate(ctx context.Context, name string) {
+	c.Delete(ctx, generationKey(name))
+}
+
+func (c *Cache) get(ctx context.Context, key string) ([]byte, bool) {
+	data, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to read cache", "key", key, "error", err)
+		return nil, false
+	}
+	if !ok || c.sealer == nil {
+		return data, ok
+	}
+
+	data, err = c.sealer.Open(data, key)
+	if err != nil {
+		logger.WarnContext(ctx, "dropping unopenable cache entry", "key", key, "error", err)
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *Cache) set(ctx context.Context, key string, data []byte, ttl time.Duration) {
+	if c.sealer != nil {
+		var err error
+		if data, err = c.sealer.Seal(data, key); err != nil {
+			logger.WarnContext(ctx, "failed to seal cache entry", "key", key, "error", err)
+			return
+		}
+	}
+	if err := c.store.Set(ctx, key, data, ttl); err != nil {
+		logger.WarnContext(ctx, "failed to fill cache", "key", key, "error", err)
+	}
+}
+
+func generationKey(name string) string {
+	return "generation:" + name
+}
@@ -0,0 +1,185 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm-reference/internal/cache"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	lru := cache.NewLRU(2)
+
+	set(t, lru, "a", 0)
+	set(t, lru, "b", 0)
+	// Reading a makes b the least recently used
+	if _, ok, _ := lru.Get(ctx, "a"); !ok {
+		t.Fatal("a missing before eviction")
+	}
+	set(t, lru, "c", 0)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := lru.Get(ctx, key); ok != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, ok, want)
+		}
+	}
+	if n := lru.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	lru := cache.NewLRU(10)
+
+	set(t, lru, "short", 10*time.Millisecond)
+	set(t, lru, "forever", 0)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok, _ := lru.Get(ctx, "short"); ok {
+		t.Error("expired entry still returned")
+	}
+	if _, ok, _ := lru.Get(ctx, "forever"); !ok {
+		t.Error("entry without a ttl expired")
+	}
+	if n := lru.Len(); n != 1 {
+		t.Errorf("Len() = %d after reading the expired entry, want 1", n)
+	}
+}
+
+func TestGenerationChangesOnInvalidate(t *testing.T) {
+	ctx := context.Background()
+	c := cache.New(cache.NewLRU(10), nil)
+
+	first := c.Generation(ctx, "users")
+	if again := c.Generation(ctx, "users"); again != first {
+		t.Fatalf("generation changed without Invalidate: %q, then %q", first, again)
+	}
+	if other := c.Generation(ctx, "posts"); other == "" {
+		t.Fatal("empty generation")
+	}
+
+	c.Invalidate(ctx, "users")
+	if next := c.Generation(ctx, "users"); next == first {
+		t.Errorf("generation %q survived Invalidate", first)
+	}
+}
+
+func TestLoadSharesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	c := cache.New(cache.NewLRU(10), nil)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (string, error) {
+		calls.Add(1)
+		<-release
+		return "value", nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make([]string, callers)
+	errs := make([]error, callers)
+	for i := range callers {
+		wg.Go(func() {
+			results[i], errs[i] = cache.Load(ctx, c, "key", time.Minute, load)
+		})
+	}
+	// Give every caller time to join the first one's load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("load called %d times for concurrent misses, want 1", n)
+	}
+	for i := range callers {
+		if errs[i] != nil || results[i] != "value" {
+			t.Errorf("caller %d got %q, %v", i, results[i], errs[i])
+		}
+	}
+
+	// The result is cached now
+	value, err := cache.Load(ctx, c, "key", time.Minute, func(context.Context) (string, error) {
+		return "", errors.New("cached value not used")
+	})
+	if err != nil || value != "value" {
+		t.Errorf("Load after fill = %q, %v", value, err)
+	}
+}
+
+func TestLoadDoesNotCacheErrors(t *testing.T) {
+	ctx := context.Background()
+	c := cache.New(cache.NewLRU(10), nil)
+
+	failure := errors.New("database down")
+	_, err := cache.Load(ctx, c, "key", time.Minute, func(context.Context) (int, error) {
+		return 0, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Load error = %v, want %v", err, failure)
+	}
+
+	value, err := cache.Load(ctx, c, "key", time.Minute, func(context.Context) (int, error) {
+		return 42, nil
+	})
+	if err != nil || value != 42 {
+		t.Errorf("Load after an error = %d, %v, want 42", value, err)
+	}
+}
+
+func TestSealedValues(t *testing.T) {
+	ctx := context.Background()
+	store := cache.NewLRU(10)
+	c := cache.New(store, tagged{})
+
+	value, err := cache.Load(ctx, c, "key", time.Minute, func(context.Context) (string, error) {
+		return "secret", nil
+	})
+	if err != nil || value != "secret" {
+		t.Fatalf("Load = %q, %v", value, err)
+	}
+	stored, _, _ := store.Get(ctx, "key")
+	if string(stored) != `sealed:key:"secret"` {
+		t.Errorf("store holds %q, want the sealed value", stored)
+	}
+
+	// An entry that doesn't open is a miss
+	set(t, store, "key", 0)
+	value, err = cache.Load(ctx, c, "key", time.Minute, func(context.Context) (string, error) {
+		return "reloaded", nil
+	})
+	if err != nil || value != "reloaded" {
+		t.Errorf("Load of an unopenable entry = %q, %v, want a reload", value, err)
+	}
+}
+
+// tagged is a Sealer that prefixes values with their key, which is enough to
+// tell sealed values from bare ones
+type tagged struct{}
+
+func (tagged) Seal(value []byte, key string) ([]byte, error) {
+	return append([]byte("sealed:"+key+":"), value...), nil
+}
+
+func (tagged) Open(sealed []byte, key string) ([]byte, error) {
+	value, ok := bytes.CutPrefix(sealed, []byte("sealed:"+key+":"))
+	if !ok {
+		return nil, errors.New("not sealed under " + key)
+	}
+	return value, nil
+}
+
+func set(t *testing.T, lru *cache.LRU, key string, ttl time.Duration) {
+	t.Helper()
+	if err := lru.Set(context.Background(), key, []byte(key), ttl); err != nil {
+		t.Fatalf("Set(%q): %v", key, err)
+	}
+}
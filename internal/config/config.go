@@ -1,11 +1,28 @@
-// Package config provides application configuration structures.
+// Package config provides application configuration structures. Load builds
+// a Config from defaults, an optional YAML file, an optional .env file and
+// the environment; callers pass it on explicitly.
 package config
 
-import "time"
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
 
+// Config is the application configuration. Print it with String, which
+// redacts secrets.
 type Config struct {
-	App appConfig
-	DB  dbConfig
+	App        appConfig
+	DB         dbConfig
+	Health     healthConfig
+	Cache      cacheConfig
+	Trash      trashConfig
+	Encryption encryptionConfig
+	Tenant     tenantConfig
+	Tracing    tracingConfig
+	Log        logConfig
 }
 
 type appConfig struct {
@@ -25,4 +42,133 @@ type dbConfig struct {
 	MaxOpenConns    int
 	MaxIdleTime     time.Duration
 	MaxConnLifetime time.Duration
+
+	// SlowQueryThreshold is the duration above which statements are logged
+	SlowQueryThreshold time.Duration
+	// ExplainSlowQueries records the plan of every slow statement
+	ExplainSlowQueries bool
+
+	// Replicas are the "host" or "host:port" addresses of read replicas.
+	// They share the primary's user, password, database and SSL mode.
+	Replicas []string
+	// ReplicaCheckInterval is how often replicas are health checked
+	ReplicaCheckInterval time.Duration
+
+	// Cascade overrides the delete policies the models declare, by model
+	// and association, e.g. {"User.Comments": "anonymize"}
+	Cascade map[string]string
+}
+
+type healthConfig struct {
+	// Timeout bounds each readiness check
+	Timeout time.Duration
+	// CacheTTL is how long a readiness report is reused
+	CacheTTL time.Duration
+	// MaxPoolUsage is the share of MaxOpenConns in use above which the
+	// instance reports itself not ready
+	MaxPoolUsage float64
+	// MaxReplicaLag is the replication delay above which a replica is not ready
+	MaxReplicaLag time.Duration
+}
+
+type cacheConfig struct {
+	// Size is the number of entries the in-memory cache holds. Zero
+	// disables caching.
+	Size int
+	// UserTTL is how long users are cached. Zero disables it.
+	UserTTL time.Duration
+	// PostTTL is how long post listings are cached. Zero disables it.
+	PostTTL time.Duration
+}
+
+type trashConfig struct {
+	// Retention is how long deleted records stay in the trash before they
+	// are purged. Zero keeps them until purged by hand.
+	Retention time.Duration
+	// PurgeInterval is how often expired records are purged
+	PurgeInterval time.Duration
+}
+
+type encryptionConfig struct {
+	// Keys are the base64 AES-256 keys that encrypt PII columns, by ID. Keys
+	// that are no longer primary stay until re-encryption has moved every
+	// value off them.
+	Keys map[string]string
+	// PrimaryKey is the ID of the key that encrypts new values
+	PrimaryKey string
+	// IndexKey is the base64 HMAC key of blind indexes. It can't be changed
+	// without recomputing every blind index.
+	IndexKey string
+	// ReencryptInterval is how often values are re-encrypted with the
+	// primary key. Zero disables re-encryption.
+	ReencryptInterval time.Duration
+	// ReencryptBatchSize is the number of rows re-encryption reads at once
+	ReencryptBatchSize int
+	// DevelopmentKeys fills in the keys left unset with public development
+	// keys. Production refuses it.
+	DevelopmentKeys bool
+}
+
+type tenantConfig struct {
+	// Header names the tenant of a request
+	Header string
+	// Domain is the base domain whose subdomains name tenants, e.g.
+	// example.com for acme.example.com. Empty disables subdomains.
+	Domain string
+}
+
+type tracingConfig struct {
+	// Exporter is "none", "otlp" or "file"
+	Exporter    string
+	Endpoint    string
+	File        string
+	SampleRatio float64
+}
+
+type logConfig struct {
+	// Format is "json" or "text"
+	Format string
+	Level  string
+	// Levels overrides Level per package, e.g. {"gorm": "debug"}
+	Levels map[string]string
+}
+
+// DSN returns the Postgres connection string for the database. Values are
+// quoted, so passwords may hold spaces, quotes and backslashes.
+func (c dbConfig) DSN() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quoteDSN(c.Host), c.Port, quoteDSN(c.User), quoteDSN(c.Password), quoteDSN(c.Name), quoteDSN(c.SSLMode),
+	)
+}
+
+// dsnEscaper escapes the quotes and backslashes of connection string values
+var dsnEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// quoteDSN quotes a value of a key=value connection string
+func quoteDSN(v string) string {
+	return "'" + dsnEscaper.Replace(v) + "'"
+}
+
+// ReplicaDSN returns the connection string of the replica at addr, a "host"
+// or "host:port" entry of Replicas. The port defaults to the primary's.
+func (c dbConfig) ReplicaDSN(addr string) string {
+	replica := c
+	replica.Host = addr
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		replica.Host = host
+		replica.Port, _ = strconv.Atoi(port)
+	}
+	return replica.DSN()
+}
+
+// UsesDevelopmentKeys reports whether any of the keys is a public
+// development key
+func (c encryptionConfig) UsesDevelopmentKeys() bool {
+	for _, key := range c.Keys {
+		if key == developmentKey {
+			return true
+		}
+	}
+	return c.IndexKey == developmentIndexKey
 }
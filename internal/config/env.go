@@ -1,61 +1,234 @@
 package config
 
 import (
-	"log"
-	"os"
+	"fmt"
+	"log/slog"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
-var Envs = initConfig()
+// setting binds one configuration key to a field of Config. Keys are the
+// environment variable names; YAML files use the same names, lower-cased and
+// nested on the first underscore (db: {max_open_conns: 10}).
+type setting struct {
+	key    string
+	secret bool
+	set    func(string) error
+	get    func() string
+}
+
+// settings returns the bindings of every key to the fields of c
+func settings(c *Config) []setting {
+	return []setting{
+		stringSetting("APP_ENV", &c.App.Env),
+		intSetting("APP_PORT", &c.App.Port),
+		stringSetting("APP_VERSION", &c.App.Version),
+
+		stringSetting("DB_HOST", &c.DB.Host),
+		intSetting("DB_PORT", &c.DB.Port),
+		stringSetting("DB_USER", &c.DB.User),
+		secretSetting("DB_PASSWORD", &c.DB.Password),
+		stringSetting("DB_NAME", &c.DB.Name),
+		stringSetting("DB_SSL_MODE", &c.DB.SSLMode),
+		intSetting("DB_MAX_IDLE_CONNS", &c.DB.MaxIdleConns),
+		intSetting("DB_MAX_OPEN_CONNS", &c.DB.MaxOpenConns),
+		durationSetting("DB_MAX_IDLE_TIME", &c.DB.MaxIdleTime),
+		durationSetting("DB_MAX_CONN_LIFETIME", &c.DB.MaxConnLifetime),
+		durationSetting("DB_SLOW_QUERY_THRESHOLD", &c.DB.SlowQueryThreshold),
+		boolSetting("DB_EXPLAIN_SLOW_QUERIES", &c.DB.ExplainSlowQueries),
+		listSetting("DB_REPLICAS", &c.DB.Replicas),
+		durationSetting("DB_REPLICA_CHECK_INTERVAL", &c.DB.ReplicaCheckInterval),
+		mapSetting("DB_CASCADE", &c.DB.Cascade),
+
+		durationSetting("HEALTH_TIMEOUT", &c.Health.Timeout),
+		durationSetting("HEALTH_CACHE_TTL", &c.Health.CacheTTL),
+		floatSetting("HEALTH_MAX_POOL_USAGE", &c.Health.MaxPoolUsage),
+		durationSetting("HEALTH_MAX_REPLICA_LAG", &c.Health.MaxReplicaLag),
+
+		intSetting("CACHE_SIZE", &c.Cache.Size),
+		durationSetting("CACHE_USER_TTL", &c.Cache.UserTTL),
+		durationSetting("CACHE_POST_TTL", &c.Cache.PostTTL),
+
+		durationSetting("TRASH_RETENTION", &c.Trash.Retention),
+		durationSetting("TRASH_PURGE_INTERVAL", &c.Trash.PurgeInterval),
+
+		secretMapSetting("ENCRYPTION_KEYS", &c.Encryption.Keys),
+		stringSetting("ENCRYPTION_PRIMARY_KEY", &c.Encryption.PrimaryKey),
+		secretSetting("ENCRYPTION_INDEX_KEY", &c.Encryption.IndexKey),
+		durationSetting("ENCRYPTION_REENCRYPT_INTERVAL", &c.Encryption.ReencryptInterval),
+		intSetting("ENCRYPTION_REENCRYPT_BATCH_SIZE", &c.Encryption.ReencryptBatchSize),
+		boolSetting("ENCRYPTION_DEVELOPMENT_KEYS", &c.Encryption.DevelopmentKeys),
+
+		stringSetting("TENANT_HEADER", &c.Tenant.Header),
+		stringSetting("TENANT_DOMAIN", &c.Tenant.Domain),
+
+		stringSetting("TRACING_EXPORTER", &c.Tracing.Exporter),
+		stringSetting("TRACING_ENDPOINT", &c.Tracing.Endpoint),
+		stringSetting("TRACING_FILE", &c.Tracing.File),
+		floatSetting("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio),
 
-func initConfig() *Config {
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("failed to load .env file")
+		stringSetting("LOG_FORMAT", &c.Log.Format),
+		stringSetting("LOG_LEVEL", &c.Log.Level),
+		mapSetting("LOG_LEVELS", &c.Log.Levels),
 	}
+}
+
+func stringSetting(key string, field *string) setting {
+	return setting{
+		key: key,
+		set: func(v string) error { *field = v; return nil },
+		get: func() string { return *field },
+	}
+}
+
+func secretSetting(key string, field *string) setting {
+	s := stringSetting(key, field)
+	s.secret = true
+	return s
+}
 
-	return &Config{
-		App: appConfig{
-			Env:     getEnv("APP_ENV", "development"),
-			Port:    getEnvAtInt("APP_PORT", 4000),
-			Version: getEnv("APP_VERSION", "1.0.0"),
+func intSetting(key string, field *int) setting {
+	return setting{
+		key: key,
+		set: func(v string) error {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("not an integer: %q", v)
+			}
+			*field = n
+			return nil
 		},
-		DB: dbConfig{
-			Host:            getEnv("DB_HOST", "localhost"),
-			Port:            getEnvAtInt("DB_PORT", 5432),
-			User:            getEnv("DB_USER", "postgres"),
-			Password:        getEnv("DB_PASSWORD", "password"),
-			Name:            getEnv("DB_NAME", "gorm"),
-			SSLMode:         getEnv("DB_SSL_MODE", "disable"),
-			MaxIdleConns:    getEnvAtInt("DB_MAX_IDLE_CONNS", 10),
-			MaxOpenConns:    getEnvAtInt("DB_MAX_OPEN_CONNS", 100),
-			MaxIdleTime:     getEnvAsDuration("DB_MAX_IDLE_TIME", 10*time.Minute),
-			MaxConnLifetime: getEnvAsDuration("DB_MAX_CONN_LIFETIME", time.Hour),
+		get: func() string { return strconv.Itoa(*field) },
+	}
+}
+
+func floatSetting(key string, field *float64) setting {
+	return setting{
+		key: key,
+		set: func(v string) error {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return fmt.Errorf("not a number: %q", v)
+			}
+			*field = f
+			return nil
 		},
+		get: func() string { return strconv.FormatFloat(*field, 'g', -1, 64) },
 	}
 }
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+func boolSetting(key string, field *bool) setting {
+	return setting{
+		key: key,
+		set: func(v string) error {
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("not a boolean: %q", v)
+			}
+			*field = b
+			return nil
+		},
+		get: func() string { return strconv.FormatBool(*field) },
+	}
+}
+
+func durationSetting(key string, field *time.Duration) setting {
+	return setting{
+		key: key,
+		set: func(v string) error {
+			d, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("not a duration such as 30s or 5m: %q", v)
+			}
+			*field = d
+			return nil
+		},
+		get: func() string { return field.String() },
+	}
+}
+
+// listSetting parses "value,value"
+func listSetting(key string, field *[]string) setting {
+	return setting{
+		key: key,
+		set: func(v string) error {
+			var list []string
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			*field = list
+			return nil
+		},
+		get: func() string { return strings.Join(*field, ",") },
+	}
+}
+
+// mapSetting parses "key=value,key=value"
+func mapSetting(key string, field *map[string]string) setting {
+	return setting{
+		key: key,
+		set: func(v string) error {
+			m := make(map[string]string)
+			for _, pair := range strings.Split(v, ",") {
+				if strings.TrimSpace(pair) == "" {
+					continue
+				}
+				k, val, ok := strings.Cut(pair, "=")
+				if !ok || strings.TrimSpace(k) == "" {
+					return fmt.Errorf("expected key=value pairs, got %q", pair)
+				}
+				m[strings.TrimSpace(k)] = strings.TrimSpace(val)
+			}
+			*field = m
+			return nil
+		},
+		get: func() string {
+			pairs := make([]string, 0, len(*field))
+			for k, v := range *field {
+				pairs = append(pairs, k+"="+v)
+			}
+			sort.Strings(pairs)
+			return strings.Join(pairs, ",")
+		},
+	}
+}
+
+func secretMapSetting(key string, field *map[string]string) setting {
+	s := mapSetting(key, field)
+	s.secret = true
+	return s
+}
+
+// redacted is the value printed in place of secrets
+const redacted = "[REDACTED]"
+
+// String lists every key with its value, one per line, with secrets
+// redacted. It is safe to log.
+func (c *Config) String() string {
+	var b strings.Builder
+	for _, s := range settings(c) {
+		fmt.Fprintf(&b, "%s=%s\n", s.key, c.display(s))
 	}
-	return fallback
+	return b.String()
 }
 
-func getEnvAtInt(key string, fallback int) int {
-	valueStr := getEnv(key, "")
-	if value, err := strconv.Atoi(valueStr); err == nil {
-		return value
+// LogValue implements slog.LogValuer with secrets redacted
+func (c *Config) LogValue() slog.Value {
+	all := settings(c)
+	attrs := make([]slog.Attr, 0, len(all))
+	for _, s := range all {
+		attrs = append(attrs, slog.String(s.key, c.display(s)))
 	}
-	return fallback
+	return slog.GroupValue(attrs...)
 }
 
-func getEnvAsDuration(key string, fallback time.Duration) time.Duration {
-	valueStr := getEnv(key, "")
-	if duration, err := time.ParseDuration(valueStr); err == nil {
-		return duration
+func (c *Config) display(s setting) string {
+	if s.secret && s.get() != "" {
+		return redacted
 	}
-	return fallback
+	return s.get()
 }
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/joho/godotenv"
+)
+
+// Options tells Load where to find the optional sources
+type Options struct {
+	// ConfigFile is a YAML file. Defaults to $CONFIG_FILE; when neither is
+	// set no file is read, but a file that is named and missing is an error.
+	ConfigFile string
+
+	// EnvFile is a dotenv file read when it exists. Defaults to ".env".
+	EnvFile string
+
+	// Environ replaces os.Environ, e.g. in tests
+	Environ []string
+}
+
+// Problem is one invalid key
+type Problem struct {
+	Key     string
+	Source  string
+	Message string
+}
+
+// ValidationError reports every invalid key found by Load
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problems):", len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  %s", p.Key)
+		if p.Source != "" {
+			fmt.Fprintf(&b, " (from %s)", p.Source)
+		}
+		fmt.Fprintf(&b, ": %s", p.Message)
+	}
+	return b.String()
+}
+
+// The encryption keys of development, used only with
+// ENCRYPTION_DEVELOPMENT_KEYS so that a checkout runs without configuring
+// any. They are public, so production refuses them.
+const (
+	developmentKey      = "a/bAUDwmxZ28b7Gkf4jXWVmYGaD4Tu+TkgFOFol94NU="
+	developmentIndexKey = "e3ywRhMeRqF5zMoJ7Y4bZkEM5zJREbD/lhOslIWS6i0="
+)
+
+// Defaults returns the configuration used when no source sets a key
+func Defaults() *Config {
+	return &Config{
+		App: appConfig{
+			Env:     "development",
+			Port:    4000,
+			Version: "1.0.0",
+		},
+		DB: dbConfig{
+			Host:                 "localhost",
+			Port:                 5432,
+			User:                 "postgres",
+			Password:             "password",
+			Name:                 "gorm",
+			SSLMode:              "disable",
+			MaxIdleConns:         10,
+			MaxOpenConns:         100,
+			MaxIdleTime:          10 * time.Minute,
+			MaxConnLifetime:      time.Hour,
+			SlowQueryThreshold:   200 * time.Millisecond,
+			ReplicaCheckInterval: 5 * time.Second,
+			Cascade:              map[string]string{},
+		},
+		Health: healthConfig{
+			Timeout:       2 * time.Second,
+			CacheTTL:      5 * time.Second,
+			MaxPoolUsage:  0.9,
+			MaxReplicaLag: 30 * time.Second,
+		},
+		Cache: cacheConfig{
+			Size:    10000,
+			UserTTL: 5 * time.Minute,
+			PostTTL: 30 * time.Second,
+		},
+		Trash: trashConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Encryption: encryptionConfig{
+			Keys:               map[string]string{},
+			ReencryptInterval:  time.Hour,
+			ReencryptBatchSize: 500,
+		},
+		Tenant: tenantConfig{
+			Header: "X-Tenant-ID",
+		},
+		Tracing: tracingConfig{
+			Exporter:    "none",
+			File:        "traces.json",
+			SampleRatio: 1,
+		},
+		Log: logConfig{
+			Format: "text",
+			Level:  "info",
+			Levels: map[string]string{},
+		},
+	}
+}
+
+// value is a raw setting and the source it came from
+type value struct {
+	raw    string
+	source string
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// the defaults, the YAML file, the dotenv file and the process environment.
+// It validates the result and reports every invalid key at once.
+func Load(opts Options) (*Config, error) {
+	environ := opts.Environ
+	if environ == nil {
+		environ = os.Environ()
+	}
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+
+	cfg := Defaults()
+	known := make(map[string]setting)
+	for _, s := range settings(cfg) {
+		known[s.key] = s
+	}
+
+	values := make(map[string]value)
+	var problems []Problem
+
+	configFile := opts.ConfigFile
+	if configFile == "" {
+		configFile = env["CONFIG_FILE"]
+	}
+	if configFile != "" {
+		fromFile, err := readYAML(configFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, raw := range fromFile {
+			if _, ok := known[key]; !ok {
+				problems = append(problems, Problem{Key: key, Source: configFile, Message: "unknown key"})
+				continue
+			}
+			values[key] = value{raw: raw, source: configFile}
+		}
+	}
+
+	envFile := opts.EnvFile
+	if envFile == "" {
+		envFile = ".env"
+	}
+	fromDotenv, err := godotenv.Read(envFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", envFile, err)
+	}
+	for key, raw := range fromDotenv {
+		if _, ok := known[key]; ok {
+			values[key] = value{raw: raw, source: envFile}
+		}
+	}
+
+	for key := range known {
+		if raw, ok := env[key]; ok {
+			values[key] = value{raw: raw, source: "environment"}
+		}
+	}
+
+	for key, v := range values {
+		if err := known[key].set(v.raw); err != nil {
+			problems = append(problems, Problem{Key: key, Source: v.source, Message: err.Error()})
+		}
+	}
+
+	// Production logs are shipped as JSON unless told otherwise
+	if _, ok := values["LOG_FORMAT"]; !ok && cfg.App.Env == "production" {
+		cfg.Log.Format = "json"
+	}
+
+	// The development keys stand in for the encryption keys left unset, and
+	// only when asked for, so that a deployment missing its keys fails
+	if cfg.Encryption.DevelopmentKeys {
+		if len(cfg.Encryption.Keys) == 0 {
+			cfg.Encryption.Keys = map[string]string{"development": developmentKey}
+			if cfg.Encryption.PrimaryKey == "" {
+				cfg.Encryption.PrimaryKey = "development"
+			}
+		}
+		if cfg.Encryption.IndexKey == "" {
+			cfg.Encryption.IndexKey = developmentIndexKey
+		}
+	}
+
+	for _, p := range cfg.validate() {
+		if v, ok := values[p.Key]; ok {
+			p.Source = v.source
+		}
+		problems = append(problems, p)
+	}
+
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Key < problems[j].Key })
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// validate checks the values that parse but make no sense
+func (c *Config) validate() []Problem {
+	var problems []Problem
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+	oneOf := func(key, v string, allowed ...string) {
+		for _, a := range allowed {
+			if v == a {
+				return
+			}
+		}
+		check(false, key, "must be one of %s, got %q", strings.Join(allowed, ", "), v)
+	}
+
+	check(c.App.Port > 0 && c.App.Port <= 65535, "APP_PORT", "must be between 1 and 65535, got %d", c.App.Port)
+
+	check(c.DB.Host != "", "DB_HOST", "must not be empty")
+	check(c.DB.Port > 0 && c.DB.Port <= 65535, "DB_PORT", "must be between 1 and 65535, got %d", c.DB.Port)
+	check(c.DB.User != "", "DB_USER", "must not be empty")
+	check(c.DB.Name != "", "DB_NAME", "must not be empty")
+	oneOf("DB_SSL_MODE", c.DB.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	check(c.DB.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS", "must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS", "must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "DB_MAX_IDLE_CONNS",
+		"must not exceed DB_MAX_OPEN_CONNS (%d)", c.DB.MaxOpenConns)
+	check(c.DB.MaxIdleTime >= 0, "DB_MAX_IDLE_TIME", "must not be negative")
+	check(c.DB.MaxConnLifetime >= 0, "DB_MAX_CONN_LIFETIME", "must not be negative")
+	check(c.DB.SlowQueryThreshold >= 0, "DB_SLOW_QUERY_THRESHOLD", "must not be negative")
+	for _, addr := range c.DB.Replicas {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			n, err := strconv.Atoi(port)
+			check(host != "" && err == nil && n > 0 && n <= 65535, "DB_REPLICAS", "%q is not a valid host:port", addr)
+		} else {
+			check(!strings.ContainsAny(addr, ":/ "), "DB_REPLICAS", "%q is not a valid host or host:port", addr)
+		}
+	}
+	check(c.DB.ReplicaCheckInterval > 0, "DB_REPLICA_CHECK_INTERVAL", "must be positive")
+	for association, policy := range c.DB.Cascade {
+		check(strings.Count(association, ".") == 1, "DB_CASCADE", "%q must name a model and an association, e.g. User.Comments", association)
+		check(slices.Contains([]string{"cascade", "anonymize", "restrict", "set_null"}, policy), "DB_CASCADE",
+			"policy of %s must be cascade, anonymize, restrict or set_null, got %q", association, policy)
+	}
+
+	check(c.Health.Timeout > 0, "HEALTH_TIMEOUT", "must be positive")
+	check(c.Health.CacheTTL >= 0, "HEALTH_CACHE_TTL", "must not be negative")
+	check(c.Health.MaxPoolUsage > 0 && c.Health.MaxPoolUsage <= 1, "HEALTH_MAX_POOL_USAGE",
+		"must be above 0 and at most 1, got %g", c.Health.MaxPoolUsage)
+	check(c.Health.MaxReplicaLag > 0, "HEALTH_MAX_REPLICA_LAG", "must be positive")
+
+	check(c.Cache.Size >= 0, "CACHE_SIZE", "must not be negative")
+	check(c.Cache.UserTTL >= 0, "CACHE_USER_TTL", "must not be negative")
+	check(c.Cache.PostTTL >= 0, "CACHE_POST_TTL", "must not be negative")
+
+	check(c.Trash.Retention >= 0, "TRASH_RETENTION", "must not be negative")
+	check(c.Trash.PurgeInterval > 0, "TRASH_PURGE_INTERVAL", "must be positive")
+
+	check(!c.Encryption.DevelopmentKeys || c.App.Env != "production", "ENCRYPTION_DEVELOPMENT_KEYS",
+		"must not be set in production")
+	check(len(c.Encryption.Keys) > 0, "ENCRYPTION_KEYS",
+		"must not be empty; set ENCRYPTION_DEVELOPMENT_KEYS=true to use the public development keys")
+	for id, key := range c.Encryption.Keys {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		check(err == nil && len(decoded) == 32, "ENCRYPTION_KEYS", "key %s must be 32 bytes in base64", id)
+		check(!strings.ContainsAny(id, ": "), "ENCRYPTION_KEYS", "key id %q must not contain colons or spaces", id)
+		check(c.Encryption.DevelopmentKeys || key != developmentKey, "ENCRYPTION_KEYS",
+			"key %s is the public development key, which needs ENCRYPTION_DEVELOPMENT_KEYS", id)
+	}
+	_, ok := c.Encryption.Keys[c.Encryption.PrimaryKey]
+	check(ok, "ENCRYPTION_PRIMARY_KEY", "must name a key of ENCRYPTION_KEYS, got %q", c.Encryption.PrimaryKey)
+	indexKey, err := base64.StdEncoding.DecodeString(c.Encryption.IndexKey)
+	check(err == nil && len(indexKey) >= 32, "ENCRYPTION_INDEX_KEY", "must be at least 32 bytes in base64")
+	check(c.Encryption.DevelopmentKeys || c.Encryption.IndexKey != developmentIndexKey, "ENCRYPTION_INDEX_KEY",
+		"is the public development key, which needs ENCRYPTION_DEVELOPMENT_KEYS")
+	check(c.Encryption.ReencryptInterval >= 0, "ENCRYPTION_REENCRYPT_INTERVAL", "must not be negative")
+	check(c.Encryption.ReencryptBatchSize > 0, "ENCRYPTION_REENCRYPT_BATCH_SIZE", "must be positive")
+
+	check(c.Tenant.Header != "" || c.Tenant.Domain != "", "TENANT_HEADER",
+		"must be set when TENANT_DOMAIN is empty, or no request could name its tenant")
+	check(!strings.ContainsAny(c.Tenant.Domain, ":/ ") && !strings.HasPrefix(c.Tenant.Domain, "."),
+		"TENANT_DOMAIN", "must be a bare domain such as example.com, got %q", c.Tenant.Domain)
+
+	oneOf("TRACING_EXPORTER", c.Tracing.Exporter, "none", "otlp", "file")
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "TRACING_FILE", "must be set when TRACING_EXPORTER is file")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO",
+		"must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+
+	oneOf("LOG_FORMAT", c.Log.Format, "json", "text")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "LOG_LEVEL", "must be debug, info, warn or error, got %q", c.Log.Level)
+	for pkg, l := range c.Log.Levels {
+		check(level.UnmarshalText([]byte(l)) == nil, "LOG_LEVELS", "level of %s must be debug, info, warn or error, got %q", pkg, l)
+	}
+
+	return problems
+}
+
+// readYAML reads a YAML file into setting keys: {db: {max_open_conns: 10}}
+// becomes DB_MAX_OPEN_CONNS=10. Maps under log.levels, db.cascade and
+// encryption.keys become the "key=value" lists of LOG_LEVELS, DB_CASCADE and
+// ENCRYPTION_KEYS, and sequences comma-separated lists.
+func readYAML(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	out := make(map[string]string)
+	flatten("", doc, out)
+	return out, nil
+}
+
+// mapKeys are the settings whose YAML maps become "key=value" lists
+var mapKeys = map[string]bool{"LOG_LEVELS": true, "DB_CASCADE": true, "ENCRYPTION_KEYS": true}
+
+func flatten(prefix string, node map[string]any, out map[string]string) {
+	for k, v := range node {
+		key := strings.ToUpper(k)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch v := v.(type) {
+		case map[string]any:
+			if mapKeys[key] {
+				pairs := make([]string, 0, len(v))
+				for k, item := range v {
+					pairs = append(pairs, fmt.Sprintf("%s=%v", k, item))
+				}
+				sort.Strings(pairs)
+				out[key] = strings.Join(pairs, ",")
+				continue
+			}
+			flatten(key, v, out)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+			out[key] = ""
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
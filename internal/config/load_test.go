@@ -0,0 +1,193 @@
+package config_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm-reference/internal/config"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// devKeys lets a configuration without encryption keys load
+const devKeys = "ENCRYPTION_DEVELOPMENT_KEYS=true"
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := config.Load(config.Options{EnvFile: missing(t), Environ: []string{devKeys}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.App.Port != 4000 || cfg.DB.Host != "localhost" || cfg.Log.Format != "text" {
+		t.Errorf("defaults not applied: %s", cfg)
+	}
+	if !cfg.Encryption.UsesDevelopmentKeys() {
+		t.Error("ENCRYPTION_DEVELOPMENT_KEYS didn't fill in the keys")
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	configFile := write(t, dir, "config.yaml", `
+app:
+  port: 5000
+db:
+  host: from-yaml
+  port: 6000
+  replicas: [replica-1, "replica-2:5433"]
+log:
+  levels:
+    gorm: debug
+`)
+	envFile := write(t, dir, ".env", "APP_PORT=5001\nDB_HOST=from-dotenv\n")
+
+	cfg, err := config.Load(config.Options{
+		ConfigFile: configFile,
+		EnvFile:    envFile,
+		Environ:    []string{devKeys, "DB_HOST=from-environment"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DB.Host != "from-environment" {
+		t.Errorf("DB_HOST = %q, want the environment's", cfg.DB.Host)
+	}
+	if cfg.App.Port != 5001 {
+		t.Errorf("APP_PORT = %d, want the dotenv file's 5001", cfg.App.Port)
+	}
+	if cfg.DB.Port != 6000 {
+		t.Errorf("DB_PORT = %d, want the YAML file's 6000", cfg.DB.Port)
+	}
+	if len(cfg.DB.Replicas) != 2 || cfg.DB.Replicas[1] != "replica-2:5433" {
+		t.Errorf("DB_REPLICAS = %v, want the YAML sequence", cfg.DB.Replicas)
+	}
+	if cfg.Log.Levels["gorm"] != "debug" {
+		t.Errorf("LOG_LEVELS = %v, want the YAML map", cfg.Log.Levels)
+	}
+	if cfg.DB.Name != "gorm" {
+		t.Errorf("DB_NAME = %q, want the default", cfg.DB.Name)
+	}
+}
+
+func TestLoadCollectsProblems(t *testing.T) {
+	dir := t.TempDir()
+	configFile := write(t, dir, "config.yaml", "db:\n  port: 0\n  hots: typo\n")
+
+	_, err := config.Load(config.Options{
+		ConfigFile: configFile,
+		EnvFile:    missing(t),
+		Environ:    []string{devKeys, "APP_PORT=http", "LOG_LEVEL=loud"},
+	})
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Load error = %v, want a *ValidationError", err)
+	}
+
+	want := []config.Problem{
+		{Key: "APP_PORT", Source: "environment"},
+		{Key: "DB_HOTS", Source: configFile},
+		{Key: "DB_PORT", Source: configFile},
+		{Key: "LOG_LEVEL", Source: "environment"},
+	}
+	if len(invalid.Problems) != len(want) {
+		t.Fatalf("problems = %+v, want %d", invalid.Problems, len(want))
+	}
+	for i, p := range invalid.Problems {
+		if p.Key != want[i].Key || p.Source != want[i].Source || p.Message == "" {
+			t.Errorf("problem %d = %+v, want %s from %s", i, p, want[i].Key, want[i].Source)
+		}
+	}
+}
+
+func TestLoadRefusesDevelopmentKeysInProduction(t *testing.T) {
+	_, err := config.Load(config.Options{
+		EnvFile: missing(t),
+		Environ: []string{devKeys, "APP_ENV=production"},
+	})
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) || invalid.Problems[0].Key != "ENCRYPTION_DEVELOPMENT_KEYS" {
+		t.Errorf("Load error = %v, want ENCRYPTION_DEVELOPMENT_KEYS refused", err)
+	}
+
+	_, err = config.Load(config.Options{EnvFile: missing(t), Environ: []string{}})
+	if !errors.As(err, &invalid) || invalid.Problems[0].Key != "ENCRYPTION_INDEX_KEY" {
+		t.Errorf("Load without keys error = %v, want the missing keys reported", err)
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	const password = "hunter2"
+	cfg, err := config.Load(config.Options{
+		EnvFile: missing(t),
+		Environ: []string{devKeys, "DB_PASSWORD=" + password},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printed := cfg.String()
+	if !strings.Contains(printed, "DB_PASSWORD=[REDACTED]\n") || !strings.Contains(printed, "ENCRYPTION_INDEX_KEY=[REDACTED]\n") {
+		t.Errorf("String() doesn't redact the secrets:\n%s", printed)
+	}
+	if !strings.Contains(printed, "DB_USER=postgres\n") {
+		t.Errorf("String() lacks DB_USER:\n%s", printed)
+	}
+
+	var logged bytes.Buffer
+	slog.New(slog.NewTextHandler(&logged, nil)).Info("config", "config", cfg)
+	if !strings.Contains(logged.String(), "config.DB_PASSWORD=[REDACTED]") {
+		t.Errorf("LogValue doesn't redact the password: %s", logged.String())
+	}
+
+	for _, out := range []string{printed, logged.String()} {
+		if strings.Contains(out, password) || strings.Contains(out, cfg.Encryption.IndexKey) {
+			t.Errorf("secret printed: %s", out)
+		}
+	}
+}
+
+func TestDSNQuotesValues(t *testing.T) {
+	password := `it's a \secret`
+	cfg, err := config.Load(config.Options{
+		EnvFile: missing(t),
+		Environ: []string{devKeys, "DB_PASSWORD=" + password, "DB_NAME=app db", "DB_REPLICAS=replica:5433"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dsn := range []string{cfg.DB.DSN(), cfg.DB.ReplicaDSN(cfg.DB.Replicas[0])} {
+		parsed, err := pgconn.ParseConfig(dsn)
+		if err != nil {
+			t.Fatalf("ParseConfig(%q): %v", dsn, err)
+		}
+		if parsed.Password != password || parsed.Database != "app db" || parsed.User != "postgres" {
+			t.Errorf("%q parsed to password %q, database %q, user %q", dsn, parsed.Password, parsed.Database, parsed.User)
+		}
+	}
+
+	replica, _ := pgconn.ParseConfig(cfg.DB.ReplicaDSN("replica:5433"))
+	if replica.Host != "replica" || replica.Port != 5433 {
+		t.Errorf("replica DSN connects to %s:%d, want replica:5433", replica.Host, replica.Port)
+	}
+}
+
+func write(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// missing returns the path of a dotenv file that doesn't exist, so that no
+// .env of the working directory is read
+func missing(t *testing.T) string {
+	return filepath.Join(t.TempDir(), ".env")
+}
@@ -0,0 +1,426 @@
+// Package cascade applies delete policies to the has-one and has-many
+// associations of a model, so that deleting a record also deals with the
+// records that reference it: they are deleted too, handed over to a
+// placeholder, kept from being orphaned or detached. Policies apply in the
+// transaction of the delete, to soft and hard deletes alike.
+package cascade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gorm-reference/internal/db/gormutil"
+	"gorm-reference/internal/tenant"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Policy is what happens to the records of an association when the record
+// they reference is deleted
+type Policy string
+
+const (
+	// Cascade deletes them the same way: soft deleted with a soft delete,
+	// removed with a hard delete
+	Cascade Policy = "cascade"
+
+	// Anonymize hands them over to the placeholder of the deleted record's
+	// model, see Anonymizer
+	Anonymize Policy = "anonymize"
+
+	// Restrict fails the delete while they exist
+	Restrict Policy = "restrict"
+
+	// SetNull clears their foreign key, which must be nullable
+	SetNull Policy = "set_null"
+)
+
+// Policies lists every policy
+var Policies = []Policy{Cascade, Anonymize, Restrict, SetNull}
+
+// ErrRestricted is returned by deletes of records that an association with
+// the Restrict policy still references
+var ErrRestricted = errors.New("record is referenced by an association that restricts its deletion")
+
+// Declarer is implemented by models that declare the policies of their
+// associations, by association field name. Associations without a policy
+// are left to the foreign keys of the database.
+type Declarer interface {
+	OnDelete() map[string]Policy
+}
+
+// Anonymizer is implemented by models whose associations may use the
+// Anonymize policy. Placeholder returns the primary key of the record that
+// takes over the records of a deleted one, creating it if need be. tx is
+// scoped to the tenant of the deleted records.
+type Anonymizer interface {
+	Placeholder(tx *gorm.DB) (any, error)
+}
+
+// Instance keys of the records a delete targets and of its policies
+const (
+	targetsKey = "cascade:targets"
+	rulesKey   = "cascade:rules"
+)
+
+// GORMPlugin applies the declared policies around every delete. Overrides
+// replace the declared policy of an association, keyed by model and field
+// name, e.g. "User.Comments".
+type GORMPlugin struct {
+	overrides map[string]Policy
+}
+
+var _ gorm.Plugin = (*GORMPlugin)(nil)
+
+// NewGORMPlugin creates the plugin with the given overrides
+func NewGORMPlugin(overrides map[string]Policy) *GORMPlugin {
+	return &GORMPlugin{overrides: overrides}
+}
+
+// Name implements gorm.Plugin
+func (p *GORMPlugin) Name() string {
+	return "cascade"
+}
+
+// Initialize validates the overrides and applies the delete policies around
+// deletes
+func (p *GORMPlugin) Initialize(db *gorm.DB) error {
+	for key, policy := range p.overrides {
+		if !policy.valid() {
+			return fmt.Errorf("invalid delete policy %q for %s", policy, key)
+		}
+	}
+
+	cb := db.Callback()
+	return gormutil.Register([]gormutil.Hook{
+		{Register: cb.Delete().Before("gorm:delete").Register, Name: "cascade:before_delete", Fn: p.before},
+		{Register: cb.Delete().After("gorm:delete").Register, Name: "cascade:after_delete", Fn: p.after},
+	})
+}
+
+func (p Policy) valid() bool {
+	for _, policy := range Policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// rule is the policy of one association
+type rule struct {
+	policy Policy
+	rel    *schema.Relationship
+}
+
+// rules returns the policies of the associations of s, in field order
+func (p *GORMPlugin) rules(s *schema.Schema) ([]rule, error) {
+	declared := map[string]Policy{}
+	if d, ok := reflect.New(s.ModelType).Interface().(Declarer); ok {
+		declared = d.OnDelete()
+	}
+
+	var rules []rule
+	for _, field := range s.Fields {
+		policy, ok := p.overrides[s.Name+"."+field.Name]
+		if !ok {
+			policy, ok = declared[field.Name]
+		}
+		if !ok {
+			continue
+		}
+
+		rel := s.Relationships.Relations[field.Name]
+		switch {
+		case !policy.valid():
+			return nil, fmt.Errorf("invalid delete policy %q for %s.%s", policy, s.Name, field.Name)
+		case rel != nil && rel.Type == schema.Many2Many:
+			if policy != Cascade {
+				return nil, fmt.Errorf("%s.%s is a many-to-many association, which only cascades", s.Name, field.Name)
+			}
+		case rel == nil || (rel.Type != schema.HasOne && rel.Type != schema.HasMany):
+			return nil, fmt.Errorf("%s.%s is not an association the record owns", s.Name, field.Name)
+		case len(rel.References) != 1:
+			return nil, fmt.Errorf("%s.%s has a composite foreign key", s.Name, field.Name)
+		case policy == SetNull && rel.References[0].ForeignKey.NotNull:
+			return nil, fmt.Errorf("%s.%s can't be set to null, its foreign key is not nullable", s.Name, field.Name)
+		}
+		rules = append(rules, rule{policy: policy, rel: rel})
+	}
+	return rules, nil
+}
+
+// before finds the records the delete targets, enforces Restrict and, for
+// hard deletes, applies the other policies while the foreign keys still
+// point at existing rows
+func (p *GORMPlugin) before(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	rules, err := p.rules(stmt.Schema)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	targets, err := findTargets(db)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if len(targets) == 0 {
+		return
+	}
+	db.InstanceSet(targetsKey, targets)
+	db.InstanceSet(rulesKey, rules)
+
+	for _, r := range rules {
+		if r.policy == Restrict {
+			if err := restrict(db, r, targets, isHard(stmt)); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+		}
+	}
+	if isHard(stmt) {
+		_ = db.AddError(apply(db, rules, targets, true))
+	}
+}
+
+// after applies the policies of soft deletes. The records of the
+// association are deleted after the record they reference, so that
+// restoring it from the trash brings them back too.
+func (p *GORMPlugin) after(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || isHard(stmt) {
+		return
+	}
+	targets, ok := db.InstanceGet(targetsKey)
+	if !ok {
+		return
+	}
+	rules, _ := db.InstanceGet(rulesKey)
+	_ = db.AddError(apply(db, rules.([]rule), targets.(map[string][]any), false))
+}
+
+// isHard reports whether the delete removes rows rather than setting their
+// deleted_at
+func isHard(stmt *gorm.Statement) bool {
+	if stmt.Unscoped {
+		return true
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return false
+		}
+	}
+	return true
+}
+
+// findTargets returns the primary keys of the records the delete targets,
+// grouped by tenant when the context may see several tenants
+func findTargets(db *gorm.DB) (map[string][]any, error) {
+	stmt := db.Statement
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if pk == nil {
+		return nil, nil
+	}
+
+	query := session(db, stmt.Context).Model(reflect.New(stmt.Schema.ModelType).Interface())
+	if stmt.Unscoped {
+		query = query.Unscoped()
+	}
+
+	conditions := 0
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+			query = query.Where(where)
+			conditions++
+		}
+	}
+	// Records passed to Delete are deleted by primary key
+	if stmt.ReflectValue.IsValid() {
+		_, values := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, stmt.Schema.PrimaryFields)
+		if len(values) > 0 {
+			column, queryValues := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, values)
+			query = query.Where(clause.IN{Column: column, Values: queryValues})
+			conditions++
+		}
+	}
+	if conditions == 0 {
+		// gorm refuses deletes without conditions
+		return nil, nil
+	}
+
+	tenantField := stmt.Schema.LookUpField(tenant.Column)
+	if tenantField == nil || !tenant.Unscoped(stmt.Context) {
+		ids := reflect.New(reflect.SliceOf(pk.FieldType))
+		if err := query.Pluck(pk.DBName, ids.Interface()).Error; err != nil {
+			return nil, err
+		}
+		return map[string][]any{"": toAny(ids.Elem())}, nil
+	}
+
+	rows, err := query.Select(pk.DBName, tenantField.DBName).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := map[string][]any{}
+	for rows.Next() {
+		id := reflect.New(pk.FieldType)
+		var tenantID string
+		if err := rows.Scan(id.Interface(), &tenantID); err != nil {
+			return nil, err
+		}
+		targets[tenantID] = append(targets[tenantID], id.Elem().Interface())
+	}
+	return targets, rows.Err()
+}
+
+// restrict fails when the association references any of the targets. Hard
+// deletes count soft-deleted records too, since their rows still exist.
+func restrict(db *gorm.DB, r rule, targets map[string][]any, hard bool) error {
+	for tenantID, ids := range targets {
+		query := related(db, r, tenantID, ids)
+		if hard {
+			query = query.Unscoped()
+		}
+		var count int64
+		if err := query.Limit(1).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %s.%s", ErrRestricted, r.rel.Schema.Name, r.rel.Name)
+		}
+	}
+	return nil
+}
+
+// apply applies the Cascade, Anonymize and SetNull policies to the records
+// of each association that reference the targets
+func apply(db *gorm.DB, rules []rule, targets map[string][]any, hard bool) error {
+	// Sorted so that statements run in the same order every time
+	tenants := make([]string, 0, len(targets))
+	for tenantID := range targets {
+		tenants = append(tenants, tenantID)
+	}
+	sort.Strings(tenants)
+
+	for _, r := range rules {
+		for _, tenantID := range tenants {
+			if err := applyRule(db, r, tenantID, targets[tenantID], hard); err != nil {
+				return fmt.Errorf("failed to apply %s to %s.%s: %w", r.policy, r.rel.Schema.Name, r.rel.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func applyRule(db *gorm.DB, r rule, tenantID string, ids []any, hard bool) error {
+	if r.rel.Type == schema.Many2Many {
+		return unlink(db, r, tenantID, ids, hard)
+	}
+
+	fk := r.rel.References[0].ForeignKey
+	query := related(db, r, tenantID, ids)
+	if hard {
+		query = query.Unscoped()
+	}
+
+	switch r.policy {
+	case Cascade:
+		// Deleting through GORM applies the policies of the records deleted
+		return query.Delete(reflect.New(r.rel.FieldSchema.ModelType).Interface()).Error
+	case SetNull:
+		return query.Update(fk.DBName, nil).Error
+	case Anonymize:
+		anonymizer, ok := reflect.New(r.rel.Schema.ModelType).Interface().(Anonymizer)
+		if !ok {
+			return fmt.Errorf("%s has no placeholder to hand records over to", r.rel.Schema.Name)
+		}
+
+		// Placeholders are created on first use, so only look one up when
+		// there is something to hand over
+		var count int64
+		if err := query.Session(&gorm.Session{}).Limit(1).Count(&count).Error; err != nil || count == 0 {
+			return err
+		}
+		placeholder, err := anonymizer.Placeholder(session(db, tenantContext(db, tenantID)))
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if reflect.DeepEqual(id, placeholder) {
+				return fmt.Errorf("%w: the placeholder of %s can't be deleted", ErrRestricted, r.rel.Schema.Name)
+			}
+		}
+		return query.Update(fk.DBName, placeholder).Error
+	}
+	return nil
+}
+
+// unlink removes the join table rows of a many-to-many association on hard
+// deletes. The records at the other end are shared, so they stay, and so do
+// the rows of soft-deleted records.
+func unlink(db *gorm.DB, r rule, tenantID string, ids []any, hard bool) error {
+	if !hard {
+		return nil
+	}
+	for _, ref := range r.rel.References {
+		if !ref.OwnPrimaryKey {
+			continue
+		}
+		err := session(db, tenantContext(db, tenantID)).
+			Table(r.rel.JoinTable.Table).
+			Where(clause.IN{Column: clause.Column{Name: ref.ForeignKey.DBName}, Values: ids}).
+			Delete(reflect.New(r.rel.JoinTable.ModelType).Interface()).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// related returns the query of the records of the association that
+// reference ids of tenantID
+func related(db *gorm.DB, r rule, tenantID string, ids []any) *gorm.DB {
+	fk := r.rel.References[0].ForeignKey
+	return session(db, tenantContext(db, tenantID)).
+		Model(reflect.New(r.rel.FieldSchema.ModelType).Interface()).
+		Where(clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: fk.DBName}, Values: ids})
+}
+
+// session returns a new statement on the connection of db, and so in the
+// transaction of the delete
+func session(db *gorm.DB, ctx context.Context) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, Context: ctx})
+}
+
+// tenantContext returns the context of the delete, scoped to tenantID when
+// the delete spans tenants
+func tenantContext(db *gorm.DB, tenantID string) context.Context {
+	ctx := db.Statement.Context
+	if tenantID == "" {
+		return ctx
+	}
+	return tenant.Rescope(ctx, tenantID)
+}
+
+func toAny(slice reflect.Value) []any {
+	values := make([]any, slice.Len())
+	for i := range values {
+		values[i] = slice.Index(i).Interface()
+	}
+	return values
+}
@@ -0,0 +1,307 @@
+package cascade_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm-reference/internal/db/cascade"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// The models of the tests: an author owns a bio, books with chapters, and
+// notes whose foreign key is nullable
+
+type author struct {
+	gorm.Model
+	Name  string
+	Bio   *bio
+	Books []book
+	Notes []note
+}
+
+func (author) OnDelete() map[string]cascade.Policy {
+	return map[string]cascade.Policy{
+		"Bio":   cascade.Cascade,
+		"Books": cascade.Cascade,
+		"Notes": cascade.SetNull,
+	}
+}
+
+// Placeholder implements cascade.Anonymizer
+func (author) Placeholder(tx *gorm.DB) (any, error) {
+	var placeholder author
+	err := tx.Where(author{Name: "deleted"}).FirstOrCreate(&placeholder).Error
+	return placeholder.ID, err
+}
+
+type bio struct {
+	gorm.Model
+	AuthorID uint `gorm:"not null"`
+}
+
+type book struct {
+	gorm.Model
+	AuthorID uint `gorm:"not null"`
+	Chapters []chapter
+}
+
+func (book) OnDelete() map[string]cascade.Policy {
+	return map[string]cascade.Policy{"Chapters": cascade.Cascade}
+}
+
+type chapter struct {
+	gorm.Model
+	BookID uint `gorm:"not null"`
+}
+
+type note struct {
+	gorm.Model
+	AuthorID *uint
+}
+
+func TestInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]cascade.Policy
+		want      string
+	}{
+		{"unknown policy", map[string]cascade.Policy{"author.Books": "orphan"}, `invalid delete policy "orphan"`},
+		{"not nullable", map[string]cascade.Policy{"author.Books": cascade.SetNull}, "foreign key is not nullable"},
+		{"not an association", map[string]cascade.Policy{"author.Name": cascade.Cascade}, "not an association"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+				DryRun:                 true,
+				DisableAutomaticPing:   true,
+				SkipDefaultTransaction: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Unknown policies are caught when the plugin is installed, the
+			// others on the first delete of the model
+			err = db.Use(cascade.NewGORMPlugin(tt.overrides))
+			if err == nil {
+				err = db.Delete(&author{Model: gorm.Model{ID: 1}}).Error
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestSoftDelete checks that a soft delete cascades down to the chapters of
+// the books, detaches the notes, and leaves records deleted before alone.
+// It needs a Postgres database in TEST_DATABASE_URL.
+func TestSoftDelete(t *testing.T) {
+	db := openDB(t, nil)
+	a := seed(t, db)
+
+	if err := db.Delete(&a.Books[1]).Error; err != nil {
+		t.Fatal(err)
+	}
+	earlier := deletedAt(t, db, "books", a.Books[1].ID)
+
+	if err := db.Delete(&author{}, a.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"bios", "books", "chapters"} {
+		if n := count(t, db, table, "deleted_at IS NULL"); n != 0 {
+			t.Errorf("%d %s are still live", n, table)
+		}
+	}
+	since := deletedAt(t, db, "authors", a.ID)
+	if n := count(t, db, "chapters", "book_id = ? AND deleted_at >= ?", a.Books[0].ID, since); n != 2 {
+		t.Errorf("%d chapters were deleted with their author, want 2", n)
+	}
+	if got := deletedAt(t, db, "books", a.Books[1].ID); !got.Equal(earlier) {
+		t.Errorf("the book deleted earlier was deleted again at %v", got)
+	}
+	if n := count(t, db, "notes", "author_id IS NULL"); n != 2 {
+		t.Errorf("%d notes are detached, want 2", n)
+	}
+}
+
+// TestHardDelete checks that a hard delete removes the rows the foreign keys
+// would otherwise keep it from deleting, soft-deleted ones included
+func TestHardDelete(t *testing.T) {
+	db := openDB(t, nil)
+	a := seed(t, db)
+
+	if err := db.Delete(&a.Books[1]).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Unscoped().Delete(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"authors", "bios", "books", "chapters"} {
+		if n := count(t, db, table, "true"); n != 0 {
+			t.Errorf("%d %s remain", n, table)
+		}
+	}
+	if n := count(t, db, "notes", "author_id IS NULL"); n != 2 {
+		t.Errorf("%d notes are detached, want 2", n)
+	}
+}
+
+// TestAnonymize checks that records are handed over to one placeholder
+func TestAnonymize(t *testing.T) {
+	db := openDB(t, map[string]cascade.Policy{"author.Books": cascade.Anonymize})
+	first := seed(t, db)
+	second := seed(t, db)
+
+	for _, a := range []author{first, second} {
+		if err := db.Delete(&a).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var placeholders []author
+	if err := db.Where("name = ?", "deleted").Find(&placeholders).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(placeholders) != 1 {
+		t.Fatalf("found %d placeholders, want 1", len(placeholders))
+	}
+	if n := count(t, db, "books", "author_id = ? AND deleted_at IS NULL", placeholders[0].ID); n != 4 {
+		t.Errorf("the placeholder has %d live books, want 4", n)
+	}
+	if n := count(t, db, "chapters", "deleted_at IS NULL"); n != 8 {
+		t.Errorf("%d chapters are live, want the 8 of the books handed over", n)
+	}
+
+	err := db.Delete(&placeholders[0]).Error
+	if !errors.Is(err, cascade.ErrRestricted) {
+		t.Errorf("deleting the placeholder returned %v, want ErrRestricted", err)
+	}
+}
+
+// TestRestrict checks that a restricted association blocks soft and hard
+// deletes, and that the rest of the delete is rolled back
+func TestRestrict(t *testing.T) {
+	db := openDB(t, map[string]cascade.Policy{"book.Chapters": cascade.Restrict})
+	a := seed(t, db)
+
+	for name, tx := range map[string]*gorm.DB{"soft": db, "hard": db.Unscoped()} {
+		err := tx.Delete(&author{}, a.ID).Error
+		if !errors.Is(err, cascade.ErrRestricted) {
+			t.Errorf("%s delete returned %v, want ErrRestricted", name, err)
+		}
+		if n := count(t, db, "books", "deleted_at IS NULL"); n != 2 {
+			t.Errorf("%d books are live after a failed %s delete, want 2", n, name)
+		}
+		if n := count(t, db, "authors", "deleted_at IS NULL"); n != 1 {
+			t.Errorf("%d authors are live after a failed %s delete, want 1", n, name)
+		}
+	}
+
+	// Soft-deleted chapters still hold hard deletes back
+	if err := db.Where("book_id IN ?", []uint{a.Books[0].ID, a.Books[1].ID}).Delete(&chapter{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&author{}, a.ID).Error; err != nil {
+		t.Errorf("soft delete without live chapters: %v", err)
+	}
+	if err := db.Unscoped().Delete(&author{}, a.ID).Error; !errors.Is(err, cascade.ErrRestricted) {
+		t.Errorf("hard delete with soft-deleted chapters returned %v, want ErrRestricted", err)
+	}
+}
+
+// seed creates an author with a bio, two books of two chapters each, and
+// two notes
+func seed(t *testing.T, db *gorm.DB) author {
+	t.Helper()
+
+	a := author{
+		Name: "author",
+		Bio:  &bio{},
+		Books: []book{
+			{Chapters: []chapter{{}, {}}},
+			{Chapters: []chapter{{}, {}}},
+		},
+		Notes: []note{{}, {}},
+	}
+	if err := db.Create(&a).Error; err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	return a
+}
+
+func count(t *testing.T, db *gorm.DB, table, where string, args ...any) int64 {
+	t.Helper()
+
+	var n int64
+	if err := db.Table(table).Where(where, args...).Count(&n).Error; err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
+
+func deletedAt(t *testing.T, db *gorm.DB, table string, id uint) time.Time {
+	t.Helper()
+
+	var deletedAt []time.Time
+	if err := db.Table(table).Where("id = ?", id).Pluck("deleted_at", &deletedAt).Error; err != nil || len(deletedAt) == 0 {
+		t.Fatalf("deleted_at of %s %d: %v", table, id, err)
+	}
+	return deletedAt[0]
+}
+
+// openDB creates the tables of the test models in a scratch schema and
+// installs the plugin with overrides
+func openDB(t *testing.T, overrides map[string]cascade.Policy) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	scratch := fmt.Sprintf("cascade_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + scratch); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec("DROP SCHEMA " + scratch + " CASCADE")
+		admin.Close()
+	})
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	config.RuntimeParams["search_path"] = scratch
+	pool := stdlib.OpenDB(*config)
+	t.Cleanup(func() { pool.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open gorm: %v", err)
+	}
+	if err := db.AutoMigrate(&author{}, &bio{}, &book{}, &chapter{}, &note{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := db.Use(cascade.NewGORMPlugin(overrides)); err != nil {
+		t.Fatalf("install plugin: %v", err)
+	}
+	return db.WithContext(context.Background())
+}
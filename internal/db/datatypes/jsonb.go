@@ -0,0 +1,177 @@
+// Package datatypes provides column types that neither database/sql nor
+// GORM support out of the box.
+package datatypes
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// JSONB stores a value of type T in a jsonb column. It encodes to the same
+// JSON as T, so API payloads look as if the field were a plain T. When T has
+// a Validate method, the value is validated before every write, and ozzo
+// validation calls it too. A zero T that encodes to null is stored as NULL.
+type JSONB[T any] struct {
+	Data T
+}
+
+var (
+	_ sql.Scanner                  = (*JSONB[any])(nil)
+	_ driver.Valuer                = JSONB[any]{}
+	_ schema.GormDataTypeInterface = JSONB[any]{}
+	_ json.Marshaler               = JSONB[any]{}
+	_ json.Unmarshaler             = (*JSONB[any])(nil)
+)
+
+// NewJSONB returns data as a JSONB column value
+func NewJSONB[T any](data T) JSONB[T] {
+	return JSONB[T]{Data: data}
+}
+
+// Scan implements sql.Scanner
+func (j *JSONB[T]) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		var zero T
+		j.Data = zero
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONB[%T]", src, j.Data)
+	}
+
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("cannot decode JSONB[%T]: %w", j.Data, err)
+	}
+	j.Data = value
+	return nil
+}
+
+// Value implements driver.Valuer
+func (j JSONB[T]) Value() (driver.Value, error) {
+	if err := j.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %T: %w", j.Data, err)
+	}
+	data, err := json.Marshal(j.Data)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return string(data), nil
+}
+
+// Validate runs the Validate method of T, if it has one
+func (j JSONB[T]) Validate() error {
+	if v, ok := any(j.Data).(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// GormDataType implements schema.GormDataTypeInterface
+func (JSONB[T]) GormDataType() string {
+	return "jsonb"
+}
+
+// GormDBDataType implements migrator.GormDBDataTypeInterface
+func (JSONB[T]) GormDBDataType(*gorm.DB, *schema.Field) string {
+	return "jsonb"
+}
+
+// MarshalJSON implements json.Marshaler
+func (j JSONB[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.Data)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (j *JSONB[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &j.Data)
+}
+
+// JSONPath names the value at a path of keys inside a jsonb column, for
+// conditions on nested values:
+//
+//	db.Where(datatypes.Path("preferences", "notifications", "email").Equals(true))
+type JSONPath struct {
+	Column string
+	Keys   []string
+}
+
+// Path returns the path of keys inside column
+func Path(column string, keys ...string) JSONPath {
+	return JSONPath{Column: column, Keys: keys}
+}
+
+// JSON returns the jsonb value at the path, or NULL when it is missing
+func (p JSONPath) JSON() clause.Expr {
+	return clause.Expr{SQL: "? #> ?::text[]", Vars: []any{p.column(), textArray(p.Keys)}}
+}
+
+// Text returns the value at the path as text, for ordering or comparing
+// with SQL values
+func (p JSONPath) Text() clause.Expr {
+	return clause.Expr{SQL: "? #>> ?::text[]", Vars: []any{p.column(), textArray(p.Keys)}}
+}
+
+// Exists matches rows that have a value at the path, null included
+func (p JSONPath) Exists() clause.Expr {
+	return clause.Expr{SQL: "? #> ?::text[] IS NOT NULL", Vars: []any{p.column(), textArray(p.Keys)}}
+}
+
+// Equals matches rows whose value at the path is value. A GIN index on the
+// column serves the containment, @>, that narrows the rows down; containment
+// also matches objects and arrays holding more than value, so the value at
+// the path is compared as well.
+func (p JSONPath) Equals(value any) clause.Expr {
+	doc := value
+	for i := len(p.Keys) - 1; i >= 0; i-- {
+		doc = map[string]any{p.Keys[i]: doc}
+	}
+	return clause.Expr{
+		SQL:  "(? @> ?::jsonb AND ? #> ?::text[] = ?::jsonb)",
+		Vars: []any{p.column(), jsonArg{doc}, p.column(), textArray(p.Keys), jsonArg{value}},
+	}
+}
+
+func (p JSONPath) column() clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: p.Column}
+}
+
+// jsonArg is a statement argument encoded as JSON when the statement runs
+type jsonArg struct {
+	value any
+}
+
+// Value implements driver.Valuer
+func (a jsonArg) Value() (driver.Value, error) {
+	data, err := json.Marshal(a.value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// textArray renders keys as a Postgres text[] literal
+func textArray(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		key = strings.ReplaceAll(key, `\`, `\\`)
+		key = strings.ReplaceAll(key, `"`, `\"`)
+		quoted[i] = `"` + key + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
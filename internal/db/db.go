@@ -0,0 +1,130 @@
+// Package db
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"gorm-reference/internal/config"
+	"gorm-reference/internal/db/cascade"
+	"gorm-reference/internal/db/encryption"
+	"gorm-reference/internal/db/locking"
+	"gorm-reference/internal/db/rls"
+	"gorm-reference/internal/db/slowquery"
+	"gorm-reference/internal/logging"
+	"gorm-reference/internal/metrics"
+	"gorm-reference/internal/models"
+	"gorm-reference/internal/tenant"
+	"gorm-reference/internal/tracing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var logger = logging.For("db")
+
+// Open connects to the database described by cfg through a sized pool that
+// sets the row-level security variables, and installs the tenant,
+// encryption, optimistic locking, delete cascade, metrics, tracing and slow
+// query plugins. Pool and query metrics are registered with reg.
+func Open(cfg *config.Config, reg prometheus.Registerer) (*gorm.DB, error) {
+	keys, err := Keyring(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Encryption.UsesDevelopmentKeys() {
+		logger.Warn("encrypting with the public development keys; set ENCRYPTION_KEYS and ENCRYPTION_INDEX_KEY outside development")
+	}
+	pool, err := openPool(cfg, cfg.DB.DSN())
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{
+		Logger: logging.GORM(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	plugins := []gorm.Plugin{
+		tenant.NewGORMPlugin(),
+		// Before locking, which builds the SET clause of updates
+		encryption.NewGORMPlugin(keys),
+		locking.NewGORMPlugin(),
+		cascade.NewGORMPlugin(cascadeOverrides(cfg.DB.Cascade)),
+		metrics.NewGORMPlugin(reg),
+		tracing.NewGORMPlugin(),
+		slowquery.New(slowquery.Config{
+			Threshold: cfg.DB.SlowQueryThreshold,
+			Explain:   cfg.DB.ExplainSlowQueries,
+		}),
+	}
+	for _, p := range plugins {
+		if err := db.Use(p); err != nil {
+			return nil, fmt.Errorf("failed to install %s plugin: %w", p.Name(), err)
+		}
+	}
+	if err := metrics.RegisterPool(reg, "primary", db); err != nil {
+		return nil, err
+	}
+
+	if err := models.SetupJoinTable(db); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// cascadeOverrides converts the configured delete policies
+func cascadeOverrides(policies map[string]string) map[string]cascade.Policy {
+	overrides := make(map[string]cascade.Policy, len(policies))
+	for association, policy := range policies {
+		overrides[association] = cascade.Policy(policy)
+	}
+	return overrides
+}
+
+// Keyring decodes the configured encryption keys
+func Keyring(cfg *config.Config) (*encryption.Keyring, error) {
+	keys := make(map[string][]byte, len(cfg.Encryption.Keys))
+	for id, key := range cfg.Encryption.Keys {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", id, err)
+		}
+		keys[id] = decoded
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(cfg.Encryption.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption index key: %w", err)
+	}
+	return encryption.NewKeyring(keys, cfg.Encryption.PrimaryKey, indexKey)
+}
+
+// openPool opens a sized pool for dsn whose connections carry the row-level
+// security variables
+func openPool(cfg *config.Config, dsn string) (*rls.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database configuration: %w", err)
+	}
+
+	return rls.Open(context.Background(), poolConfig, rls.Options{
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		MaxIdleTime:     cfg.DB.MaxIdleTime,
+		MaxConnLifetime: cfg.DB.MaxConnLifetime,
+	})
+}
+
+// Close closes the pool of a database returned by Open
+func Close(db *gorm.DB) error {
+	if pool, ok := db.ConnPool.(io.Closer); ok {
+		return pool.Close()
+	}
+	return nil
+}
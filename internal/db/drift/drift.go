@@ -0,0 +1,453 @@
+// Package drift compares the GORM schema of the models with the live
+// database and reports every difference between the two.
+package drift
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gorm-reference/internal/db/indexes"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Severity tells whether a difference breaks the models (Error) or is only
+// something the models don't know about (Warning)
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "ERROR"
+	}
+	return "WARNING"
+}
+
+// Kind identifies what kind of object differs and how
+type Kind int
+
+const (
+	MissingTable Kind = iota
+	MissingColumn
+	ColumnType
+	ColumnNullable
+	MissingIndex
+	IndexDefinition
+	MissingConstraint
+
+	// Objects that exist in the database but not in the models
+	ExtraColumn
+	ExtraIndex
+	ExtraConstraint
+)
+
+// Severity returns Warning for objects the models don't declare and Error
+// for everything else
+func (k Kind) Severity() Severity {
+	switch k {
+	case ExtraColumn, ExtraIndex, ExtraConstraint:
+		return Warning
+	default:
+		return Error
+	}
+}
+
+// Difference is a single mismatch between a model and the database
+type Difference struct {
+	Kind     Kind
+	Severity Severity
+	Table    string
+	Object   string // column, index or constraint name; empty for the table itself
+	Message  string
+
+	// Schema is the GORM schema of the table, used to look up the expected
+	// definition of Object
+	Schema *schema.Schema
+
+	// Actual is the database's definition of Object: the column type, or the
+	// output of pg_get_indexdef / pg_get_constraintdef
+	Actual string
+}
+
+// Report holds every difference found by Check
+type Report struct {
+	Differences []Difference
+}
+
+// Count returns the number of differences with the given severity
+func (r *Report) Count(severity Severity) int {
+	n := 0
+	for _, d := range r.Differences {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// HasDrift reports whether the schema drifted. Warnings only count when
+// strict is set.
+func (r *Report) HasDrift(strict bool) bool {
+	if strict {
+		return len(r.Differences) > 0
+	}
+	return r.Count(Error) > 0
+}
+
+// Print writes a human-readable report to w
+func (r *Report) Print(w io.Writer) error {
+	if len(r.Differences) == 0 {
+		_, err := fmt.Fprintln(w, "no schema drift detected")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEVERITY\tTABLE\tOBJECT\tDIFFERENCE")
+	for _, d := range r.Differences {
+		object := d.Object
+		if object == "" {
+			object = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Severity, d.Table, object, d.Message)
+	}
+	fmt.Fprintf(tw, "\n%d error(s), %d warning(s)\n", r.Count(Error), r.Count(Warning))
+	return tw.Flush()
+}
+
+// Check compares each model, and the join tables of its many-to-many
+// associations, with the tables in the database's current schema. Join
+// tables customised with SetupJoinTable must be set up on db beforehand.
+func Check(ctx context.Context, db *gorm.DB, models ...any) (*Report, error) {
+	c := &checker{db: db.WithContext(ctx), report: &Report{}}
+
+	seen := make(map[string]bool)
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("failed to parse %T: %w", model, err)
+		}
+
+		tables := []*schema.Schema{stmt.Schema}
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			if rel.JoinTable != nil {
+				tables = append(tables, rel.JoinTable)
+			}
+		}
+
+		for _, s := range tables {
+			if seen[s.Table] {
+				continue
+			}
+			seen[s.Table] = true
+			if err := c.checkTable(s); err != nil {
+				return nil, fmt.Errorf("failed to inspect %s: %w", s.Table, err)
+			}
+		}
+	}
+
+	sort.SliceStable(c.report.Differences, func(i, j int) bool {
+		a, b := c.report.Differences[i], c.report.Differences[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Object < b.Object
+	})
+
+	return c.report, nil
+}
+
+type checker struct {
+	db     *gorm.DB
+	report *Report
+}
+
+func (c *checker) add(kind Kind, s *schema.Schema, object, actual, format string, args ...any) {
+	c.report.Differences = append(c.report.Differences, Difference{
+		Kind:     kind,
+		Severity: kind.Severity(),
+		Table:    s.Table,
+		Object:   object,
+		Message:  fmt.Sprintf(format, args...),
+		Schema:   s,
+		Actual:   actual,
+	})
+}
+
+func (c *checker) checkTable(s *schema.Schema) error {
+	var exists bool
+	err := c.db.Raw(`
+        SELECT EXISTS (
+            SELECT 1 FROM information_schema.tables
+            WHERE table_schema = current_schema() AND table_name = ?
+        )
+    `, s.Table).Scan(&exists).Error
+	if err != nil {
+		return err
+	}
+	if !exists {
+		c.add(MissingTable, s, "", "", "table is missing")
+		return nil
+	}
+
+	if err := c.checkColumns(s); err != nil {
+		return err
+	}
+	if err := c.checkIndexes(s); err != nil {
+		return err
+	}
+	return c.checkConstraints(s)
+}
+
+type column struct {
+	Name       string `gorm:"column:name"`
+	UDTName    string `gorm:"column:udt_name"`
+	MaxLength  *int   `gorm:"column:max_length"`
+	IsNullable string `gorm:"column:is_nullable"`
+}
+
+// dataType returns the normalized column type, e.g. "varchar(100)"
+func (col column) dataType() string {
+	t := normalizeType(col.UDTName)
+	if col.MaxLength != nil {
+		t = fmt.Sprintf("%s(%d)", t, *col.MaxLength)
+	}
+	return t
+}
+
+func (c *checker) checkColumns(s *schema.Schema) error {
+	var columns []column
+	err := c.db.Raw(`
+        SELECT column_name AS name, udt_name, character_maximum_length AS max_length, is_nullable
+        FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = ?
+    `, s.Table).Scan(&columns).Error
+	if err != nil {
+		return err
+	}
+
+	actual := make(map[string]column, len(columns))
+	for _, col := range columns {
+		actual[col.Name] = col
+	}
+
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.IgnoreMigration {
+			continue
+		}
+
+		col, ok := actual[field.DBName]
+		if !ok {
+			c.add(MissingColumn, s, field.DBName, "", "column is missing")
+			continue
+		}
+		delete(actual, field.DBName)
+
+		wantType := normalizeType(c.db.Dialector.DataTypeOf(field))
+		if gotType := col.dataType(); wantType != gotType {
+			c.add(ColumnType, s, field.DBName, gotType, "type is %s, model expects %s", gotType, wantType)
+		}
+
+		wantNullable := !field.NotNull && !field.PrimaryKey
+		if nullable := col.IsNullable == "YES"; nullable != wantNullable {
+			c.add(ColumnNullable, s, field.DBName, "", "nullable is %v, model expects %v", nullable, wantNullable)
+		}
+	}
+
+	for name, col := range actual {
+		c.add(ExtraColumn, s, name, col.dataType(), "column is not declared by the model")
+	}
+	return nil
+}
+
+type index struct {
+	Name       string  `gorm:"column:name"`
+	IsUnique   bool    `gorm:"column:is_unique"`
+	IsPrimary  bool    `gorm:"column:is_primary"`
+	Columns    string  `gorm:"column:columns"`
+	Predicate  *string `gorm:"column:predicate"`
+	Definition string  `gorm:"column:definition"`
+}
+
+func (c *checker) checkIndexes(s *schema.Schema) error {
+	var rows []index
+	err := c.db.Raw(`
+        SELECT
+            i.relname AS name,
+            ix.indisunique AS is_unique,
+            ix.indisprimary AS is_primary,
+            array_to_string(ARRAY(
+                SELECT a.attname
+                FROM unnest(ix.indkey) WITH ORDINALITY AS k(attnum, ord)
+                JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+                ORDER BY k.ord
+            ), ',') AS columns,
+            pg_get_expr(ix.indpred, ix.indrelid) AS predicate,
+            pg_get_indexdef(ix.indexrelid) AS definition
+        FROM pg_index ix
+        JOIN pg_class i ON i.oid = ix.indexrelid
+        JOIN pg_class t ON t.oid = ix.indrelid
+        JOIN pg_namespace n ON n.oid = t.relnamespace
+        WHERE n.nspname = current_schema() AND t.relname = ?
+    `, s.Table).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	actual := make(map[string]index, len(rows))
+	for _, idx := range rows {
+		if !idx.IsPrimary {
+			actual[idx.Name] = idx
+		}
+	}
+
+	for _, want := range s.ParseIndexes() {
+		got, ok := actual[want.Name]
+		if !ok {
+			c.add(MissingIndex, s, want.Name, "", "index is missing")
+			continue
+		}
+		delete(actual, want.Name)
+
+		var mismatches []string
+		if wantUnique := want.Class == "UNIQUE"; got.IsUnique != wantUnique {
+			mismatches = append(mismatches, fmt.Sprintf("unique is %v, model expects %v", got.IsUnique, wantUnique))
+		}
+
+		wantColumns := make([]string, 0, len(want.Fields))
+		for _, f := range want.Fields {
+			if f.Expression != "" {
+				// Expression indexes can't be compared column by column
+				wantColumns = nil
+				break
+			}
+			wantColumns = append(wantColumns, f.DBName)
+		}
+		if wantColumns != nil && strings.Join(wantColumns, ",") != got.Columns {
+			mismatches = append(mismatches, fmt.Sprintf("columns are (%s), model expects (%s)", got.Columns, strings.Join(wantColumns, ",")))
+		}
+
+		if (want.Where != "") != (got.Predicate != nil) {
+			mismatches = append(mismatches, "partial index predicate does not match the model")
+		}
+
+		if len(mismatches) > 0 {
+			c.add(IndexDefinition, s, want.Name, got.Definition, "%s", strings.Join(mismatches, "; "))
+		}
+	}
+
+	// Indexes declared through indexes.Indexer are only checked for existence
+	declared, _ := indexes.Declared(s.ModelType)
+	for _, want := range declared {
+		if _, ok := actual[want.Name]; !ok {
+			c.add(MissingIndex, s, want.Name, "", "index is missing")
+			continue
+		}
+		delete(actual, want.Name)
+	}
+
+	for name, idx := range actual {
+		c.add(ExtraIndex, s, name, idx.Definition, "index is not declared by the model")
+	}
+	return nil
+}
+
+type constraint struct {
+	Name       string `gorm:"column:name"`
+	Definition string `gorm:"column:definition"`
+}
+
+func (c *checker) checkConstraints(s *schema.Schema) error {
+	var constraints []constraint
+	err := c.db.Raw(`
+        SELECT tc.constraint_name AS name, pg_get_constraintdef(pc.oid) AS definition
+        FROM information_schema.table_constraints tc
+        JOIN pg_namespace n ON n.nspname = tc.constraint_schema
+        JOIN pg_constraint pc ON pc.connamespace = n.oid
+            AND pc.conname = tc.constraint_name
+            AND pc.conrelid = (quote_ident(tc.table_schema) || '.' || quote_ident(tc.table_name))::regclass
+        WHERE tc.table_schema = current_schema()
+          AND tc.table_name = ?
+          AND tc.constraint_type IN ('FOREIGN KEY', 'CHECK')
+          AND tc.constraint_name NOT LIKE '%_not_null'
+    `, s.Table).Scan(&constraints).Error
+	if err != nil {
+		return err
+	}
+
+	actual := make(map[string]string, len(constraints))
+	for _, con := range constraints {
+		actual[con.Name] = con.Definition
+	}
+
+	var expected []string
+	for _, rel := range s.Relationships.Relations {
+		if constraint := rel.ParseConstraint(); constraint != nil && constraint.Schema == s {
+			expected = append(expected, constraint.Name)
+		}
+	}
+	for name := range s.ParseCheckConstraints() {
+		expected = append(expected, name)
+	}
+	_, declared := indexes.Declared(s.ModelType)
+	for _, con := range declared {
+		expected = append(expected, con.Name)
+	}
+
+	for _, name := range expected {
+		if _, ok := actual[name]; !ok {
+			c.add(MissingConstraint, s, name, "", "constraint is missing")
+			continue
+		}
+		delete(actual, name)
+	}
+
+	for name, definition := range actual {
+		c.add(ExtraConstraint, s, name, definition, "constraint is not declared by the model")
+	}
+	return nil
+}
+
+// normalizeType maps GORM data types and information_schema udt names to a
+// common spelling, e.g. "bigserial" and "int8" both become "int8"
+func normalizeType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+
+	switch t {
+	case "bigserial", "bigint", "int8":
+		return "int8"
+	case "serial", "integer", "int", "int4":
+		return "int4"
+	case "smallserial", "smallint", "int2":
+		return "int2"
+	case "boolean", "bool":
+		return "bool"
+	case "timestamp with time zone", "timestamptz":
+		return "timestamptz"
+	case "timestamp without time zone", "timestamp":
+		return "timestamp"
+	case "double precision", "float8":
+		return "float8"
+	case "real", "float4":
+		return "float4"
+	case "decimal", "numeric":
+		return "numeric"
+	}
+
+	if rest, ok := strings.CutPrefix(t, "character varying"); ok {
+		return "varchar" + rest
+	}
+	if strings.HasPrefix(t, "decimal(") || strings.HasPrefix(t, "numeric(") {
+		// information_schema reports precision separately
+		return "numeric"
+	}
+	return t
+}
@@ -0,0 +1,484 @@
+// Package encryption encrypts chosen columns with AES-GCM. A field opts in
+// with the encrypted serializer:
+//
+//	Email *string `gorm:"type:text;serializer:encrypted;blindIndex:email_index"`
+//
+// Values are stored as enc:<key id>:<base64 nonce and ciphertext>, sealed
+// with the primary key of the keyring and bound to their table and column.
+// Older keys stay in the keyring to decrypt what they sealed until
+// Reencrypt has moved every row to the primary key. Rows written before
+// the column was encrypted hold plaintext, which is read as is.
+//
+// Ciphertext can't be compared, so exact-match lookups and unique indexes go
+// through a blind index: the blindIndex tag names a column that the plugin
+// keeps set to an HMAC of the plaintext, see BlindIndex.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"gorm-reference/internal/db/gormutil"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Serializer is the name of the serializer of encrypted fields
+const Serializer = "encrypted"
+
+// prefix starts every encrypted value
+const prefix = "enc:"
+
+var (
+	// ErrNoKeyring is returned when encrypted fields are used before the
+	// plugin is installed
+	ErrNoKeyring = errors.New("encryption: no keyring installed")
+	// ErrUnknownKey is returned for values sealed with a key the keyring
+	// doesn't have
+	ErrUnknownKey = errors.New("encryption: value sealed with an unknown key")
+	// ErrDecrypt is returned for values that fail authentication
+	ErrDecrypt = errors.New("encryption: value can't be decrypted")
+)
+
+// keyID is the syntax of key IDs, which must not contain the separator of
+// stored values
+var keyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// active is the keyring of the installed plugin. Serializers are registered
+// globally by GORM, so the keyring they use is global too.
+var active atomic.Pointer[Keyring]
+
+func init() {
+	schema.RegisterSerializer(Serializer, serializer{})
+}
+
+// ===================================================================
+// Keyring
+// ===================================================================
+
+// Keyring holds the AES keys by ID, the ID of the primary key that seals new
+// values, and the HMAC key of blind indexes. The index key can't be rotated
+// by Reencrypt: changing it requires recomputing every blind index.
+type Keyring struct {
+	keys     map[string]cipher.AEAD
+	primary  string
+	indexKey []byte
+}
+
+// NewKeyring creates a keyring from AES-256 keys of 32 bytes by ID, the ID of
+// the primary key and an index key of at least 32 bytes
+func NewKeyring(keys map[string][]byte, primary string, indexKey []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD, len(keys)), primary: primary, indexKey: indexKey}
+	for id, key := range keys {
+		if !keyID.MatchString(id) {
+			return nil, fmt.Errorf("encryption: invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption: key %s must be 32 bytes, got %d", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+	}
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf("encryption: primary key %q is not in the keyring", primary)
+	}
+	if len(indexKey) < 32 {
+		return nil, fmt.Errorf("encryption: index key must be at least 32 bytes, got %d", len(indexKey))
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key that seals new values
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Encrypt seals plaintext with the primary key. The additional data binds it
+// to where it is stored, so it can't be copied to another column.
+func (k *Keyring) Encrypt(plaintext, additionalData string) (string, error) {
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(additionalData))
+	return prefix + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with any key of the keyring.
+// Values without the prefix are plaintext written before encryption.
+func (k *Keyring) Decrypt(value, additionalData string) (string, error) {
+	id, encoded, ok := parse(value)
+	if !ok {
+		return value, nil
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(additionalData))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
+
+// Seal encrypts value like Encrypt, for values kept outside the database
+// such as cache entries. The additional data names where it is kept.
+func (k *Keyring) Seal(value []byte, additionalData string) ([]byte, error) {
+	sealed, err := k.Encrypt(string(value), additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(sealed), nil
+}
+
+// Open decrypts a value sealed by Seal. Unlike Decrypt, it fails on values
+// that aren't encrypted.
+func (k *Keyring) Open(sealed []byte, additionalData string) ([]byte, error) {
+	if !strings.HasPrefix(string(sealed), prefix) {
+		return nil, ErrDecrypt
+	}
+	value, err := k.Decrypt(string(sealed), additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// Index returns the blind index of value: the hex HMAC-SHA256 of it under the
+// index key. Equal values have equal indexes, and nothing else can be learnt
+// from them without the key.
+func (k *Keyring) Index(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parse splits an encrypted value into its key ID and payload
+func parse(value string) (id, payload string, ok bool) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// BlindIndex returns the blind index of value under the installed keyring,
+// for exact-match lookups of encrypted columns:
+//
+//	db.Where("email_index = ?", encryption.BlindIndex(email))
+//
+// It returns "", which matches nothing, when no keyring is installed.
+func BlindIndex(value string) string {
+	k := active.Load()
+	if k == nil {
+		return ""
+	}
+	return k.Index(value)
+}
+
+// ===================================================================
+// Serializer
+// ===================================================================
+
+// serializer encrypts string and *string fields with the installed keyring
+type serializer struct{}
+
+// Scan implements schema.SerializerInterface
+func (serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	value := reflect.New(field.FieldType).Elem()
+	if dbValue != nil {
+		var stored string
+		switch v := dbValue.(type) {
+		case string:
+			stored = v
+		case []byte:
+			stored = string(v)
+		default:
+			return fmt.Errorf("encryption: can't scan %T into %s", dbValue, field.Name)
+		}
+
+		k := active.Load()
+		if k == nil {
+			return ErrNoKeyring
+		}
+		plaintext, err := k.Decrypt(stored, additionalData(field))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", field.Schema.Table, field.DBName, err)
+		}
+		setString(value, plaintext)
+	}
+	field.ReflectValueOf(ctx, dst).Set(value)
+	return nil
+}
+
+// Value implements schema.SerializerValuerInterface
+func (serializer) Value(_ context.Context, field *schema.Field, _ reflect.Value, fieldValue any) (any, error) {
+	return encrypt(field, fieldValue)
+}
+
+// encrypt seals a string or *string value of field; nil stays NULL
+func encrypt(field *schema.Field, value any) (any, error) {
+	plaintext, ok, err := toString(field, value)
+	if err != nil || !ok {
+		return nil, err
+	}
+	k := active.Load()
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+	return k.Encrypt(plaintext, additionalData(field))
+}
+
+// additionalData binds values to their table and column
+func additionalData(field *schema.Field) string {
+	return field.Schema.Table + "." + field.DBName
+}
+
+// toString returns the plaintext of a string or *string value, and false for
+// nil
+func toString(field *schema.Field, value any) (string, bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	case *string:
+		if v == nil {
+			return "", false, nil
+		}
+		return *v, true, nil
+	default:
+		return "", false, fmt.Errorf("encryption: %s must be a string or *string, got %T", field.Name, value)
+	}
+}
+
+// setString stores s in v, a string or *string
+func setString(v reflect.Value, s string) {
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	v.SetString(s)
+}
+
+// ===================================================================
+// GORM Plugin
+// ===================================================================
+
+// GORMPlugin installs the keyring of the encrypted serializer and keeps blind
+// indexes up to date on creates and updates. It also encrypts the values of
+// maps of updates, which GORM passes to the database without serializing.
+type GORMPlugin struct {
+	keys *Keyring
+}
+
+// NewGORMPlugin creates the plugin
+func NewGORMPlugin(keys *Keyring) *GORMPlugin {
+	return &GORMPlugin{keys: keys}
+}
+
+// Name implements gorm.Plugin
+func (p *GORMPlugin) Name() string {
+	return "encryption"
+}
+
+// Initialize installs the keyring and prepares the columns of creates and
+// updates. That must happen before other plugins build the SET clause of
+// updates, as locking does, so install this plugin first.
+func (p *GORMPlugin) Initialize(db *gorm.DB) error {
+	active.Store(p.keys)
+
+	cb := db.Callback()
+	return gormutil.Register([]gormutil.Hook{
+		{Register: cb.Create().Before("gorm:create").Register, Name: "encryption:before_create", Fn: p.prepare},
+		{Register: cb.Update().Before("gorm:update").Register, Name: "encryption:before_update", Fn: p.prepare},
+	})
+}
+
+// encryptedField is a field of the encrypted serializer and the field of its
+// blind index, if any
+type encryptedField struct {
+	field *schema.Field
+	index *schema.Field
+}
+
+// encryptedFields returns the encrypted fields of s
+func encryptedFields(s *schema.Schema) ([]encryptedField, error) {
+	var fields []encryptedField
+	for _, field := range s.Fields {
+		if !strings.EqualFold(field.TagSettings["SERIALIZER"], Serializer) {
+			continue
+		}
+		f := encryptedField{field: field}
+		if name := field.TagSettings["BLINDINDEX"]; name != "" {
+			if f.index = s.LookUpField(name); f.index == nil {
+				return nil, fmt.Errorf("encryption: blind index %s of %s.%s is not a field", name, s.Name, field.Name)
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// prepare fills in the blind indexes of the records or map being written,
+// and encrypts the encrypted values of maps
+func (p *GORMPlugin) prepare(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	fields, err := encryptedFields(stmt.Schema)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	if values, ok := stmt.Dest.(map[string]any); ok {
+		_ = db.AddError(p.prepareMap(fields, values))
+		return
+	}
+
+	rv := reflect.ValueOf(stmt.Dest)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Struct && !rv.CanAddr() {
+		// Updates of a struct value: write from an addressable copy so that
+		// its indexes can be set
+		copied := reflect.New(rv.Type())
+		copied.Elem().Set(rv)
+		stmt.Dest = copied.Interface()
+		rv = copied.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		_ = db.AddError(p.prepareRecord(stmt, fields, rv))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := p.prepareRecord(stmt, fields, reflect.Indirect(rv.Index(i))); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+		}
+	}
+
+	// Updates of selected columns must write the indexes of those selected
+	if len(stmt.Selects) > 0 {
+		for _, f := range fields {
+			if f.index != nil && selected(stmt, f.field) && !selected(stmt, f.index) {
+				stmt.Selects = append(stmt.Selects, f.index.DBName)
+			}
+		}
+	}
+}
+
+// prepareRecord sets the blind indexes of a record. The serializer encrypts
+// its values.
+func (p *GORMPlugin) prepareRecord(stmt *gorm.Statement, fields []encryptedField, rv reflect.Value) error {
+	if rv.Kind() != reflect.Struct || rv.Type() != stmt.Schema.ModelType {
+		return nil
+	}
+	for _, f := range fields {
+		if f.index == nil {
+			continue
+		}
+		plaintext, ok, err := toString(f.field, f.field.ReflectValueOf(stmt.Context, rv).Interface())
+		if err != nil {
+			return err
+		}
+		if err := setIndex(stmt.Context, f.index, rv, p.index(plaintext, ok)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareMap encrypts the encrypted values of a map of updates, keyed by
+// column or field name, and adds their blind indexes
+func (p *GORMPlugin) prepareMap(fields []encryptedField, values map[string]any) error {
+	for _, f := range fields {
+		for _, key := range []string{f.field.DBName, f.field.Name} {
+			value, ok := values[key]
+			if !ok {
+				continue
+			}
+			if _, isExpr := value.(clause.Expression); isExpr {
+				return fmt.Errorf("encryption: %s can't be set to an SQL expression", f.field.Name)
+			}
+
+			plaintext, notNull, err := toString(f.field, value)
+			if err != nil {
+				return err
+			}
+			if values[key], err = encrypt(f.field, value); err != nil {
+				return err
+			}
+			if f.index != nil {
+				values[f.index.DBName] = p.index(plaintext, notNull)
+			}
+		}
+	}
+	return nil
+}
+
+// index returns the blind index of plaintext, or nil for NULL
+func (p *GORMPlugin) index(plaintext string, notNull bool) *string {
+	if !notNull {
+		return nil
+	}
+	index := p.keys.Index(plaintext)
+	return &index
+}
+
+// setIndex stores index in the field, a string or *string
+func setIndex(ctx context.Context, field *schema.Field, rv reflect.Value, index *string) error {
+	if field.FieldType.Kind() == reflect.Ptr {
+		return field.Set(ctx, rv, index)
+	}
+	if index == nil {
+		return field.Set(ctx, rv, "")
+	}
+	return field.Set(ctx, rv, *index)
+}
+
+// selected reports whether the statement selects field
+func selected(stmt *gorm.Statement, field *schema.Field) bool {
+	for _, s := range stmt.Selects {
+		if s == "*" || s == field.Name || s == field.DBName {
+			return true
+		}
+	}
+	return false
+}
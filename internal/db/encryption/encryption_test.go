@@ -0,0 +1,252 @@
+package encryption_test
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm-reference/internal/db/encryption"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// account is the model of the plugin tests: an encrypted email with a blind
+// index and an encrypted name without one
+type account struct {
+	ID         uint
+	Email      *string `gorm:"type:text;serializer:encrypted;blindIndex:email_index"`
+	EmailIndex *string
+	Name       string `gorm:"type:text;serializer:encrypted"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := newKeyring(t, "k1", "k1")
+
+	sealed, err := k.Encrypt("alice@example.com", "users.email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, "enc:k1:") || strings.Contains(sealed, "alice") {
+		t.Fatalf("Encrypt = %q, want a value sealed with k1", sealed)
+	}
+
+	again, _ := k.Encrypt("alice@example.com", "users.email")
+	if again == sealed {
+		t.Error("equal plaintexts sealed to equal values; nonces must differ")
+	}
+
+	plaintext, err := k.Decrypt(sealed, "users.email")
+	if err != nil || plaintext != "alice@example.com" {
+		t.Errorf("Decrypt = %q, %v", plaintext, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old := newKeyring(t, "k1", "k1")
+	sealed, err := old.Encrypt("alice", "users.first_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newKeyring(t, "k2", "k1", "k2")
+	plaintext, err := rotated.Decrypt(sealed, "users.first_name")
+	if err != nil || plaintext != "alice" {
+		t.Errorf("Decrypt of a value sealed with the old key = %q, %v", plaintext, err)
+	}
+
+	resealed, err := rotated.Encrypt("alice", "users.first_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resealed, "enc:k2:") {
+		t.Errorf("Encrypt after rotation = %q, want a value sealed with the primary key k2", resealed)
+	}
+
+	if old.Index("alice") != rotated.Index("alice") {
+		t.Error("blind index changed with the encryption keys")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	k := newKeyring(t, "k1", "k1")
+	sealed, err := k.Encrypt("alice@example.com", "users.email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a bit of the ciphertext, past the prefix and nonce
+	raw := []byte(sealed)
+	raw[len(raw)-4] ^= 1
+
+	tests := []struct {
+		name           string
+		keys           *encryption.Keyring
+		value          string
+		additionalData string
+		want           error
+	}{
+		{"other column", k, sealed, "users.first_name", encryption.ErrDecrypt},
+		{"other table", k, sealed, "profiles.email", encryption.ErrDecrypt},
+		{"tampered", k, string(raw), "users.email", encryption.ErrDecrypt},
+		{"not base64", k, "enc:k1:???", "users.email", encryption.ErrDecrypt},
+		{"unknown key", newKeyring(t, "k2", "k2"), sealed, "users.email", encryption.ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.keys.Decrypt(tt.value, tt.additionalData); !errors.Is(err, tt.want) {
+				t.Errorf("Decrypt error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecryptPassesPlaintextThrough(t *testing.T) {
+	k := newKeyring(t, "k1", "k1")
+
+	// Rows written before the column was encrypted
+	plaintext, err := k.Decrypt("alice@example.com", "users.email")
+	if err != nil || plaintext != "alice@example.com" {
+		t.Errorf("Decrypt of plaintext = %q, %v", plaintext, err)
+	}
+
+	// Cache entries, unlike columns, must be sealed
+	if _, err := k.Open([]byte("alice@example.com"), "key"); !errors.Is(err, encryption.ErrDecrypt) {
+		t.Errorf("Open of plaintext error = %v, want %v", err, encryption.ErrDecrypt)
+	}
+}
+
+func TestCreateSetsBlindIndex(t *testing.T) {
+	k := newKeyring(t, "k1", "k1")
+	db := openDryRun(t, k)
+
+	email := "alice@example.com"
+	record := account{Email: &email, Name: "Alice"}
+	stmt := db.Create(&record).Statement
+
+	if record.EmailIndex == nil || *record.EmailIndex != k.Index(email) {
+		t.Errorf("EmailIndex = %v, want the blind index of the email", record.EmailIndex)
+	}
+	assertEncrypted(t, k, stmt.Vars, "accounts.email", email)
+	assertEncrypted(t, k, stmt.Vars, "accounts.name", "Alice")
+}
+
+func TestUpdateOfSelectedColumnsWritesBlindIndex(t *testing.T) {
+	k := newKeyring(t, "k1", "k1")
+	db := openDryRun(t, k)
+
+	email := "bob@example.com"
+	stmt := db.Model(&account{ID: 1}).Select("Email").Updates(account{Email: &email}).Statement
+
+	sql := stmt.SQL.String()
+	if !strings.Contains(sql, `"email_index"=`) {
+		t.Fatalf("update of the selected email doesn't set its blind index: %s", sql)
+	}
+	if !contains(stmt.Vars, k.Index(email)) {
+		t.Errorf("vars %v lack the blind index of the email", stmt.Vars)
+	}
+	assertEncrypted(t, k, stmt.Vars, "accounts.email", email)
+}
+
+func TestUpdateWithMap(t *testing.T) {
+	k := newKeyring(t, "k1", "k1")
+	db := openDryRun(t, k)
+
+	email := "carol@example.com"
+	stmt := db.Model(&account{ID: 1}).Updates(map[string]any{"email": email, "Name": "Carol"}).Statement
+	if stmt.Error != nil {
+		t.Fatal(stmt.Error)
+	}
+	if !contains(stmt.Vars, k.Index(email)) {
+		t.Errorf("vars %v lack the blind index of the email", stmt.Vars)
+	}
+	assertEncrypted(t, k, stmt.Vars, "accounts.email", email)
+	assertEncrypted(t, k, stmt.Vars, "accounts.name", "Carol")
+
+	// Setting the email to NULL clears its index
+	stmt = db.Model(&account{ID: 1}).Updates(map[string]any{"email": nil}).Statement
+	if !strings.Contains(stmt.SQL.String(), `"email_index"=`) {
+		t.Errorf("update setting the email to NULL leaves its index: %s", stmt.SQL.String())
+	}
+
+	err := db.Model(&account{ID: 1}).Updates(map[string]any{"email": gorm.Expr("lower(email)")}).Error
+	if err == nil || !strings.Contains(err.Error(), "SQL expression") {
+		t.Errorf("update with an SQL expression error = %v, want a refusal", err)
+	}
+}
+
+func newKeyring(t *testing.T, primary string, ids ...string) *encryption.Keyring {
+	t.Helper()
+	keys := make(map[string][]byte, len(ids))
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	k, err := encryption.NewKeyring(keys, primary, bytes.Repeat([]byte{0xff}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// openDryRun returns a database that builds statements without running them
+func openDryRun(t *testing.T, k *encryption.Keyring) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(encryption.NewGORMPlugin(k)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// assertEncrypted checks that one of vars is plaintext sealed for the column
+// named by additionalData, and that plaintext itself is not
+func assertEncrypted(t *testing.T, k *encryption.Keyring, vars []any, additionalData, plaintext string) {
+	t.Helper()
+	if contains(vars, plaintext) {
+		t.Errorf("vars %v hold %q in the clear", vars, plaintext)
+	}
+	for _, v := range vars {
+		// The serializer encrypts when the driver asks for the value
+		if valuer, ok := v.(driver.Valuer); ok {
+			var err error
+			if v, err = valuer.Value(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sealed, ok := v.(string)
+		if !ok || !strings.HasPrefix(sealed, "enc:") {
+			continue
+		}
+		if got, err := k.Decrypt(sealed, additionalData); err == nil && got == plaintext {
+			return
+		}
+	}
+	t.Errorf("vars %v lack %q sealed for %s", vars, plaintext, additionalData)
+}
+
+func contains(vars []any, want string) bool {
+	for _, v := range vars {
+		switch v := v.(type) {
+		case string:
+			if v == want {
+				return true
+			}
+		case *string:
+			if v != nil && *v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm-reference/internal/db/locking"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reencrypt rewrites, batchSize rows at a time, the rows of the models whose
+// encrypted columns hold plaintext or values sealed with a key other than
+// the primary one, or whose blind indexes are missing. It returns how many
+// rows it rewrote. Soft-deleted rows are rewritten too.
+//
+// Each row is rewritten by its own update, so updates the application makes
+// meanwhile are never overwritten: a row that changed since it was read is
+// skipped when its model is versioned, and left for the next run. Once a run
+// rewrites nothing, keys other than the primary one can be removed.
+func Reencrypt(ctx context.Context, db *gorm.DB, batchSize int, models ...any) (int64, error) {
+	k := active.Load()
+	if k == nil {
+		return 0, ErrNoKeyring
+	}
+
+	var rewritten int64
+	for _, model := range models {
+		n, err := reencryptModel(ctx, db.WithContext(ctx), k, batchSize, model)
+		rewritten += n
+		if err != nil {
+			return rewritten, err
+		}
+	}
+	return rewritten, nil
+}
+
+func reencryptModel(ctx context.Context, db *gorm.DB, k *Keyring, batchSize int, model any) (int64, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return 0, err
+	}
+	s := stmt.Schema
+	fields, err := encryptedFields(s)
+	if err != nil || len(fields) == 0 {
+		return 0, err
+	}
+	pk := s.PrioritizedPrimaryField
+	if pk == nil {
+		return 0, fmt.Errorf("encryption: %s has no primary key to rewrite its rows by", s.Name)
+	}
+
+	// The rows that need rewriting
+	current := prefix + k.Primary() + ":%"
+	var (
+		stale   []clause.Expression
+		selects []string
+	)
+	for _, f := range fields {
+		column := clause.Column{Table: clause.CurrentTable, Name: f.field.DBName}
+		stale = append(stale, clause.Expr{SQL: "(? IS NOT NULL AND ? NOT LIKE ?)", Vars: []any{column, column, current}})
+		selects = append(selects, f.field.Name)
+		if f.index != nil {
+			index := clause.Column{Table: clause.CurrentTable, Name: f.index.DBName}
+			stale = append(stale, clause.Expr{SQL: "(? IS NULL AND ? IS NOT NULL)", Vars: []any{index, column}})
+		}
+	}
+
+	var rewritten int64
+	var last any
+	for {
+		query := db.Unscoped().Model(model).Where(clause.Or(stale...)).Order(clause.OrderByColumn{
+			Column: clause.Column{Table: clause.CurrentTable, Name: pk.DBName},
+		}).Limit(batchSize)
+		if last != nil {
+			query = query.Where(clause.Gt{Column: clause.Column{Table: clause.CurrentTable, Name: pk.DBName}, Value: last})
+		}
+
+		records := reflect.New(reflect.SliceOf(s.ModelType))
+		if err := query.Find(records.Interface()).Error; err != nil {
+			return rewritten, err
+		}
+		rows := records.Elem()
+		if rows.Len() == 0 {
+			return rewritten, nil
+		}
+
+		for i := 0; i < rows.Len(); i++ {
+			record := rows.Index(i).Addr().Interface()
+			last, _ = pk.ValueOf(ctx, rows.Index(i))
+
+			// UpdateColumns leaves updated_at alone: the data didn't change.
+			// The plugin adds the blind indexes to the selected columns.
+			err := db.Unscoped().Model(record).Select(selects).UpdateColumns(record).Error
+			switch {
+			case err == nil:
+				rewritten++
+			case errors.Is(err, locking.ErrStale):
+				// Changed meanwhile
+			default:
+				return rewritten, fmt.Errorf("failed to rewrite %s %v: %w", s.Name, last, err)
+			}
+		}
+		if rows.Len() < batchSize {
+			return rewritten, nil
+		}
+	}
+}
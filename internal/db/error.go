@@ -2,10 +2,10 @@ package db
 
 import (
 	"errors"
-	"fmt"
-	"strings"
 
-	"gorm.io/gorm"
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/db/cascade"
+	"gorm-reference/internal/db/locking"
 )
 
 // ==========================================================
@@ -13,34 +13,26 @@ import (
 // Properly handle and wrap GORM errors for better debugging.
 // ==========================================================
 
-// Custom error types for domain-specific errors
-var (
-	ErrUserNotFound      = errors.New("user not found")
-	ErrDuplicateEmail    = errors.New("email already exists")
-	ErrDuplicateUsername = errors.New("username already exists")
-)
-
-// HandleGORMError converts GORM errors to domain errors
+// HandleGORMError converts GORM errors to domain errors.
+// Domain errors live in the apperror package so that the repository,
+// service and handler layers all share the same sentinels.
 func HandleGORMError(err error) error {
-	if err == nil {
-		return nil
-	}
-
-	// Check for record not found
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return ErrUserNotFound
-	}
+	return AppError(err, apperror.ErrUserNotFound)
+}
 
-	// Check for duplicate key violation (PostgreSQL)
-	if strings.Contains(err.Error(), "duplicate key") {
-		if strings.Contains(err.Error(), "email") {
-			return ErrDuplicateEmail
-		}
-		if strings.Contains(err.Error(), "username") {
-			return ErrDuplicateUsername
-		}
+// AppError converts database errors into the taxonomy like apperror.FromDB,
+// and the errors of the plugins Open installs as well, which apperror
+// doesn't know about so that it depends on no database package of ours.
+// notFound is returned for gorm.ErrRecordNotFound.
+func AppError(err error, notFound *apperror.Error) error {
+	var appErr *apperror.Error
+	switch {
+	case errors.As(err, &appErr):
+		return err
+	case errors.Is(err, locking.ErrStale):
+		return apperror.ErrVersionConflict.Wrap(err)
+	case errors.Is(err, cascade.ErrRestricted):
+		return apperror.ErrReferenced.Wrap(err)
 	}
-
-	// Return wrapped error for unexpected errors
-	return fmt.Errorf("database error: %w", err)
+	return apperror.FromDB(err, notFound)
 }
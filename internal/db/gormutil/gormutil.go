@@ -0,0 +1,51 @@
+// Package gormutil registers the callbacks of the GORM plugins of this
+// repository.
+package gormutil
+
+import "gorm.io/gorm"
+
+// Hook is a callback and where it runs. Register is the Register method of a
+// positioned callback, e.g. db.Callback().Query().Before("gorm:query").Register.
+type Hook struct {
+	Register func(name string, fn func(*gorm.DB)) error
+	Name     string
+	Fn       func(*gorm.DB)
+}
+
+// Register registers hooks in order and stops at the first error
+func Register(hooks []Hook) error {
+	for _, h := range hooks {
+		if err := h.Register(h.Name, h.Fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Around returns the hooks that run before and after each of GORM's
+// operations: create, query, update, delete, row and raw. They are named
+// <plugin>:before_<operation> and <plugin>:after_<operation>, and before and
+// after return their callbacks by operation.
+func Around(db *gorm.DB, plugin string, before, after func(operation string) func(*gorm.DB)) []Hook {
+	cb := db.Callback()
+	operations := []struct {
+		name          string
+		before, after func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+
+	hooks := make([]Hook, 0, 2*len(operations))
+	for _, op := range operations {
+		hooks = append(hooks,
+			Hook{op.before, plugin + ":before_" + op.name, before(op.name)},
+			Hook{op.after, plugin + ":after_" + op.name, after(op.name)},
+		)
+	}
+	return hooks
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"time"
 
+	"gorm-reference/internal/health"
+	"gorm-reference/internal/metrics"
+
 	"gorm.io/gorm"
 )
 
@@ -14,16 +17,14 @@ import (
 // Implement health checks for production deployments.
 // ===================================================
 
-// HealthCheck verifies database connectivity
-func HealthCheck(db *gorm.DB) error {
+// HealthCheck verifies database connectivity. The caller bounds the ping
+// through ctx.
+func HealthCheck(ctx context.Context, db *gorm.DB) error {
 	sqlDB, err := db.DB()
 	if err != nil {
 		return fmt.Errorf("failed to get database instance: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	if err := sqlDB.PingContext(ctx); err != nil {
 		return fmt.Errorf("database ping failed: %w", err)
 	}
@@ -31,11 +32,129 @@ func HealthCheck(db *gorm.DB) error {
 	return nil
 }
 
-// GetDBStats returns database connection pool statistics
+// GetDBStats returns database connection pool statistics, those of the
+// pgxpool under the pools Open opens
 func GetDBStats(db *gorm.DB) (sql.DBStats, error) {
+	if pool, ok := db.ConnPool.(metrics.StatsReporter); ok {
+		return pool.Stats(), nil
+	}
 	sqlDB, err := db.DB()
 	if err != nil {
 		return sql.DBStats{}, err
 	}
 	return sqlDB.Stats(), nil
 }
+
+// PingCheck is a readiness check that pings the database
+func PingCheck(name string, db *gorm.DB) health.Check {
+	return health.Check{
+		Name: name,
+		Run: func(ctx context.Context) (any, error) {
+			return nil, HealthCheck(ctx, db)
+		},
+	}
+}
+
+// MigrationCheck is a readiness check that fails while embedded migrations
+// are pending, so traffic only reaches instances whose schema is current
+func MigrationCheck(db *gorm.DB) health.Check {
+	return health.Check{
+		Name: "migrations",
+		Run: func(ctx context.Context) (any, error) {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get database instance: %w", err)
+			}
+			m, err := NewMigrator(sqlDB)
+			if err != nil {
+				return nil, err
+			}
+
+			pending, err := m.Pending(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(pending) > 0 {
+				versions := make([]int64, len(pending))
+				for i, mig := range pending {
+					versions[i] = mig.Version
+				}
+				return map[string]any{"pending": versions}, fmt.Errorf("%d pending migrations", len(pending))
+			}
+			return nil, nil
+		},
+	}
+}
+
+// PoolCheck is a readiness check that fails when more than maxUsage of the
+// pool's MaxOpenConns is in use. An unlimited pool never saturates.
+func PoolCheck(name string, db *gorm.DB, maxUsage float64) health.Check {
+	return health.Check{
+		Name: name,
+		Run: func(ctx context.Context) (any, error) {
+			stats, err := GetDBStats(db)
+			if err != nil {
+				return nil, err
+			}
+
+			details := map[string]any{
+				"maxOpen":        stats.MaxOpenConnections,
+				"open":           stats.OpenConnections,
+				"inUse":          stats.InUse,
+				"idle":           stats.Idle,
+				"waitCount":      stats.WaitCount,
+				"waitDurationMs": stats.WaitDuration.Milliseconds(),
+			}
+			if stats.MaxOpenConnections <= 0 {
+				return details, nil
+			}
+
+			usage := float64(stats.InUse) / float64(stats.MaxOpenConnections)
+			details["usage"] = usage
+			if usage > maxUsage {
+				return details, fmt.Errorf("pool usage %.0f%% is above %.0f%%", usage*100, maxUsage*100)
+			}
+			return details, nil
+		},
+	}
+}
+
+// ReplicaLagCheck is a readiness check that fails when the replica behind db
+// replays the primary's changes more than maxLag late. A replica that has
+// replayed everything it received reports no lag, even if the primary has
+// been idle. Run against a primary, it always passes.
+func ReplicaLagCheck(name string, db *gorm.DB, maxLag time.Duration) health.Check {
+	return health.Check{
+		Name: name,
+		Run: func(ctx context.Context) (any, error) {
+			var seconds float64
+			err := db.WithContext(ctx).Raw(`
+                SELECT CASE
+                    WHEN NOT pg_is_in_recovery() THEN 0
+                    WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+                    ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+                END
+            `).Scan(&seconds).Error
+			if err != nil {
+				return nil, fmt.Errorf("failed to read replication lag: %w", err)
+			}
+
+			lag := time.Duration(seconds * float64(time.Second))
+			details := map[string]any{"lagMs": lag.Milliseconds()}
+			if lag > maxLag {
+				return details, fmt.Errorf("replication lag %s is above %s", lag.Round(time.Millisecond), maxLag)
+			}
+			return details, nil
+		},
+	}
+}
+
+// ReadinessChecks returns the checks for the primary database. Replicas are
+// reported through ReplicaSet.Checks.
+func ReadinessChecks(db *gorm.DB, maxPoolUsage float64) []health.Check {
+	return []health.Check{
+		PingCheck("database", db),
+		MigrationCheck(db),
+		PoolCheck("database_pool", db, maxPoolUsage),
+	}
+}
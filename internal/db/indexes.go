@@ -1,56 +1,24 @@
 package db
 
 import (
+	"gorm-reference/internal/db/indexes"
 	"gorm-reference/internal/models"
 
 	"gorm.io/gorm"
 )
 
-// CreateIndexes manually creates indexes using GORM's Migrator
+// CreateIndexes creates the indexes the models declare through Indexes()
+// that don't exist yet. It is safe to run on every deploy; concurrent indexes
+// are built without blocking writes, so db must not be a transaction.
 func CreateIndexes(db *gorm.DB) error {
-	migrator := db.Migrator()
-
-	// Create a composite index
-	if !migrator.HasIndex(&models.User{}, "idx_users_name") {
-		err := migrator.CreateIndex(&models.User{}, "idx_users_name")
-		if err != nil {
-			return err
-		}
-	}
-
-	// Create a partial index using raw SQL
-	err := db.Exec(`
-        CREATE INDEX IF NOT EXISTS idx_active_users
-        ON users(email)
-        WHERE is_active = true AND deleted_at IS NULL
-    `).Error
-	if err != nil {
-		return err
-	}
-
-	return nil
+	_, err := indexes.ReconcileIndexes(db.Statement.Context, db, models.All()...)
+	return err
 }
 
-// AddConstraints adds foreign key and check constraints
+// AddConstraints adds the constraints the models declare through
+// Constraints() that don't exist yet. Foreign keys come from the associations
+// and are created by the migrations.
 func AddConstraints(db *gorm.DB) error {
-	// Add foreign key constraint with cascade
-	err := db.Exec(`
-        ALTER TABLE posts
-        ADD CONSTRAINT fk_posts_user
-        FOREIGN KEY (user_id)
-        REFERENCES users(id)
-        ON DELETE CASCADE
-    `).Error
-	if err != nil {
-		return err
-	}
-
-	// Add check constraint
-	err = db.Exec(`
-        ALTER TABLE users
-        ADD CONSTRAINT chk_email_format
-        CHECK (email ~* '^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$')
-    `).Error
-
+	_, err := indexes.ReconcileConstraints(db.Statement.Context, db, models.All()...)
 	return err
 }
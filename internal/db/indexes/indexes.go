@@ -0,0 +1,97 @@
+// Package indexes manages the indexes and constraints that GORM struct tags
+// cannot express, such as partial, expression, GIN and concurrently built
+// indexes. Models declare them by implementing Indexer or Constrainer, and
+// Reconcile creates whatever is missing.
+package indexes
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Index declares a single index
+type Index struct {
+	Name string
+
+	// Columns holds column names or expressions such as "lower(email)"
+	Columns []string
+
+	Unique bool
+
+	// Using is the access method: btree (the default), gin, gist, brin or hash
+	Using string
+
+	// Where turns the index into a partial index
+	Where string
+
+	// Concurrent builds the index with CREATE INDEX CONCURRENTLY so the table
+	// stays writable. Such indexes can't be created inside a transaction.
+	Concurrent bool
+}
+
+// Constraint declares a table constraint by its full definition, e.g.
+// "CHECK (price >= 0)" or "FOREIGN KEY (user_id) REFERENCES users(id)"
+type Constraint struct {
+	Name       string
+	Definition string
+}
+
+// Indexer is implemented by models that declare indexes
+type Indexer interface {
+	Indexes() []Index
+}
+
+// Constrainer is implemented by models that declare constraints
+type Constrainer interface {
+	Constraints() []Constraint
+}
+
+// CreateSQL renders the CREATE INDEX statement. concurrently is ignored for
+// indexes that are not declared Concurrent.
+func (i Index) CreateSQL(table string, concurrently bool) string {
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if i.Unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	if concurrently && i.Concurrent {
+		b.WriteString("CONCURRENTLY ")
+	}
+	fmt.Fprintf(&b, "IF NOT EXISTS %s ON %s", i.Name, table)
+	if i.Using != "" {
+		b.WriteString(" USING " + i.Using)
+	}
+	fmt.Fprintf(&b, " (%s)", strings.Join(i.Columns, ", "))
+	if i.Where != "" {
+		b.WriteString(" WHERE " + i.Where)
+	}
+	return b.String()
+}
+
+// AddSQL renders the ALTER TABLE statement that adds the constraint
+func (c Constraint) AddSQL(table string) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", table, c.Name, c.Definition)
+}
+
+// Declared returns the indexes and constraints declared by model, which may
+// be a value, a pointer or a reflect.Type of the model
+func Declared(model any) ([]Index, []Constraint) {
+	if t, ok := model.(reflect.Type); ok {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		model = reflect.New(t).Interface()
+	}
+
+	var idx []Index
+	var cons []Constraint
+	if i, ok := model.(Indexer); ok {
+		idx = i.Indexes()
+	}
+	if c, ok := model.(Constrainer); ok {
+		cons = c.Constraints()
+	}
+	return idx, cons
+}
@@ -0,0 +1,167 @@
+package indexes
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Action is what Reconcile did with a declared index or constraint
+type Action string
+
+const (
+	Unchanged Action = "unchanged"
+	Created   Action = "created"
+
+	// Rebuilt means an invalid index, left behind by a failed concurrent
+	// build, was dropped and created again
+	Rebuilt Action = "rebuilt"
+)
+
+// Result reports what happened to one declared object
+type Result struct {
+	Table  string
+	Name   string
+	Kind   string // "index" or "constraint"
+	Action Action
+}
+
+// Reconcile creates the declared indexes and constraints of models that are
+// missing from the database's current schema. It only adds what is missing,
+// so running it again is a no-op. Concurrent indexes are built outside a
+// transaction; Reconcile fails if db is a transaction and one is needed.
+func Reconcile(ctx context.Context, db *gorm.DB, models ...any) ([]Result, error) {
+	indexResults, err := ReconcileIndexes(ctx, db, models...)
+	if err != nil {
+		return indexResults, err
+	}
+	constraintResults, err := ReconcileConstraints(ctx, db, models...)
+	return append(indexResults, constraintResults...), err
+}
+
+// ReconcileIndexes creates the declared indexes that are missing
+func ReconcileIndexes(ctx context.Context, db *gorm.DB, models ...any) ([]Result, error) {
+	db = db.WithContext(ctx)
+
+	var results []Result
+	for _, model := range models {
+		table, err := tableName(db, model)
+		if err != nil {
+			return results, err
+		}
+
+		declared, _ := Declared(model)
+		for _, idx := range declared {
+			action, err := reconcileIndex(db, table, idx)
+			if err != nil {
+				return results, fmt.Errorf("failed to reconcile index %s on %s: %w", idx.Name, table, err)
+			}
+			results = append(results, Result{Table: table, Name: idx.Name, Kind: "index", Action: action})
+		}
+	}
+	return results, nil
+}
+
+// ReconcileConstraints adds the declared constraints that are missing
+func ReconcileConstraints(ctx context.Context, db *gorm.DB, models ...any) ([]Result, error) {
+	db = db.WithContext(ctx)
+
+	var results []Result
+	for _, model := range models {
+		table, err := tableName(db, model)
+		if err != nil {
+			return results, err
+		}
+
+		_, declared := Declared(model)
+		for _, con := range declared {
+			action, err := reconcileConstraint(db, table, con)
+			if err != nil {
+				return results, fmt.Errorf("failed to reconcile constraint %s on %s: %w", con.Name, table, err)
+			}
+			results = append(results, Result{Table: table, Name: con.Name, Kind: "constraint", Action: action})
+		}
+	}
+	return results, nil
+}
+
+func reconcileIndex(db *gorm.DB, table string, idx Index) (Action, error) {
+	var state struct {
+		Found bool `gorm:"column:found"`
+		Valid bool `gorm:"column:valid"`
+	}
+	err := db.Raw(`
+        SELECT true AS found, ix.indisvalid AS valid
+        FROM pg_index ix
+        JOIN pg_class i ON i.oid = ix.indexrelid
+        JOIN pg_namespace n ON n.oid = i.relnamespace
+        WHERE n.nspname = current_schema() AND i.relname = ?
+    `, idx.Name).Scan(&state).Error
+	if err != nil {
+		return "", err
+	}
+	if state.Found && state.Valid {
+		return Unchanged, nil
+	}
+
+	if idx.Concurrent && inTransaction(db) {
+		return "", fmt.Errorf("concurrent indexes can't be built inside a transaction")
+	}
+
+	action := Created
+	if state.Found {
+		// A failed CREATE INDEX CONCURRENTLY leaves an invalid index behind
+		// that IF NOT EXISTS would happily skip
+		drop := "DROP INDEX IF EXISTS " + idx.Name
+		if idx.Concurrent {
+			drop = "DROP INDEX CONCURRENTLY IF EXISTS " + idx.Name
+		}
+		if err := db.Exec(drop).Error; err != nil {
+			return "", err
+		}
+		action = Rebuilt
+	}
+
+	if err := db.Exec(idx.CreateSQL(table, true)).Error; err != nil {
+		return "", err
+	}
+	return action, nil
+}
+
+func reconcileConstraint(db *gorm.DB, table string, con Constraint) (Action, error) {
+	var exists bool
+	err := db.Raw(`
+        SELECT EXISTS (
+            SELECT 1
+            FROM pg_constraint c
+            JOIN pg_class t ON t.oid = c.conrelid
+            JOIN pg_namespace n ON n.oid = t.relnamespace
+            WHERE n.nspname = current_schema() AND t.relname = ? AND c.conname = ?
+        )
+    `, table, con.Name).Scan(&exists).Error
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return Unchanged, nil
+	}
+
+	if err := db.Exec(con.AddSQL(table)).Error; err != nil {
+		return "", err
+	}
+	return Created, nil
+}
+
+func tableName(db *gorm.DB, model any) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", fmt.Errorf("failed to parse %T: %w", model, err)
+	}
+	return stmt.Schema.Table, nil
+}
+
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
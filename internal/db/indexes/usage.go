@@ -0,0 +1,135 @@
+package indexes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+)
+
+// Stat describes one index in the current schema, from pg_stat_user_indexes
+type Stat struct {
+	Table      string `gorm:"column:table_name"`
+	Name       string `gorm:"column:index_name"`
+	Scans      int64  `gorm:"column:scans"`
+	Size       int64  `gorm:"column:size"`
+	Definition string `gorm:"column:definition"`
+}
+
+// UsageReport lists indexes that cost writes and disk without paying for
+// themselves
+type UsageReport struct {
+	// Unused holds non-unique indexes that were never scanned since the
+	// statistics were last reset. Unique and primary key indexes enforce
+	// constraints, so they are never reported.
+	Unused []Stat
+
+	// Duplicates holds groups of indexes on the same table with the same
+	// columns, expressions, operator classes and predicate
+	Duplicates [][]Stat
+}
+
+// Usage builds the usage report for the current schema. The scan counts are
+// per server and reset with pg_stat_reset, so check every replica and a
+// representative period of traffic before dropping anything.
+func Usage(ctx context.Context, db *gorm.DB) (*UsageReport, error) {
+	db = db.WithContext(ctx)
+	report := &UsageReport{}
+
+	err := db.Raw(`
+        SELECT
+            s.relname AS table_name,
+            s.indexrelname AS index_name,
+            s.idx_scan AS scans,
+            pg_relation_size(s.indexrelid) AS size,
+            pg_get_indexdef(s.indexrelid) AS definition
+        FROM pg_stat_user_indexes s
+        JOIN pg_index ix ON ix.indexrelid = s.indexrelid
+        WHERE s.schemaname = current_schema()
+          AND s.idx_scan = 0
+          AND NOT ix.indisunique
+          AND NOT ix.indisprimary
+        ORDER BY pg_relation_size(s.indexrelid) DESC, s.relname, s.indexrelname
+    `).Scan(&report.Unused).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read unused indexes: %w", err)
+	}
+
+	var groups []struct {
+		Indexes string `gorm:"column:indexes"`
+	}
+	err = db.Raw(`
+        SELECT string_agg(s.indexrelname, ',' ORDER BY s.indexrelname) AS indexes
+        FROM pg_stat_user_indexes s
+        JOIN pg_index ix ON ix.indexrelid = s.indexrelid
+        WHERE s.schemaname = current_schema()
+        GROUP BY
+            ix.indrelid,
+            ix.indkey::text,
+            ix.indclass::text,
+            coalesce(pg_get_expr(ix.indexprs, ix.indrelid), ''),
+            coalesce(pg_get_expr(ix.indpred, ix.indrelid), '')
+        HAVING count(*) > 1
+        ORDER BY 1
+    `).Scan(&groups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read duplicate indexes: %w", err)
+	}
+
+	for _, group := range groups {
+		var stats []Stat
+		err := db.Raw(`
+            SELECT
+                s.relname AS table_name,
+                s.indexrelname AS index_name,
+                s.idx_scan AS scans,
+                pg_relation_size(s.indexrelid) AS size,
+                pg_get_indexdef(s.indexrelid) AS definition
+            FROM pg_stat_user_indexes s
+            WHERE s.schemaname = current_schema() AND s.indexrelname IN ?
+            ORDER BY s.indexrelname
+        `, strings.Split(group.Indexes, ",")).Scan(&stats).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to read duplicate indexes: %w", err)
+		}
+		report.Duplicates = append(report.Duplicates, stats)
+	}
+
+	return report, nil
+}
+
+// Print writes a human-readable report to w
+func (r *UsageReport) Print(w io.Writer) error {
+	if len(r.Unused) == 0 && len(r.Duplicates) == 0 {
+		_, err := fmt.Fprintln(w, "no unused or duplicate indexes")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FINDING\tTABLE\tINDEX\tSCANS\tSIZE\tDEFINITION")
+	for _, s := range r.Unused {
+		fmt.Fprintf(tw, "unused\t%s\t%s\t%d\t%s\t%s\n", s.Table, s.Name, s.Scans, formatSize(s.Size), s.Definition)
+	}
+	for i, group := range r.Duplicates {
+		for _, s := range group {
+			fmt.Fprintf(tw, "duplicate #%d\t%s\t%s\t%d\t%s\t%s\n", i+1, s.Table, s.Name, s.Scans, formatSize(s.Size), s.Definition)
+		}
+	}
+	return tw.Flush()
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,176 @@
+// Package locking implements optimistic locking for models with a version
+// column. Every update increments the version; an update made from a record
+// that carries a version only applies if the row still has that version, so
+// writers that read the same row can't silently overwrite each other.
+package locking
+
+import (
+	"errors"
+	"reflect"
+
+	"gorm-reference/internal/db/gormutil"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Column is the column that holds the version of a row
+const Column = "version"
+
+// ErrStale is returned by updates whose expected version no longer matches
+// the row, because it was changed or deleted since it was read
+var ErrStale = errors.New("record was modified since it was read")
+
+// The keys that carry what check did to an update over to advance: the
+// version the update expects, and whether check built its SET clause
+const (
+	expectedKey = "locking:expected_version"
+	setKey      = "locking:set"
+)
+
+// GORMPlugin checks and increments the version column of every update of a
+// model that has one. The expected version is the one of the record being
+// saved or updated, or the version key of a map of updates; updates without
+// one, like batch updates, increment the version unconditionally.
+type GORMPlugin struct{}
+
+var _ gorm.Plugin = (*GORMPlugin)(nil)
+
+// NewGORMPlugin creates the plugin
+func NewGORMPlugin() *GORMPlugin {
+	return &GORMPlugin{}
+}
+
+// Name implements gorm.Plugin
+func (p *GORMPlugin) Name() string {
+	return "locking"
+}
+
+// Initialize checks the version before updates and advances it after them
+func (p *GORMPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return gormutil.Register([]gormutil.Hook{
+		{Register: cb.Update().Before("gorm:update").Register, Name: "locking:check_version", Fn: p.check},
+		{Register: cb.Update().After("gorm:update").Register, Name: "locking:advance_version", Fn: p.advance},
+	})
+}
+
+// check builds the SET clause of the update itself, with the version
+// incremented, and adds the expected version to its WHERE clause
+func (p *GORMPlugin) check(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	field := stmt.Schema.LookUpField(Column)
+	if field == nil {
+		return
+	}
+	if _, ok := stmt.Clauses["SET"]; ok {
+		return
+	}
+
+	expected, ok := expectedVersion(db, field)
+	if ok {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: expected},
+		}})
+		db.InstanceSet(expectedKey, expected)
+	}
+
+	set := callbacks.ConvertToAssignments(stmt)
+	if db.Error != nil || len(set) == 0 {
+		return
+	}
+	assignments := make(clause.Set, 0, len(set)+1)
+	for _, a := range set {
+		if a.Column.Name != field.DBName {
+			assignments = append(assignments, a)
+		}
+	}
+	assignments = append(assignments, clause.Assignment{
+		Column: clause.Column{Name: field.DBName},
+		Value:  clause.Expr{SQL: "? + 1", Vars: []any{clause.Column{Name: field.DBName}}},
+	})
+	stmt.AddClause(assignments)
+	db.InstanceSet(setKey, true)
+}
+
+// advance reports a stale update, or records the new version on the record.
+// It drops the SET clause check built, so that a statement run again builds
+// it anew; a SET clause of the caller's stays.
+func (p *GORMPlugin) advance(db *gorm.DB) {
+	stmt := db.Statement
+	if _, ok := db.InstanceGet(setKey); ok {
+		delete(stmt.Clauses, "SET")
+	}
+
+	value, ok := db.InstanceGet(expectedKey)
+	if !ok || db.Error != nil || db.DryRun {
+		return
+	}
+	if db.RowsAffected == 0 {
+		_ = db.AddError(ErrStale)
+		return
+	}
+
+	field := stmt.Schema.LookUpField(Column)
+	next := value.(int64) + 1
+	for _, rv := range []reflect.Value{stmt.ReflectValue, reflect.Indirect(reflect.ValueOf(stmt.Dest))} {
+		if rv.Kind() == reflect.Struct && rv.CanAddr() && rv.Type() == stmt.Schema.ModelType {
+			_ = field.Set(stmt.Context, rv, next)
+		}
+	}
+	if m, ok := stmt.Dest.(map[string]any); ok {
+		for _, key := range []string{field.DBName, field.Name} {
+			if _, ok := m[key]; ok {
+				m[key] = next
+			}
+		}
+	}
+}
+
+// expectedVersion returns the version the update expects: the one in a map
+// of updates, of the struct of updates or of the model, whichever is set
+func expectedVersion(db *gorm.DB, field *schema.Field) (int64, bool) {
+	stmt := db.Statement
+
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	switch {
+	case dest.Kind() == reflect.Map:
+		if m, ok := stmt.Dest.(map[string]any); ok {
+			for _, key := range []string{field.DBName, field.Name} {
+				if value, ok := m[key]; ok {
+					return toVersion(value)
+				}
+			}
+		}
+	case dest.Kind() == reflect.Struct && dest.Type() == stmt.Schema.ModelType:
+		if value, zero := field.ValueOf(stmt.Context, dest); !zero {
+			return toVersion(value)
+		}
+	}
+
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		if value, zero := field.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+			return toVersion(value)
+		}
+	}
+	return 0, false
+}
+
+func toVersion(value any) (int64, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), rv.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), rv.Uint() != 0
+	case reflect.Float64:
+		// Versions decoded from JSON into a map
+		return int64(rv.Float()), rv.Float() != 0
+	}
+	return 0, false
+}
@@ -0,0 +1,245 @@
+package locking_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm-reference/internal/db/locking"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// item is the versioned model of the tests
+type item struct {
+	ID      uint
+	Name    string
+	Version int64 `gorm:"not null;default:1"`
+}
+
+func TestUpdateSQL(t *testing.T) {
+	db := openDryRun(t)
+
+	stmt := db.Model(&item{ID: 1, Version: 3}).Update("name", "b").Statement
+	if stmt.Error != nil {
+		t.Fatal(stmt.Error)
+	}
+	sql := stmt.SQL.String()
+	if !strings.Contains(sql, `"version"="version" + 1`) {
+		t.Errorf("update doesn't increment the version: %s", sql)
+	}
+	if !strings.Contains(sql, `"items"."version" = $`) {
+		t.Errorf("update doesn't expect the model's version: %s", sql)
+	}
+	if _, ok := stmt.Clauses["SET"]; ok {
+		t.Error("the SET clause the plugin built outlives the update")
+	}
+
+	// Without an expected version the increment is unconditional
+	sql = db.Model(&item{}).Where("name = ?", "a").Update("name", "b").Statement.SQL.String()
+	if !strings.Contains(sql, `"version"="version" + 1`) || strings.Contains(sql, `"items"."version" =`) {
+		t.Errorf("batch update = %s, want the version incremented without a check", sql)
+	}
+}
+
+func TestCallerSetClauseStays(t *testing.T) {
+	db := openDryRun(t)
+
+	set := clause.Set{{Column: clause.Column{Name: "name"}, Value: "b"}}
+	stmt := db.Model(&item{}).Where("id = ?", 1).Clauses(set).Updates(map[string]any{"name": "b"}).Statement
+	if stmt.Error != nil {
+		t.Fatal(stmt.Error)
+	}
+	if _, ok := stmt.Clauses["SET"]; !ok {
+		t.Error("the caller's SET clause was dropped")
+	}
+}
+
+func TestModelVersion(t *testing.T) {
+	db := openDB(t)
+	record := seed(t, db, "a")
+
+	if err := db.Model(&record).Update("name", "b").Error; err != nil {
+		t.Fatal(err)
+	}
+	if record.Version != 2 {
+		t.Errorf("Version = %d after an update, want 2", record.Version)
+	}
+	assertVersion(t, db, record.ID, 2)
+
+	stale := item{ID: record.ID, Version: 1}
+	if err := db.Model(&stale).Update("name", "c").Error; !errors.Is(err, locking.ErrStale) {
+		t.Errorf("update of a stale record error = %v, want %v", err, locking.ErrStale)
+	}
+	stale.Name = "c"
+	if err := db.Save(&stale).Error; !errors.Is(err, locking.ErrStale) {
+		t.Errorf("save of a stale record error = %v, want %v", err, locking.ErrStale)
+	}
+	assertVersion(t, db, record.ID, 2)
+}
+
+func TestMapVersion(t *testing.T) {
+	db := openDB(t)
+	record := seed(t, db, "a")
+
+	updates := map[string]any{"name": "b", "version": 1}
+	if err := db.Model(&item{ID: record.ID}).Updates(updates).Error; err != nil {
+		t.Fatal(err)
+	}
+	if updates["version"] != int64(2) {
+		t.Errorf("map version = %v after the update, want 2", updates["version"])
+	}
+
+	// Versions decoded from JSON are float64
+	err := db.Model(&item{ID: record.ID}).Updates(map[string]any{"name": "c", "version": float64(1)}).Error
+	if !errors.Is(err, locking.ErrStale) {
+		t.Errorf("update with a stale map version error = %v, want %v", err, locking.ErrStale)
+	}
+	if err := db.Model(&item{ID: record.ID}).Updates(map[string]any{"name": "c", "version": float64(2)}).Error; err != nil {
+		t.Errorf("update with the current map version: %v", err)
+	}
+	assertVersion(t, db, record.ID, 3)
+}
+
+func TestStructVersion(t *testing.T) {
+	db := openDB(t)
+	record := seed(t, db, "a")
+
+	if err := db.Model(&item{ID: record.ID}).Updates(item{Name: "b", Version: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	err := db.Model(&item{ID: record.ID}).Updates(item{Name: "c", Version: 1}).Error
+	if !errors.Is(err, locking.ErrStale) {
+		t.Errorf("update with a stale struct version error = %v, want %v", err, locking.ErrStale)
+	}
+	assertVersion(t, db, record.ID, 2)
+}
+
+func TestDeletedRowIsStale(t *testing.T) {
+	db := openDB(t)
+	record := seed(t, db, "a")
+
+	if err := db.Delete(&item{}, record.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&record).Update("name", "b").Error; !errors.Is(err, locking.ErrStale) {
+		t.Errorf("update of a deleted row error = %v, want %v", err, locking.ErrStale)
+	}
+}
+
+func TestBatchUpdate(t *testing.T) {
+	db := openDB(t)
+	first, second := seed(t, db, "a"), seed(t, db, "b")
+	if err := db.Model(&second).Update("name", "c").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	result := db.Model(&item{}).Where("id IN ?", []uint{first.ID, second.ID}).Update("name", "batch")
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if result.RowsAffected != 2 {
+		t.Errorf("batch update affected %d rows, want 2", result.RowsAffected)
+	}
+	assertVersion(t, db, first.ID, 2)
+	assertVersion(t, db, second.ID, 3)
+
+	// A batch update that matches nothing isn't stale
+	if err := db.Model(&item{}).Where("name = ?", "none").Update("name", "x").Error; err != nil {
+		t.Errorf("batch update without matches: %v", err)
+	}
+}
+
+func seed(t *testing.T, db *gorm.DB, name string) item {
+	t.Helper()
+	record := item{Name: name}
+	if err := db.Create(&record).Error; err != nil {
+		t.Fatalf("seed %s: %v", name, err)
+	}
+	if record.Version != 1 {
+		t.Fatalf("seeded version = %d, want 1", record.Version)
+	}
+	return record
+}
+
+func assertVersion(t *testing.T, db *gorm.DB, id uint, want int64) {
+	t.Helper()
+	var version int64
+	if err := db.Model(&item{}).Where("id = ?", id).Pluck("version", &version).Error; err != nil {
+		t.Fatal(err)
+	}
+	if version != want {
+		t.Errorf("stored version of item %d = %d, want %d", id, version, want)
+	}
+}
+
+// openDryRun returns a database that builds statements without running them
+func openDryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(locking.NewGORMPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// openDB migrates a scratch schema of the database in TEST_DATABASE_URL
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	scratch := fmt.Sprintf("locking_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + scratch); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec("DROP SCHEMA " + scratch + " CASCADE")
+		admin.Close()
+	})
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	config.RuntimeParams["search_path"] = scratch
+	pool := stdlib.OpenDB(*config)
+	t.Cleanup(func() { pool.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open gorm: %v", err)
+	}
+	if err := db.AutoMigrate(&item{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := db.Use(locking.NewGORMPlugin()); err != nil {
+		t.Fatalf("install plugin: %v", err)
+	}
+	return db.WithContext(context.Background())
+}
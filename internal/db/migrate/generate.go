@@ -0,0 +1,438 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm-reference/internal/db/drift"
+	"gorm-reference/internal/db/indexes"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Change is one schema change of a generated migration
+type Change struct {
+	Description string
+	Up          string
+	Down        string
+
+	// Review explains why the change may lose data or fail on existing rows.
+	// Such changes are written commented out so that someone decides on them
+	// before the migration runs.
+	Review string
+
+	phase int
+}
+
+// Changes run in phases so that every table exists before indexes and
+// foreign keys refer to it
+const (
+	phaseTables = iota
+	phaseColumns
+	phaseIndexes
+	phaseConstraints
+	phaseExtras
+)
+
+// Plan is the list of changes that bring the database in line with the models
+type Plan struct {
+	Changes []Change
+}
+
+// NeedsReview returns the changes that are written commented out
+func (p *Plan) NeedsReview() []Change {
+	var changes []Change
+	for _, c := range p.Changes {
+		if c.Review != "" {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// UpSQL renders the forward migration
+func (p *Plan) UpSQL(base string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- %s (up)\n-- Generated by `migrate generate` from the GORM models.\n", base)
+	for _, c := range p.Changes {
+		writeChange(&b, c.Description, c.Up, c.Review)
+	}
+	return b.String()
+}
+
+// DownSQL renders the rollback, undoing the changes in reverse order
+func (p *Plan) DownSQL(base string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- %s (down)\n-- Generated by `migrate generate` from the GORM models.\n", base)
+	for i := len(p.Changes) - 1; i >= 0; i-- {
+		c := p.Changes[i]
+		writeChange(&b, "undo: "+c.Description, c.Down, c.Review)
+	}
+	return b.String()
+}
+
+func writeChange(b *strings.Builder, description, sql, review string) {
+	fmt.Fprintf(b, "\n-- %s\n", description)
+	if review == "" {
+		b.WriteString(sql + "\n")
+		return
+	}
+	fmt.Fprintf(b, "-- REVIEW: %s\n", review)
+	for _, line := range strings.Split(sql, "\n") {
+		b.WriteString("-- " + line + "\n")
+	}
+}
+
+// Generate writes the plan as a new numbered up/down pair in dir
+func Generate(dir, name string, plan *Plan) (up, down string, err error) {
+	return writePair(dir, name, func(base string) (string, string) {
+		return plan.UpSQL(base), plan.DownSQL(base)
+	})
+}
+
+// NewPlan turns a drift report into SQL changes. Objects that only exist in
+// the database are dropped only when includeExtras is set, and always need
+// review.
+func NewPlan(db *gorm.DB, report *drift.Report, includeExtras bool) (*Plan, error) {
+	p := &planner{db: db, plan: &Plan{}}
+
+	for _, d := range report.Differences {
+		if d.Severity == drift.Warning && !includeExtras {
+			continue
+		}
+		if err := p.add(d); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", d.Table, d.Object, err)
+		}
+	}
+
+	sort.SliceStable(p.plan.Changes, func(i, j int) bool {
+		return p.plan.Changes[i].phase < p.plan.Changes[j].phase
+	})
+	return p.plan, nil
+}
+
+type planner struct {
+	db   *gorm.DB
+	plan *Plan
+}
+
+func (p *planner) change(phase int, description, up, down, review string) {
+	p.plan.Changes = append(p.plan.Changes, Change{
+		Description: description,
+		Up:          up,
+		Down:        down,
+		Review:      review,
+		phase:       phase,
+	})
+}
+
+func (p *planner) add(d drift.Difference) error {
+	s := d.Schema
+	table := p.quote(s.Table)
+
+	switch d.Kind {
+	case drift.MissingTable:
+		p.createTable(s)
+
+	case drift.MissingColumn:
+		field := s.LookUpField(d.Object)
+		if field == nil {
+			return fmt.Errorf("unknown column")
+		}
+		review := ""
+		if field.NotNull && !field.HasDefaultValue {
+			review = "adds a NOT NULL column without a default, which fails if the table has rows"
+		}
+		p.change(phaseColumns, "add column "+s.Table+"."+field.DBName,
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, p.quote(field.DBName), p.fullType(field)),
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s;", table, p.quote(field.DBName)),
+			review)
+
+	case drift.ColumnType:
+		field := s.LookUpField(d.Object)
+		if field == nil {
+			return fmt.Errorf("unknown column")
+		}
+		column := p.quote(field.DBName)
+		want := p.db.Dialector.DataTypeOf(field)
+		if plain, ok := serialTypes[want]; ok {
+			// serial is only valid in CREATE TABLE; the sequence already exists
+			want = plain
+		}
+		review := ""
+		if !isWidening(d.Actual, want) {
+			review = fmt.Sprintf("changes the type from %s to %s, which may fail to convert or truncate existing data", d.Actual, want)
+		}
+		p.change(phaseColumns, "change type of "+s.Table+"."+field.DBName,
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s;", table, column, want, column, want),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s;", table, column, d.Actual, column, d.Actual),
+			review)
+
+	case drift.ColumnNullable:
+		field := s.LookUpField(d.Object)
+		if field == nil {
+			return fmt.Errorf("unknown column")
+		}
+		column := p.quote(field.DBName)
+		setNotNull := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", table, column)
+		dropNotNull := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", table, column)
+		if field.NotNull || field.PrimaryKey {
+			p.change(phaseColumns, "make "+s.Table+"."+field.DBName+" NOT NULL", setNotNull, dropNotNull,
+				"fails if the column contains NULL values")
+		} else {
+			p.change(phaseColumns, "make "+s.Table+"."+field.DBName+" nullable", dropNotNull, setNotNull, "")
+		}
+
+	case drift.MissingIndex:
+		up := ""
+		if idx := findIndex(s, d.Object); idx != nil {
+			up = p.createIndex(s, idx)
+		} else if decl, ok := findDeclaredIndex(s, d.Object); ok {
+			up = p.createDeclaredIndex(s, decl)
+		} else {
+			return fmt.Errorf("unknown index")
+		}
+		p.change(phaseIndexes, "create index "+d.Object, up,
+			fmt.Sprintf("DROP INDEX IF EXISTS %s;", p.quote(d.Object)),
+			"")
+
+	case drift.IndexDefinition:
+		idx := findIndex(s, d.Object)
+		if idx == nil {
+			return fmt.Errorf("unknown index")
+		}
+		drop := fmt.Sprintf("DROP INDEX IF EXISTS %s;", p.quote(idx.Name))
+		p.change(phaseIndexes, "rebuild index "+idx.Name+" ("+d.Message+")",
+			drop+"\n"+p.createIndex(s, idx),
+			drop+"\n"+d.Actual+";",
+			"")
+
+	case drift.MissingConstraint:
+		up, err := p.addConstraint(s, d.Object)
+		if err != nil {
+			return err
+		}
+		p.change(phaseConstraints, "add constraint "+d.Object, up,
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s;", table, p.quote(d.Object)),
+			"")
+
+	case drift.ExtraColumn:
+		column := p.quote(d.Object)
+		p.change(phaseExtras, "drop column "+s.Table+"."+d.Object,
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, column),
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, d.Actual),
+			"drops the column and its data; the rollback restores the column but not the data")
+
+	case drift.ExtraIndex:
+		p.change(phaseExtras, "drop index "+d.Object,
+			fmt.Sprintf("DROP INDEX IF EXISTS %s;", p.quote(d.Object)),
+			d.Actual+";",
+			"the index is not declared by any model; drop it only if no query relies on it")
+
+	case drift.ExtraConstraint:
+		p.change(phaseExtras, "drop constraint "+d.Object,
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s;", table, p.quote(d.Object)),
+			fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s;", table, p.quote(d.Object), d.Actual),
+			"the constraint is not declared by any model; dropping it removes a data integrity check")
+	}
+
+	return nil
+}
+
+// createTable creates the table with its columns and primary key, followed
+// by its indexes and constraints in their own phases
+func (p *planner) createTable(s *schema.Schema) {
+	var defs, primaryKeys []string
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.IgnoreMigration {
+			continue
+		}
+		defs = append(defs, fmt.Sprintf("    %s %s", p.quote(field.DBName), p.fullType(field)))
+		if field.PrimaryKey {
+			primaryKeys = append(primaryKeys, p.quote(field.DBName))
+		}
+	}
+	if len(primaryKeys) > 0 {
+		defs = append(defs, fmt.Sprintf("    PRIMARY KEY (%s)", strings.Join(primaryKeys, ", ")))
+	}
+
+	table := p.quote(s.Table)
+	p.change(phaseTables, "create table "+s.Table,
+		fmt.Sprintf("CREATE TABLE %s (\n%s\n);", table, strings.Join(defs, ",\n")),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", table),
+		"")
+
+	for _, idx := range s.ParseIndexes() {
+		p.change(phaseIndexes, "create index "+idx.Name,
+			p.createIndex(s, idx),
+			fmt.Sprintf("DROP INDEX IF EXISTS %s;", p.quote(idx.Name)),
+			"")
+	}
+	declared, _ := indexes.Declared(s.ModelType)
+	for _, idx := range declared {
+		p.change(phaseIndexes, "create index "+idx.Name,
+			p.createDeclaredIndex(s, idx),
+			fmt.Sprintf("DROP INDEX IF EXISTS %s;", p.quote(idx.Name)),
+			"")
+	}
+
+	for _, name := range constraintNames(s) {
+		up, _ := p.addConstraint(s, name)
+		p.change(phaseConstraints, "add constraint "+name, up,
+			fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s;", table, p.quote(name)),
+			"")
+	}
+}
+
+func (p *planner) createIndex(s *schema.Schema, idx *schema.Index) string {
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if idx.Class == "UNIQUE" {
+		b.WriteString("UNIQUE ")
+	}
+	fmt.Fprintf(&b, "INDEX %s ON %s", p.quote(idx.Name), p.quote(s.Table))
+	if idx.Type != "" {
+		b.WriteString(" USING " + idx.Type)
+	}
+
+	columns := make([]string, 0, len(idx.Fields))
+	for _, opt := range idx.Fields {
+		column := opt.Expression
+		if column == "" {
+			column = p.quote(opt.DBName)
+		}
+		if opt.Collate != "" {
+			column += " COLLATE " + opt.Collate
+		}
+		if opt.Sort != "" {
+			column += " " + opt.Sort
+		}
+		columns = append(columns, column)
+	}
+	fmt.Fprintf(&b, " (%s)", strings.Join(columns, ", "))
+
+	if idx.Where != "" {
+		b.WriteString(" WHERE " + idx.Where)
+	}
+	return b.String() + ";"
+}
+
+// createDeclaredIndex renders an index declared through indexes.Indexer.
+// Migrations run in a transaction, so it is never built concurrently.
+func (p *planner) createDeclaredIndex(s *schema.Schema, idx indexes.Index) string {
+	return idx.CreateSQL(p.quote(s.Table), false) + ";"
+}
+
+// addConstraint renders a foreign key or check constraint declared by s
+func (p *planner) addConstraint(s *schema.Schema, name string) (string, error) {
+	table := p.quote(s.Table)
+
+	for _, rel := range s.Relationships.Relations {
+		constraint := rel.ParseConstraint()
+		if constraint == nil || constraint.Schema != s || constraint.Name != name {
+			continue
+		}
+		sql, vars := constraint.Build()
+		return fmt.Sprintf("ALTER TABLE %s ADD %s;", table, p.render(sql, vars...)), nil
+	}
+
+	if check, ok := s.ParseCheckConstraints()[name]; ok {
+		return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s);", table, p.quote(name), check.Constraint), nil
+	}
+
+	_, declared := indexes.Declared(s.ModelType)
+	for _, con := range declared {
+		if con.Name == name {
+			return con.AddSQL(table) + ";", nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown constraint")
+}
+
+func constraintNames(s *schema.Schema) []string {
+	var names []string
+	for _, rel := range s.Relationships.Relations {
+		if constraint := rel.ParseConstraint(); constraint != nil && constraint.Schema == s {
+			names = append(names, constraint.Name)
+		}
+	}
+	for name := range s.ParseCheckConstraints() {
+		names = append(names, name)
+	}
+	_, declared := indexes.Declared(s.ModelType)
+	for _, con := range declared {
+		names = append(names, con.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func findIndex(s *schema.Schema, name string) *schema.Index {
+	for _, idx := range s.ParseIndexes() {
+		if idx.Name == name {
+			return idx
+		}
+	}
+	return nil
+}
+
+func findDeclaredIndex(s *schema.Schema, name string) (indexes.Index, bool) {
+	declared, _ := indexes.Declared(s.ModelType)
+	for _, idx := range declared {
+		if idx.Name == name {
+			return idx, true
+		}
+	}
+	return indexes.Index{}, false
+}
+
+func (p *planner) quote(name string) string {
+	var b strings.Builder
+	p.db.Dialector.QuoteTo(&b, name)
+	return b.String()
+}
+
+func (p *planner) fullType(field *schema.Field) string {
+	return p.db.Migrator().FullDataTypeOf(field).SQL
+}
+
+// render builds SQL whose vars are clause.Table / clause.Column identifiers
+func (p *planner) render(sql string, vars ...any) string {
+	stmt := &gorm.Statement{DB: p.db, Clauses: map[string]clause.Clause{}}
+	clause.Expr{SQL: sql, Vars: vars}.Build(stmt)
+	return stmt.SQL.String()
+}
+
+var serialTypes = map[string]string{
+	"smallserial": "smallint",
+	"serial":      "integer",
+	"bigserial":   "bigint",
+}
+
+var varcharPattern = regexp.MustCompile(`^varchar\((\d+)\)$`)
+
+// isWidening reports whether changing from one type to the other keeps every
+// existing value, e.g. varchar(100) to varchar(255) or integer to bigint
+func isWidening(from, to string) bool {
+	from, to = strings.ToLower(from), strings.ToLower(to)
+
+	if f, t := varcharPattern.FindStringSubmatch(from), varcharPattern.FindStringSubmatch(to); f != nil && t != nil {
+		fromSize, _ := strconv.Atoi(f[1])
+		toSize, _ := strconv.Atoi(t[1])
+		return toSize >= fromSize
+	}
+	if varcharPattern.MatchString(from) && to == "text" {
+		return true
+	}
+
+	intSizes := map[string]int{"int2": 2, "smallint": 2, "int4": 4, "integer": 4, "int8": 8, "bigint": 8}
+	fromSize, fromInt := intSizes[from]
+	toSize, toInt := intSizes[to]
+	return fromInt && toInt && toSize >= fromSize
+}
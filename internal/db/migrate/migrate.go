@@ -0,0 +1,332 @@
+// Package migrate applies the versioned SQL migrations embedded in the
+// binary and records them in the schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// TableName is the table that records applied migrations
+const TableName = "schema_migrations"
+
+// lockKey identifies the Postgres advisory lock held while migrating, so
+// concurrently starting pods apply each migration exactly once
+var lockKey = int64(crc32.ChecksumIEEE([]byte("gorm-reference:" + TableName)))
+
+// ErrNoDownMigration is returned when rolling back a migration without a down file
+var ErrNoDownMigration = errors.New("migration has no down file")
+
+// Status describes one migration and whether it has been applied
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+
+	// Missing is set for versions recorded in the database that have no
+	// matching file in the binary
+	Missing bool
+}
+
+// Migrator applies migrations to a Postgres database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New loads the migrations in fsys
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations returns the known migrations sorted by version
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies every pending migration and returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last n applied migrations and returns them in the
+// order they were rolled back
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("down: step count must be positive, got %d", n)
+	}
+
+	var rolledBack []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < n; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if err := apply(ctx, conn, mig, false); err != nil {
+				return err
+			}
+			rolledBack = append(rolledBack, mig)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// To migrates up or down until version is the latest applied migration.
+// Version 0 rolls back everything.
+func (m *Migrator) To(ctx context.Context, version int64) ([]Migration, error) {
+	if version != 0 && m.find(version) == nil {
+		return nil, fmt.Errorf("unknown migration version %d", version)
+	}
+
+	var changed []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// Roll back newer migrations first, newest to oldest
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok || mig.Version <= version {
+				continue
+			}
+			if err := apply(ctx, conn, mig, false); err != nil {
+				return err
+			}
+			changed = append(changed, mig)
+		}
+
+		// Then apply anything pending up to the target
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok || mig.Version > version {
+				continue
+			}
+			if err := apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+			changed = append(changed, mig)
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// Status lists every known migration along with unknown versions found in
+// the database. It only reads: before the first migration, when the
+// schema_migrations table doesn't exist yet, every migration is pending.
+// Readiness probes call it through Pending, so it must not take locks.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	done := make(map[int64]time.Time)
+	exists, err := tableExists(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if done, err = appliedVersions(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status := Status{Version: mig.Version, Name: mig.Name}
+		if appliedAt, ok := done[mig.Version]; ok {
+			status.AppliedAt = &appliedAt
+			delete(done, mig.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, appliedAt := range done {
+		statuses = append(statuses, Status{Version: version, AppliedAt: &appliedAt, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// Pending returns the migrations that have not been applied yet. Like
+// Status, it only reads.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, *m.find(status.Version))
+		}
+	}
+	return pending, nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// withLock runs fn on a single connection holding the migration advisory
+// lock. Session-level advisory locks belong to a connection, so every
+// statement must go through conn rather than the pool.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		// Use a fresh context so the lock is released even if ctx was cancelled
+		_, unlockErr := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+		if err == nil && unlockErr != nil {
+			err = fmt.Errorf("failed to release migration lock: %w", unlockErr)
+		}
+	}()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS `+TableName+` (
+            version BIGINT PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", TableName, err)
+	}
+	return nil
+}
+
+// tableExists reports whether the schema_migrations table exists on the
+// search path
+func tableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", TableName).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up the %s table: %w", TableName, err)
+	}
+	return exists, nil
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM "+TableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
+
+// apply runs the up or down script of mig and updates schema_migrations.
+// Both happen in one transaction unless the migration opted out.
+func apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	script, record, args := mig.Up, "INSERT INTO "+TableName+" (version, name) VALUES ($1, $2)", []any{mig.Version, mig.Name}
+	direction := "up"
+	if !up {
+		if mig.Down == "" {
+			return fmt.Errorf("%05d_%s: %w", mig.Version, mig.Name, ErrNoDownMigration)
+		}
+		script, record, args = mig.Down, "DELETE FROM "+TableName+" WHERE version = $1", []any{mig.Version}
+		direction = "down"
+	}
+
+	wrap := func(err error) error {
+		return fmt.Errorf("migration %05d_%s (%s) failed: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	if mig.NoTransaction {
+		if _, err := conn.ExecContext(ctx, script); err != nil {
+			return wrap(err)
+		}
+		if _, err := conn.ExecContext(ctx, record, args...); err != nil {
+			return wrap(err)
+		}
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return wrap(err)
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		_ = tx.Rollback()
+		return wrap(err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return wrap(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return wrap(err)
+	}
+	return nil
+}
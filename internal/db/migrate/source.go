@@ -0,0 +1,137 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// noTransactionDirective opts a migration out of the per-migration
+// transaction, e.g. for CREATE INDEX CONCURRENTLY. It must be the first line
+// of the file.
+const noTransactionDirective = "-- migrate:no-transaction"
+
+// fileNamePattern matches "00001_create_users.up.sql"
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+
+	// NoTransaction is set when the up or down file starts with
+	// noTransactionDirective
+	NoTransaction bool
+}
+
+// Load reads every migration in fsys, sorted by version. Each version must
+// have an up file; the down file is optional but rollbacks past it fail.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", entry.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		sql := string(body)
+		if strings.HasPrefix(sql, noTransactionDirective) {
+			m.NoTransaction = true
+		}
+		if match[3] == "up" {
+			m.Up = sql
+		} else {
+			m.Down = sql
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Create writes an empty up/down pair to dir, numbered one past the highest
+// existing version, and returns the paths of the new files
+func Create(dir, name string) (up, down string, err error) {
+	return writePair(dir, name, func(base string) (string, string) {
+		return "-- " + base + " (up)\n", "-- " + base + " (down)\n"
+	})
+}
+
+// writePair writes the up/down files of a new migration. body receives the
+// numbered base name and returns the contents of both files.
+func writePair(dir, name string, body func(base string) (up, down string)) (up, down string, err error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}), "_")
+	if name == "" {
+		return "", "", fmt.Errorf("migration name must contain letters or digits")
+	}
+
+	existing, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	var next int64 = 1
+	if len(existing) > 0 {
+		next = existing[len(existing)-1].Version + 1
+	}
+
+	base := fmt.Sprintf("%05d_%s", next, name)
+	up = filepath.Join(dir, base+".up.sql")
+	down = filepath.Join(dir, base+".down.sql")
+	upSQL, downSQL := body(base)
+
+	if err := os.WriteFile(up, []byte(upSQL), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte(downSQL), 0o644); err != nil {
+		return "", "", err
+	}
+
+	return up, down, nil
+}
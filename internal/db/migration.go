@@ -1,16 +1,14 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
+	"gorm-reference/internal/db/migrate"
+	"gorm-reference/internal/db/migrations"
 	"gorm-reference/internal/models"
 
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/jackc/tern/v2/migrate"
-
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -19,7 +17,9 @@ import (
 // Use AutoMigrate for development environments to automatically sync schema changes.
 // ==================================================================================
 
-// AutoMigrate creates or updates tables based on model definitions
+// AutoMigrate creates or updates tables based on model definitions.
+// Production uses the versioned migrations instead; when a model changes,
+// add a migration so TestMigrationsMatchModels keeps passing.
 func AutoMigrate(db *gorm.DB) error {
 	// AutoMigrate will:
 	// - Create tables if they don't exist
@@ -30,13 +30,7 @@ func AutoMigrate(db *gorm.DB) error {
 	// - Change column types
 	// - Delete unused indexes
 
-	err := db.AutoMigrate(
-		&models.User{},
-		&models.Profile{},
-		&models.Post{},
-		&models.Comment{},
-		&models.Tag{},
-	)
+	err := db.AutoMigrate(models.All()...)
 	if err != nil {
 		return fmt.Errorf("auto migration failed: %w", err)
 	}
@@ -44,25 +38,25 @@ func AutoMigrate(db *gorm.DB) error {
 	return nil
 }
 
-// RunMigrations executes database migrations from the specified path
-func RunMigrations(db *sql.DB, migrationsPath string) error {
-	// Create postgres driver for migrate
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
-	if err != nil {
-		return fmt.Errorf("failed to create migration driver: %w", &err)
-	}
+// ==========================================================================
+// Versioned Migrations
+// Use the embedded SQL migrations in production. They are compiled into the
+// binary and applied under an advisory lock, so concurrent pods are safe.
+// ==========================================================================
+
+// NewMigrator returns a migrator for the migrations embedded in the binary
+func NewMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	return migrate.New(db, migrations.FS)
+}
 
-	// Create migrate instance
-	m, err := migrate.NewWithDatabaseInstance(
-		fmt.Sprintf("file://%s", migrationsPath),
-		"postgres",
-		driver,
-	)
+// RunMigrations applies all pending migrations
+func RunMigrations(ctx context.Context, db *sql.DB) error {
+	m, err := NewMigrator(db)
 	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %w", err)
+		return fmt.Errorf("failed to load migrations: %w", err)
 	}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+	if _, err := m.Up(ctx); err != nil {
 		return fmt.Errorf("migration failed: %w", err)
 	}
 
@@ -70,21 +64,16 @@ func RunMigrations(db *sql.DB, migrationsPath string) error {
 }
 
 // RollbackMigration rolls back the last migration
-func RollbackMigration(db *sql.DB, migrationsPath string) error {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+func RollbackMigration(ctx context.Context, db *sql.DB) error {
+	m, err := NewMigrator(db)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to load migrations: %w", err)
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		fmt.Sprintf("file://%s", migrationsPath),
-		"postgres",
-		driver,
-	)
-	if err != nil {
-		return err
+	// Roll back one step
+	if _, err := m.Down(ctx, 1); err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
 	}
 
-	// Roll back one step
-	return m.Steps(-1)
+	return nil
 }
@@ -0,0 +1,17 @@
+-- The User model declares unique indexes rather than UNIQUE constraints,
+-- plus indexes on first_name, last_name and last_login_at.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+
+DROP INDEX IF EXISTS idx_users_email;
+DROP INDEX IF EXISTS idx_users_username;
+
+CREATE UNIQUE INDEX idx_users_email ON users(email);
+CREATE UNIQUE INDEX idx_users_username ON users(username);
+CREATE INDEX idx_users_first_name ON users(first_name);
+CREATE INDEX idx_users_last_name ON users(last_name);
+CREATE INDEX idx_users_last_login_at ON users(last_login_at);
+
+ALTER TABLE users
+    ADD CONSTRAINT chk_email_format
+    CHECK (email ~* '^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$');
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS profiles (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    user_id BIGINT NOT NULL,
+    bio TEXT,
+    avatar_url VARCHAR(500),
+    website VARCHAR(255),
+    location VARCHAR(100),
+    social_links JSONB,
+    CONSTRAINT fk_users_profile FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE UNIQUE INDEX idx_profiles_user_id ON profiles(user_id);
+CREATE INDEX idx_profiles_deleted_at ON profiles(deleted_at);
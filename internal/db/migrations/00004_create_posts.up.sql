@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS posts (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    title VARCHAR(255) NOT NULL,
+    content TEXT,
+    user_id BIGINT NOT NULL,
+    parent_id BIGINT,
+    CONSTRAINT fk_users_posts FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_posts_parent FOREIGN KEY (parent_id) REFERENCES posts(id)
+);
+
+CREATE INDEX idx_posts_user_id ON posts(user_id);
+CREATE INDEX idx_posts_parent_id ON posts(parent_id);
+CREATE INDEX idx_posts_deleted_at ON posts(deleted_at);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    content TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    post_id BIGINT NOT NULL,
+    CONSTRAINT fk_users_comments FOREIGN KEY (user_id) REFERENCES users(id),
+    CONSTRAINT fk_comments_post FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+CREATE INDEX idx_comments_user_id ON comments(user_id);
+CREATE INDEX idx_comments_post_id ON comments(post_id);
+CREATE INDEX idx_comments_deleted_at ON comments(deleted_at);
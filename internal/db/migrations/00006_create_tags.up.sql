@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS tags (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    name VARCHAR(50) NOT NULL,
+    slug VARCHAR(50) NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_tags_name ON tags(name);
+CREATE UNIQUE INDEX idx_tags_slug ON tags(slug);
+CREATE INDEX idx_tags_deleted_at ON tags(deleted_at);
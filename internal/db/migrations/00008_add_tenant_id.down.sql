@@ -0,0 +1,16 @@
+-- Fails if two tenants share an email, username, tag name or slug
+DROP INDEX IF EXISTS idx_tags_tenant_slug;
+DROP INDEX IF EXISTS idx_tags_tenant_name;
+DROP INDEX IF EXISTS idx_users_tenant_username;
+DROP INDEX IF EXISTS idx_users_tenant_email;
+
+CREATE UNIQUE INDEX idx_users_email ON users(email);
+CREATE UNIQUE INDEX idx_users_username ON users(username);
+CREATE UNIQUE INDEX idx_tags_name ON tags(name);
+CREATE UNIQUE INDEX idx_tags_slug ON tags(slug);
+
+ALTER TABLE tags DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE comments DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE posts DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE profiles DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,30 @@
+-- Every row belongs to a tenant. Rows that predate tenancy go to "default".
+ALTER TABLE users ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE profiles ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE posts ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE comments ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE tags ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT 'default';
+
+-- The application always sets the tenant; a missing one is a bug
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE profiles ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE posts ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE comments ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE tags ALTER COLUMN tenant_id DROP DEFAULT;
+
+CREATE INDEX idx_users_tenant_id ON users(tenant_id);
+CREATE INDEX idx_profiles_tenant_id ON profiles(tenant_id);
+CREATE INDEX idx_posts_tenant_id ON posts(tenant_id);
+CREATE INDEX idx_comments_tenant_id ON comments(tenant_id);
+CREATE INDEX idx_tags_tenant_id ON tags(tenant_id);
+
+-- Emails, usernames, tag names and slugs are unique per tenant
+DROP INDEX IF EXISTS idx_users_email;
+DROP INDEX IF EXISTS idx_users_username;
+DROP INDEX IF EXISTS idx_tags_name;
+DROP INDEX IF EXISTS idx_tags_slug;
+
+CREATE UNIQUE INDEX idx_users_tenant_email ON users(tenant_id, email);
+CREATE UNIQUE INDEX idx_users_tenant_username ON users(tenant_id, username);
+CREATE UNIQUE INDEX idx_tags_tenant_name ON tags(tenant_id, name);
+CREATE UNIQUE INDEX idx_tags_tenant_slug ON tags(tenant_id, slug);
@@ -0,0 +1,2 @@
+-- Optimistic locking: every update of a user increments its version
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS jsonb_merge_patch(JSONB, JSONB);
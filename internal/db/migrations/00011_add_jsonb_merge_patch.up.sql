@@ -0,0 +1,26 @@
+-- jsonb_merge_patch applies an RFC 7396 merge patch to a jsonb document:
+-- members of the patch replace those of the target, objects merge
+-- recursively and null members are removed. Preference updates use it to
+-- change nested keys in a single statement.
+CREATE FUNCTION jsonb_merge_patch(target JSONB, patch JSONB) RETURNS JSONB
+LANGUAGE plpgsql IMMUTABLE AS $$
+DECLARE
+    member RECORD;
+BEGIN
+    IF jsonb_typeof(patch) IS DISTINCT FROM 'object' THEN
+        RETURN patch;
+    END IF;
+    IF jsonb_typeof(target) IS DISTINCT FROM 'object' THEN
+        target := '{}';
+    END IF;
+
+    FOR member IN SELECT key, value FROM jsonb_each(patch) LOOP
+        IF jsonb_typeof(member.value) = 'null' THEN
+            target := target - member.key;
+        ELSE
+            target := target || jsonb_build_object(member.key, jsonb_merge_patch(target -> member.key, member.value));
+        END IF;
+    END LOOP;
+    RETURN target;
+END
+$$;
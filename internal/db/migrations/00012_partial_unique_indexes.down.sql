@@ -0,0 +1,13 @@
+-- Fails while a deleted row shares its values with a live one; purge the
+-- deleted row first
+DROP INDEX IF EXISTS idx_users_tenant_email;
+DROP INDEX IF EXISTS idx_users_tenant_username;
+DROP INDEX IF EXISTS idx_tags_tenant_name;
+DROP INDEX IF EXISTS idx_tags_tenant_slug;
+DROP INDEX IF EXISTS idx_profiles_user_id;
+
+CREATE UNIQUE INDEX idx_users_tenant_email ON users(tenant_id, email);
+CREATE UNIQUE INDEX idx_users_tenant_username ON users(tenant_id, username);
+CREATE UNIQUE INDEX idx_tags_tenant_name ON tags(tenant_id, name);
+CREATE UNIQUE INDEX idx_tags_tenant_slug ON tags(tenant_id, slug);
+CREATE UNIQUE INDEX idx_profiles_user_id ON profiles(user_id);
@@ -0,0 +1,15 @@
+-- Soft-deleted rows keep their values, so unique indexes only cover live
+-- rows: the email or username of a deleted user can be registered again,
+-- and a user whose profile was deleted can create a new one. Restoring a
+-- row fails if a live row has taken its values meanwhile.
+DROP INDEX IF EXISTS idx_users_tenant_email;
+DROP INDEX IF EXISTS idx_users_tenant_username;
+DROP INDEX IF EXISTS idx_tags_tenant_name;
+DROP INDEX IF EXISTS idx_tags_tenant_slug;
+DROP INDEX IF EXISTS idx_profiles_user_id;
+
+CREATE UNIQUE INDEX idx_users_tenant_email ON users(tenant_id, email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_users_tenant_username ON users(tenant_id, username) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_tags_tenant_name ON tags(tenant_id, name) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_tags_tenant_slug ON tags(tenant_id, slug) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_profiles_user_id ON profiles(user_id) WHERE deleted_at IS NULL;
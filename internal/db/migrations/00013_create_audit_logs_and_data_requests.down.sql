@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS data_requests;
+DROP TABLE IF EXISTS audit_logs;
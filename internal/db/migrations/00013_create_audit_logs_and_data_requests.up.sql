@@ -0,0 +1,42 @@
+-- The audit log and the record of data subject requests. Both outlive the
+-- users they concern, so user_id is not a foreign key: erasing or purging a
+-- user leaves them in place.
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id BIGINT NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    details JSONB
+);
+
+CREATE INDEX idx_audit_logs_tenant_id ON audit_logs(tenant_id);
+CREATE INDEX idx_audit_logs_user_id ON audit_logs(user_id);
+
+CREATE TABLE IF NOT EXISTS data_requests (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR(63) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id BIGINT NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_data_requests_tenant_id ON data_requests(tenant_id);
+CREATE INDEX idx_data_requests_user_id ON data_requests(user_id);
+
+-- Tenant isolation, as in 00009
+ALTER TABLE audit_logs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_logs FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON audit_logs
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.bypass_rls', true) = 'on')
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.bypass_rls', true) = 'on');
+
+ALTER TABLE data_requests ENABLE ROW LEVEL SECURITY;
+ALTER TABLE data_requests FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON data_requests
+    USING (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.bypass_rls', true) = 'on')
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true) OR current_setting('app.bypass_rls', true) = 'on');
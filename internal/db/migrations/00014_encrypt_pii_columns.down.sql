@@ -0,0 +1,19 @@
+-- Requires the columns to hold plaintext again: ciphertext doesn't fit the
+-- narrower types or pass the format check
+DROP INDEX IF EXISTS idx_users_tenant_email;
+
+ALTER TABLE profiles ALTER COLUMN location TYPE VARCHAR(100);
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS email_index,
+    ALTER COLUMN email TYPE VARCHAR(255),
+    ALTER COLUMN last_name TYPE VARCHAR(100),
+    ALTER COLUMN first_name TYPE VARCHAR(100);
+
+CREATE UNIQUE INDEX idx_users_tenant_email ON users(tenant_id, email) WHERE deleted_at IS NULL;
+CREATE INDEX idx_users_first_name ON users(first_name);
+CREATE INDEX idx_users_last_name ON users(last_name);
+
+ALTER TABLE users
+    ADD CONSTRAINT chk_email_format
+    CHECK (email ~* '^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$');
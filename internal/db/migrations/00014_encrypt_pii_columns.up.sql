@@ -0,0 +1,28 @@
+-- Names, emails and locations are encrypted by the application, see package
+-- encryption. Ciphertext is longer than the plaintext and can't be ordered,
+-- pattern-matched or checked, so the columns become TEXT and lose their
+-- indexes and format check. Emails are compared through email_index, their
+-- blind index, which carries the per-tenant uniqueness instead.
+--
+-- Existing rows keep their plaintext, which reads as is, and a NULL blind
+-- index until they are encrypted and it is filled in, which migrate up does
+-- right after applying this migration. Until then, lookups by email don't
+-- find them and their emails aren't unique.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_email_format;
+
+DROP INDEX IF EXISTS idx_users_first_name;
+DROP INDEX IF EXISTS idx_users_last_name;
+DROP INDEX IF EXISTS idx_users_name;
+DROP INDEX IF EXISTS idx_users_email_lower;
+DROP INDEX IF EXISTS idx_active_users;
+DROP INDEX IF EXISTS idx_users_tenant_email;
+
+ALTER TABLE users
+    ALTER COLUMN first_name TYPE TEXT,
+    ALTER COLUMN last_name TYPE TEXT,
+    ALTER COLUMN email TYPE TEXT,
+    ADD COLUMN email_index VARCHAR(64);
+
+ALTER TABLE profiles ALTER COLUMN location TYPE TEXT;
+
+CREATE UNIQUE INDEX idx_users_tenant_email ON users(tenant_id, email_index) WHERE deleted_at IS NULL;
@@ -0,0 +1,6 @@
+-- migrate:no-transaction
+-- Indexes the preferences lookups of FindByPreference, which use the
+-- containment operator @>. The index is built without locking users against
+-- writes, which Postgres only does outside a transaction, so this file holds
+-- this one statement.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_preferences ON users USING gin (preferences jsonb_path_ops);
@@ -0,0 +1,10 @@
+// Package migrations embeds the versioned SQL migrations into the binary.
+//
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+// Use `go run ./cmd/migrate create <name>` to add a new pair.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,136 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gorm-reference/internal/db/drift"
+	"gorm-reference/internal/db/indexes"
+	"gorm-reference/internal/db/migrate"
+	"gorm-reference/internal/db/migrations"
+	"gorm-reference/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestMigrationsMatchModels applies every migration to a scratch schema and
+// checks the result against the GORM schema of each model. It needs a
+// Postgres database in TEST_DATABASE_URL.
+func TestMigrationsMatchModels(t *testing.T) {
+	sqlDB := openScratchSchema(t)
+	ctx := context.Background()
+
+	m, err := migrate.New(sqlDB, migrations.FS)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+
+	// Readiness probes ask for pending migrations before the first one ran;
+	// the answer is all of them, and asking creates nothing
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if len(pending) != len(m.Migrations()) {
+		t.Errorf("%d migrations pending on an empty schema, want %d", len(pending), len(m.Migrations()))
+	}
+	var created bool
+	if err := sqlDB.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", migrate.TableName).Scan(&created); err != nil {
+		t.Fatalf("look up %s: %v", migrate.TableName, err)
+	}
+	if created {
+		t.Errorf("listing pending migrations created %s", migrate.TableName)
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open gorm: %v", err)
+	}
+	if err := models.SetupJoinTable(db); err != nil {
+		t.Fatalf("setup join table: %v", err)
+	}
+
+	// Deploys reconcile the declared indexes after migrating; a second run
+	// must not change anything
+	if _, err := indexes.Reconcile(ctx, db, models.All()...); err != nil {
+		t.Fatalf("reconcile indexes: %v", err)
+	}
+	results, err := indexes.Reconcile(ctx, db, models.All()...)
+	if err != nil {
+		t.Fatalf("reconcile indexes again: %v", err)
+	}
+	for _, r := range results {
+		if r.Action != indexes.Unchanged {
+			t.Errorf("%s %s on %s was %s on the second run", r.Kind, r.Name, r.Table, r.Action)
+		}
+	}
+
+	report, err := drift.Check(ctx, db, models.All()...)
+	if err != nil {
+		t.Fatalf("check drift: %v", err)
+	}
+	for _, d := range report.Differences {
+		if d.Severity == drift.Error {
+			t.Errorf("%s %s: %s", d.Table, d.Object, d.Message)
+		}
+	}
+
+	// Every migration must roll back cleanly and apply again
+	if _, err := m.To(ctx, 0); err != nil {
+		t.Fatalf("down to 0: %v", err)
+	}
+	for _, table := range []string{"users", "profiles", "posts", "comments", "tags", "post_tags", "audit_logs", "data_requests"} {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("table %s still exists after rolling back every migration", table)
+		}
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("up after down: %v", err)
+	}
+}
+
+// openScratchSchema returns a connection whose search_path points at a new,
+// empty schema that is dropped when the test ends
+func openScratchSchema(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	scratch := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + scratch); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec("DROP SCHEMA " + scratch + " CASCADE")
+		admin.Close()
+	})
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	config.RuntimeParams["search_path"] = scratch
+
+	db := stdlib.OpenDB(*config)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gorm-reference/internal/config"
+	"gorm-reference/internal/db/rls"
+	"gorm-reference/internal/health"
+	"gorm-reference/internal/logging"
+	"gorm-reference/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// ===================================================
+// Read Replicas
+// Route reads to healthy replicas, everything else to the primary.
+// ===================================================
+
+// ReplicaSet is a GORM plugin that sends reads to the read replicas in turn.
+// Writes, locking reads and everything inside a transaction stay on the
+// primary, as do the reads of a context marked by ReadPrimary. Replicas
+// that fail their health checks are skipped until they pass again; with no
+// healthy replica, reads go to the primary.
+//
+// A nil *ReplicaSet has no replicas.
+type ReplicaSet struct {
+	primary  gorm.ConnPool
+	replicas []*replica
+	checks   func(*replica) []health.Check
+	timeout  time.Duration
+	next     atomic.Uint64
+}
+
+type replica struct {
+	name   string
+	pool   *rls.Pool
+	db     *gorm.DB
+	status atomic.Pointer[replicaStatus]
+}
+
+type replicaStatus struct {
+	healthy   bool
+	err       error
+	checkedAt time.Time
+}
+
+func (r *replica) healthy() bool {
+	s := r.status.Load()
+	return s != nil && s.healthy
+}
+
+// OpenReplicas connects to the replicas of cfg, checks them once and installs
+// the routing on db. Replicas that are unreachable are marked down rather
+// than failing the start. Their pools are registered with reg as
+// "replica-<n>". It returns nil when no replica is configured.
+func OpenReplicas(ctx context.Context, cfg *config.Config, db *gorm.DB, reg prometheus.Registerer) (*ReplicaSet, error) {
+	if len(cfg.DB.Replicas) == 0 {
+		return nil, nil
+	}
+
+	s := &ReplicaSet{
+		primary: db.ConnPool,
+		timeout: cfg.Health.Timeout,
+		checks: func(r *replica) []health.Check {
+			return []health.Check{
+				PingCheck(r.name, r.db),
+				ReplicaLagCheck(r.name, r.db, cfg.Health.MaxReplicaLag),
+			}
+		},
+	}
+	for i, addr := range cfg.DB.Replicas {
+		pool, err := openPool(cfg, cfg.DB.ReplicaDSN(addr))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open replica %s: %w", addr, err)
+		}
+		replicaDB, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{
+			Logger:               logging.GORM(),
+			DisableAutomaticPing: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open replica %s: %w", addr, err)
+		}
+
+		name := fmt.Sprintf("replica-%d", i+1)
+		if err := metrics.RegisterStats(reg, name, pool.Stats); err != nil {
+			return nil, err
+		}
+		s.replicas = append(s.replicas, &replica{name: name, pool: pool, db: replicaDB})
+	}
+
+	s.CheckNow(ctx)
+	if err := db.Use(s); err != nil {
+		return nil, fmt.Errorf("failed to install %s plugin: %w", s.Name(), err)
+	}
+	return s, nil
+}
+
+// Name implements gorm.Plugin
+func (s *ReplicaSet) Name() string {
+	return "replicas"
+}
+
+// Initialize implements gorm.Plugin. Routing runs before every other
+// callback, so the metrics, tracing and slow query plugins see the pool the
+// statement actually runs on.
+func (s *ReplicaSet) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Query().Before("*").Register("replicas:route_query", s.route); err != nil {
+		return err
+	}
+	return cb.Row().Before("*").Register("replicas:route_row", s.route)
+}
+
+func (s *ReplicaSet) route(db *gorm.DB) {
+	stmt := db.Statement
+	// Transactions, prepared statements and explicit pools are left alone
+	if stmt.ConnPool != s.primary || ReadsPrimary(stmt.Context) {
+		return
+	}
+	if _, locking := stmt.Clauses["FOR"]; locking {
+		return
+	}
+	if raw := stmt.SQL.String(); raw != "" && !readOnly(raw) {
+		return
+	}
+	if pool := s.pick(); pool != nil {
+		stmt.ConnPool = pool
+	}
+}
+
+// readOnly guesses whether raw SQL can run on a replica: a SELECT that takes
+// no row locks
+func readOnly(sql string) bool {
+	sql = strings.ToUpper(strings.TrimSpace(sql))
+	return strings.HasPrefix(sql, "SELECT") && !strings.Contains(sql, " FOR UPDATE") &&
+		!strings.Contains(sql, " FOR SHARE") && !strings.Contains(sql, " FOR NO KEY UPDATE")
+}
+
+// pick returns the next healthy replica, or nil if there is none
+func (s *ReplicaSet) pick() gorm.ConnPool {
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1)
+	for i := range n {
+		if r := s.replicas[(start+i)%n]; r.healthy() {
+			return r.pool
+		}
+	}
+	return nil
+}
+
+// CheckNow runs the health checks of every replica and marks each up or
+// down
+func (s *ReplicaSet) CheckNow(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	for _, r := range s.replicas {
+		s.check(ctx, r)
+	}
+}
+
+func (s *ReplicaSet) check(ctx context.Context, r *replica) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var err error
+	for _, c := range s.checks(r) {
+		if _, err = c.Run(ctx); err != nil {
+			break
+		}
+	}
+
+	prev := r.status.Swap(&replicaStatus{healthy: err == nil, err: err, checkedAt: time.Now()})
+	switch {
+	case err != nil && (prev == nil || prev.healthy):
+		logger.WarnContext(ctx, "replica marked down", "replica", r.name, "error", err)
+	case err == nil && prev != nil && !prev.healthy:
+		logger.InfoContext(ctx, "replica marked up", "replica", r.name)
+	}
+}
+
+// Watch checks the replicas every interval until ctx is done
+func (s *ReplicaSet) Watch(ctx context.Context, interval time.Duration) {
+	if s == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.CheckNow(ctx)
+		}
+	}
+}
+
+// Checks returns an optional readiness check per replica reporting the
+// outcome of its last health check. A replica being down does not make the
+// instance unready, since reads fall back to the primary.
+func (s *ReplicaSet) Checks() []health.Check {
+	if s == nil {
+		return nil
+	}
+	checks := make([]health.Check, len(s.replicas))
+	for i, r := range s.replicas {
+		checks[i] = health.Check{
+			Name:     r.name,
+			Optional: true,
+			Run: func(context.Context) (any, error) {
+				status := r.status.Load()
+				if status == nil {
+					return nil, fmt.Errorf("not checked yet")
+				}
+				details := map[string]any{"checkedAt": status.checkedAt}
+				return details, status.err
+			},
+		}
+	}
+	return checks
+}
+
+// Close closes the replica pools
+func (s *ReplicaSet) Close() error {
+	if s == nil {
+		return nil
+	}
+	var firstErr error
+	for _, r := range s.replicas {
+		if err := r.pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type primaryKey struct{}
+
+// ReadPrimary returns ctx with reads sent to the primary, for reads that must
+// see a write made just before, which a replica may not have replayed yet:
+//
+//	repo.User.Create(ctx, user)
+//	user, err := repo.User.FindByID(db.ReadPrimary(ctx), user.ID)
+func ReadPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// ReadsPrimary reports whether ctx was marked by ReadPrimary
+func ReadsPrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
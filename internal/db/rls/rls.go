@@ -0,0 +1,165 @@
+// Package rls connects the application to the row-level security policies
+// of migration 00009, the second layer of tenant isolation behind the tenant
+// plugin. The policies only show a session the rows of the tenant named by
+// its app.tenant_id setting, so a statement that forgets its WHERE clause,
+// raw SQL included, still can't read or write another tenant's rows.
+//
+// Pool sets the variables on every connection database/sql checks out, from
+// the context it is checked out with. Statements, Rows and Row cursors and
+// transactions all run on a checked out connection, so they all see the rows
+// of their tenant, for one round trip per checkout.
+package rls
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"gorm-reference/internal/logging"
+	"gorm-reference/internal/tenant"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+var logger = logging.For("rls")
+
+// The session variables the policies read
+const (
+	TenantVariable = "app.tenant_id"
+	BypassVariable = "app.bypass_rls"
+)
+
+// setSession is SET for each variable; SET itself takes no parameters
+const setSession = `SELECT set_config('` + TenantVariable + `', $1, false),
+    set_config('` + BypassVariable + `', $2, false)`
+
+// Pool is a database/sql pool over a pgxpool.Pool. database/sql keeps no
+// connections of its own: each checkout acquires one from the pgxpool, which
+// sets the session variables from the checkout's context, the tenant and
+// whether the context may see every tenant. Every acquisition sets them, so
+// they never outlive the checkout that set them.
+type Pool struct {
+	*sql.DB
+	pgx        *pgxpool.Pool
+	maxOpen    int
+	maxIdle    int32
+	idleClosed atomic.Int64
+}
+
+var (
+	_ gorm.ConnPool       = (*Pool)(nil)
+	_ gorm.TxBeginner     = (*Pool)(nil)
+	_ gorm.GetDBConnector = (*Pool)(nil)
+)
+
+// Options sizes a Pool like the setters of sql.DB do. Zero limits mean no
+// limit, as they do there, except for MaxIdleConns, which keeps no idle
+// connections at zero.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	MaxIdleTime     time.Duration
+	MaxConnLifetime time.Duration
+}
+
+// Open opens a Pool connecting with config, sized by opts. A BeforeAcquire
+// hook of config runs after the variables are set.
+func Open(ctx context.Context, config *pgxpool.Config, opts Options) (*Pool, error) {
+	p := &Pool{maxOpen: max(opts.MaxOpenConns, 0), maxIdle: clamp(opts.MaxIdleConns)}
+
+	config.MaxConns = math.MaxInt32
+	if opts.MaxOpenConns > 0 {
+		config.MaxConns = clamp(opts.MaxOpenConns)
+	}
+	// pgxpool closes connections past zero durations at once
+	config.MaxConnIdleTime = forever(opts.MaxIdleTime)
+	config.MaxConnLifetime = forever(opts.MaxConnLifetime)
+
+	before := config.BeforeAcquire
+	config.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+		if err := setVariables(ctx, conn); err != nil {
+			// The pool destroys the connection and acquires another
+			logger.WarnContext(ctx, "failed to set the row-level security variables", "error", err)
+			return false
+		}
+		return before == nil || before(ctx, conn)
+	}
+	after := config.AfterRelease
+	config.AfterRelease = func(conn *pgx.Conn) bool {
+		if after != nil && !after(conn) {
+			return false
+		}
+		// pgxpool has no idle limit of its own
+		if p.pgx.Stat().IdleConns() >= p.maxIdle {
+			p.idleClosed.Add(1)
+			return false
+		}
+		return true
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	p.pgx = pool
+	p.DB = stdlib.OpenDBFromPool(pool)
+	return p, nil
+}
+
+func setVariables(ctx context.Context, conn *pgx.Conn) error {
+	tenantID, _ := tenant.FromContext(ctx)
+
+	bypass := "off"
+	if tenant.Unscoped(ctx) {
+		bypass = "on"
+	}
+
+	_, err := conn.Exec(ctx, setSession, tenantID, bypass)
+	return err
+}
+
+// GetDBConn implements gorm.GetDBConnector, so that gorm.DB.DB still returns
+// the pool
+func (p *Pool) GetDBConn() (*sql.DB, error) {
+	return p.DB, nil
+}
+
+// Stats returns the statistics of the pgxpool as sql.DBStats. Those of
+// database/sql only count the connections checked out.
+func (p *Pool) Stats() sql.DBStats {
+	s := p.pgx.Stat()
+	return sql.DBStats{
+		MaxOpenConnections: p.maxOpen,
+		OpenConnections:    int(s.TotalConns()),
+		InUse:              int(s.AcquiredConns()),
+		Idle:               int(s.IdleConns()),
+		WaitCount:          s.EmptyAcquireCount(),
+		WaitDuration:       s.EmptyAcquireWaitTime(),
+		MaxIdleClosed:      p.idleClosed.Load(),
+		MaxIdleTimeClosed:  s.MaxIdleDestroyCount(),
+		MaxLifetimeClosed:  s.MaxLifetimeDestroyCount(),
+	}
+}
+
+// Close closes the database/sql pool, then the pgxpool under it
+func (p *Pool) Close() error {
+	err := p.DB.Close()
+	p.pgx.Close()
+	return err
+}
+
+func clamp(n int) int32 {
+	return int32(min(max(n, 0), math.MaxInt32))
+}
+
+func forever(d time.Duration) time.Duration {
+	if d <= 0 {
+		return math.MaxInt64
+	}
+	return d
+}
@@ -0,0 +1,230 @@
+package rls_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gorm-reference/internal/db/migrate"
+	"gorm-reference/internal/db/migrations"
+	"gorm-reference/internal/db/rls"
+	"gorm-reference/internal/models"
+	"gorm-reference/internal/tenant"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestCrossTenantReadsReturnNothing seeds a user in two tenants and checks
+// that neither can see the other's, through GORM, raw SQL or the bare pool.
+// It needs a Postgres database in TEST_DATABASE_URL.
+func TestCrossTenantReadsReturnNothing(t *testing.T) {
+	db, pool := openAppDB(t)
+
+	acme := tenant.WithID(context.Background(), "acme")
+	globex := tenant.WithID(context.Background(), "globex")
+	seedUser(t, db.WithContext(acme), "wile@acme.test")
+	seedUser(t, db.WithContext(globex), "hank@globex.test")
+
+	t.Run("scoped query", func(t *testing.T) {
+		var users []models.User
+		if err := db.WithContext(acme).Find(&users).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || *users[0].Email != "wile@acme.test" {
+			t.Errorf("acme sees %d users, want only its own", len(users))
+		}
+	})
+
+	t.Run("raw SQL in a tenant transaction", func(t *testing.T) {
+		var emails []string
+		err := db.WithContext(acme).Transaction(func(tx *gorm.DB) error {
+			return tx.Raw("SELECT email FROM users WHERE tenant_id = 'globex'").Scan(&emails).Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(emails) != 0 {
+			t.Errorf("acme read globex's users through raw SQL: %v", emails)
+		}
+
+		err = db.WithContext(acme).Transaction(func(tx *gorm.DB) error {
+			return tx.Raw("SELECT email FROM users").Scan(&emails).Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(emails) != 1 || emails[0] != "wile@acme.test" {
+			t.Errorf("acme reads %v through raw SQL, want only its own user", emails)
+		}
+	})
+
+	t.Run("raw SQL outside a transaction", func(t *testing.T) {
+		var count int64
+		if err := db.WithContext(acme).Raw("SELECT count(*) FROM users").Row().Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("a row outside a transaction sees %d users, want acme's one", count)
+		}
+	})
+
+	t.Run("cursor outside a transaction", func(t *testing.T) {
+		rows, err := db.WithContext(acme).Model(&models.User{}).Rows()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		var emails []string
+		for rows.Next() {
+			var user models.User
+			if err := db.ScanRows(rows, &user); err != nil {
+				t.Fatal(err)
+			}
+			emails = append(emails, *user.Email)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(emails) != 1 || emails[0] != "wile@acme.test" {
+			t.Errorf("acme's cursor reads %v, want only its own user", emails)
+		}
+	})
+
+	t.Run("bare pool", func(t *testing.T) {
+		// The pool has one connection, so this reuses the one the tenant
+		// statements ran on: their settings must not have leaked
+		var count int64
+		if err := pool.QueryRow("SELECT count(*) FROM users").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("the bare pool sees %d users, want none", count)
+		}
+	})
+
+	t.Run("writes into another tenant", func(t *testing.T) {
+		err := db.WithContext(acme).Transaction(func(tx *gorm.DB) error {
+			return tx.Exec(`INSERT INTO users (tenant_id, email, username, password_hash)
+                VALUES ('globex', 'mole@globex.test', 'mole', 'secret')`).Error
+		})
+		if err == nil {
+			t.Error("acme inserted a user into globex")
+		}
+
+		result := db.WithContext(acme).Exec("UPDATE users SET is_active = false WHERE tenant_id = 'globex'")
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if result.RowsAffected != 0 {
+			t.Errorf("acme updated %d of globex's users", result.RowsAffected)
+		}
+	})
+
+	t.Run("unscoped", func(t *testing.T) {
+		var count int64
+		if err := db.WithContext(tenant.AllowUnscoped(context.Background())).Model(&models.User{}).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("an unscoped context sees %d users, want both", count)
+		}
+	})
+}
+
+func seedUser(t *testing.T, db *gorm.DB, email string) {
+	t.Helper()
+
+	username := email[:len(email)-len(".test")]
+	user := models.User{Email: &email, Username: &username, PasswordHash: "secret"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("seed %s: %v", email, err)
+	}
+}
+
+// openAppDB migrates a scratch schema and connects to it as a role that,
+// like the application, is subject to row-level security. Superusers, which
+// tests often connect as, bypass it.
+func openAppDB(t *testing.T) (*gorm.DB, *rls.Pool) {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	suffix := time.Now().UnixNano()
+	scratch := fmt.Sprintf("rls_test_%d", suffix)
+	role := fmt.Sprintf("rls_test_app_%d", suffix)
+	for _, stmt := range []string{
+		"CREATE SCHEMA " + scratch,
+		"CREATE ROLE " + role + " NOLOGIN NOSUPERUSER NOBYPASSRLS",
+	} {
+		if _, err := admin.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec("DROP SCHEMA " + scratch + " CASCADE")
+		_, _ = admin.Exec("DROP OWNED BY " + role)
+		_, _ = admin.Exec("DROP ROLE " + role)
+		admin.Close()
+	})
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	config.RuntimeParams["search_path"] = scratch
+
+	owner := stdlib.OpenDB(*config)
+	t.Cleanup(func() { owner.Close() })
+	m, err := migrate.New(owner, migrations.FS)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	for _, stmt := range []string{
+		"GRANT USAGE ON SCHEMA " + scratch + " TO " + role,
+		"GRANT ALL ON ALL TABLES IN SCHEMA " + scratch + " TO " + role,
+		"GRANT ALL ON ALL SEQUENCES IN SCHEMA " + scratch + " TO " + role,
+	} {
+		if _, err := owner.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	poolConfig.ConnConfig.RuntimeParams["search_path"] = scratch
+	poolConfig.ConnConfig.RuntimeParams["role"] = role
+	pool, err := rls.Open(context.Background(), poolConfig, rls.Options{MaxOpenConns: 1, MaxIdleConns: 1})
+	if err != nil {
+		t.Fatalf("open pool: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open gorm: %v", err)
+	}
+	if err := db.Use(tenant.NewGORMPlugin()); err != nil {
+		t.Fatalf("install tenant plugin: %v", err)
+	}
+	return db, pool
+}
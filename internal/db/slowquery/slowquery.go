@@ -0,0 +1,278 @@
+// Package slowquery provides a GORM plugin that logs statements slower than
+// a threshold, together with the repository method that ran them and,
+// optionally, their query plan.
+package slowquery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"gorm-reference/internal/db/gormutil"
+	"gorm-reference/internal/logging"
+
+	"gorm.io/gorm"
+)
+
+const startKey = "slowquery:start"
+
+// Config configures the plugin
+type Config struct {
+	// Threshold is the duration above which a statement is logged
+	Threshold time.Duration
+
+	// Explain runs EXPLAIN (without ANALYZE, so nothing executes twice) for
+	// slow statements and records the plan in Query.Plan. Statements with
+	// bind parameters get their generic plan, which doesn't show the values,
+	// and only inside a transaction.
+	Explain bool
+
+	// ExplainTimeout bounds the EXPLAIN. Defaults to one second.
+	ExplainTimeout time.Duration
+
+	// CallerPrefix selects the frame reported as the caller. Defaults to the
+	// repository package.
+	CallerPrefix string
+
+	// Log receives every slow statement with the statement's context.
+	// Defaults to LogQuery.
+	Log func(context.Context, Query)
+}
+
+// Query describes one slow statement
+type Query struct {
+	SQL          string // with placeholders, never with the values
+	Vars         []string
+	Duration     time.Duration
+	Table        string
+	Operation    string
+	Caller       string
+	RowsAffected int64
+	Err          error
+	Plan         string
+}
+
+// Plugin is the slow query GORM plugin
+type Plugin struct {
+	cfg Config
+}
+
+var _ gorm.Plugin = (*Plugin)(nil)
+
+// New returns the plugin for cfg
+func New(cfg Config) *Plugin {
+	if cfg.ExplainTimeout <= 0 {
+		cfg.ExplainTimeout = time.Second
+	}
+	if cfg.CallerPrefix == "" {
+		cfg.CallerPrefix = "gorm-reference/internal/repository."
+	}
+	if cfg.Log == nil {
+		cfg.Log = LogQuery
+	}
+	return &Plugin{cfg: cfg}
+}
+
+// Name implements gorm.Plugin
+func (p *Plugin) Name() string {
+	return "slowquery"
+}
+
+// Initialize times each of GORM's operations and logs those over the
+// threshold
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	before := func(string) func(*gorm.DB) { return p.before }
+	return gormutil.Register(gormutil.Around(db, "slowquery", before, p.after))
+}
+
+func (p *Plugin) before(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p *Plugin) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		start, _ := value.(time.Time)
+
+		elapsed := time.Since(start)
+		if elapsed < p.cfg.Threshold || db.DryRun {
+			return
+		}
+
+		q := Query{
+			SQL:          db.Statement.SQL.String(),
+			Vars:         Redact(db.Statement.Vars),
+			Duration:     elapsed,
+			Table:        db.Statement.Table,
+			Operation:    operation,
+			Caller:       caller(p.cfg.CallerPrefix),
+			RowsAffected: db.RowsAffected,
+			Err:          db.Error,
+		}
+		if p.cfg.Explain && db.Error == nil && explainable(q.SQL) {
+			q.Plan = p.explain(db)
+		}
+
+		p.cfg.Log(db.Statement.Context, q)
+	}
+}
+
+// explain runs EXPLAIN on the statement's own connection, so it sees the
+// same transaction, and bypasses GORM so no callback runs for it
+func (p *Plugin) explain(db *gorm.DB) string {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(db.Statement.Context), p.cfg.ExplainTimeout)
+	defer cancel()
+
+	sql := db.Statement.SQL.String()
+	if len(db.Statement.Vars) == 0 {
+		return plan(ctx, db.Statement.ConnPool, "EXPLAIN "+sql)
+	}
+
+	// EXPLAIN with the bind parameters would plan for their values and print
+	// them in the plan, undoing Redact. The generic plan shows $1, $2, …
+	// instead, and needs a savepoint, so statements outside a transaction go
+	// without a plan.
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); !ok {
+		return ""
+	}
+	return genericPlan(ctx, db.Statement.ConnPool, sql, len(db.Statement.Vars))
+}
+
+// genericPlan explains the generic plan of sql, which has n bind parameters,
+// in a savepoint of tx. The savepoint is rolled back, so that neither a
+// failure nor the plan_cache_mode setting outlives it.
+func genericPlan(ctx context.Context, tx gorm.ConnPool, sql string, n int) string {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT slowquery_explain"); err != nil {
+		return "explain failed: " + err.Error()
+	}
+	prepared := false
+	defer func() {
+		// Even after a timeout, the transaction must be left as it was found
+		cleanup := context.WithoutCancel(ctx)
+		_, _ = tx.ExecContext(cleanup, "ROLLBACK TO SAVEPOINT slowquery_explain")
+		_, _ = tx.ExecContext(cleanup, "RELEASE SAVEPOINT slowquery_explain")
+		// Prepared statements outlive the savepoint
+		if prepared {
+			_, _ = tx.ExecContext(cleanup, "DEALLOCATE slowquery_explain")
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, "PREPARE slowquery_explain AS "+sql); err != nil {
+		return "explain failed: " + err.Error()
+	}
+	prepared = true
+
+	if _, err := tx.ExecContext(ctx, "SET LOCAL plan_cache_mode = force_generic_plan"); err != nil {
+		return "explain failed: " + err.Error()
+	}
+	nulls := strings.TrimSuffix(strings.Repeat("NULL, ", n), ", ")
+	return plan(ctx, tx, "EXPLAIN EXECUTE slowquery_explain("+nulls+")")
+}
+
+// plan runs an EXPLAIN statement and returns its lines
+func plan(ctx context.Context, conn gorm.ConnPool, explain string) string {
+	rows, err := conn.QueryContext(ctx, explain)
+	if err != nil {
+		return "explain failed: " + err.Error()
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "explain failed: " + err.Error()
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return "explain failed: " + err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+var logger = logging.For("slowquery")
+
+// LogQuery logs q at warn on the "slowquery" logger
+func LogQuery(ctx context.Context, q Query) {
+	attrs := []any{
+		"duration_ms", float64(q.Duration.Microseconds()) / 1000,
+		"caller", q.Caller,
+		"operation", q.Operation,
+		"table", q.Table,
+		"rows", q.RowsAffected,
+		"sql", q.SQL,
+		"vars", q.Vars,
+	}
+	if q.Err != nil {
+		attrs = append(attrs, "error", q.Err)
+	}
+	if q.Plan != "" {
+		attrs = append(attrs, "plan", q.Plan)
+	}
+	logger.Log(ctx, slog.LevelWarn, "slow query", attrs...)
+}
+
+// Redact describes bind parameters without their values. Numbers, booleans,
+// times and NULLs are kept because they identify rows rather than people;
+// everything else is replaced by its type and length.
+func Redact(vars []any) []string {
+	out := make([]string, len(vars))
+	for i, v := range vars {
+		switch v := v.(type) {
+		case nil:
+			out[i] = "NULL"
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			out[i] = fmt.Sprint(v)
+		case time.Time:
+			out[i] = v.Format(time.RFC3339)
+		case string:
+			out[i] = fmt.Sprintf("<string len=%d>", len(v))
+		case []byte:
+			out[i] = fmt.Sprintf("<bytes len=%d>", len(v))
+		default:
+			out[i] = fmt.Sprintf("<%T>", v)
+		}
+	}
+	return out
+}
+
+// caller returns the innermost function whose name starts with prefix, or
+// the first frame outside GORM and the standard library when none does
+func caller(prefix string) string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	fallback := "unknown"
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, prefix) {
+			return strings.TrimPrefix(frame.Function, "gorm-reference/internal/")
+		}
+		// Standard library packages have no slash in their import path
+		if fallback == "unknown" && strings.Contains(frame.Function, "/") &&
+			!strings.HasPrefix(frame.Function, "gorm.io/") &&
+			!strings.HasPrefix(frame.Function, "gorm-reference/internal/db/slowquery.") {
+			fallback = fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return fallback
+		}
+	}
+}
+
+func explainable(sql string) bool {
+	word, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	switch strings.ToUpper(word) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+
+	"gorm-reference/internal/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag returns the entity tag of a record at version. Every update increments
+// the version, so it is a strong validator.
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch returns the version the If-Match header requires. ok is false when
+// the header is missing or "*", which match any version. Weak or malformed
+// tags never match, since If-Match uses strong comparison.
+func ifMatch(c *gin.Context) (version int64, ok bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	tag, err := strconv.Unquote(header)
+	if err == nil {
+		version, err = strconv.ParseInt(tag, 10, 64)
+	}
+	if err != nil || version <= 0 {
+		return 0, false, apperror.ErrPreconditionFailed
+	}
+	return version, true, nil
+}
+
+// notModified reports whether the If-None-Match header names etag
+func notModified(c *gin.Context, etag string) bool {
+	for tag := range strings.SplitSeq(c.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,64 @@
 // Package handler
 package handler
 
-import "gorm-reference/internal/service"
+import (
+	"gorm-reference/internal/health"
+	"gorm-reference/internal/metrics"
+	"gorm-reference/internal/service"
+	"gorm-reference/internal/tenant"
 
-type Handler struct{}
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
 
-func NewHandler(s *service.Service) *Handler {
-	return &Handler{}
+type Handler struct {
+	User    UserHandler
+	Trash   TrashHandler
+	Privacy PrivacyHandler
+	Health  HealthHandler
+	Metrics gin.HandlerFunc
+	Tenant  gin.HandlerFunc
+}
+
+func NewHandler(s *service.Service, ready *health.Checker, reg *prometheus.Registry, tenants tenant.Resolver) *Handler {
+	return &Handler{
+		User:    &userHandler{svc: s},
+		Trash:   &trashHandler{svc: s},
+		Privacy: &privacyHandler{svc: s},
+		Health:  &healthHandler{ready: ready},
+		Metrics: gin.WrapH(metrics.Handler(reg)),
+		Tenant:  Tenant(tenants),
+	}
+}
+
+// Register installs the middleware and routes on the given engine
+func (h *Handler) Register(r *gin.Engine) {
+	r.Use(RequestID(), Tracing(), RequestLogger(), ErrorHandler())
+
+	r.GET("/healthz", h.Health.Liveness)
+	r.GET("/readyz", h.Health.Readiness)
+	r.GET("/metrics", h.Metrics)
+
+	// Probes and metrics serve the whole deployment; the API serves a tenant
+	users := r.Group("/users", h.Tenant)
+	users.POST("", h.User.Create)
+	users.POST("/import", h.User.Import)
+	users.GET("/export", h.User.Export)
+	users.GET("/:id", h.User.Get)
+	users.PUT("/:id", h.User.Update)
+	users.PATCH("/:id", h.User.Patch)
+	users.DELETE("/:id", h.User.Delete)
+	users.POST("/:id/restore", h.User.Restore)
+	users.PATCH("/:id/preferences", h.User.MergePreferences)
+	users.GET("/:id/preferences/:key", h.User.GetPreference)
+	users.PUT("/:id/preferences/:key", h.User.SetPreference)
+	users.DELETE("/:id/preferences/:key", h.User.DeletePreference)
+	users.POST("/:id/export", h.Privacy.Export)
+	users.POST("/:id/erase", h.Privacy.Erase)
+	users.GET("/:id/data-requests", h.Privacy.Requests)
+
+	trash := r.Group("/trash", h.Tenant)
+	trash.GET("/:entity", h.Trash.List)
+	trash.POST("/:entity/:id/restore", h.Trash.Restore)
+	trash.DELETE("/:entity/:id", h.Trash.Purge)
 }
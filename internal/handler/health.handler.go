@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+
+	"gorm-reference/internal/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+var _ HealthHandler = (*healthHandler)(nil)
+
+type HealthHandler interface {
+	Liveness(*gin.Context)
+	Readiness(*gin.Context)
+}
+
+type healthHandler struct {
+	ready *health.Checker
+}
+
+// Liveness reports that the process is serving requests. It deliberately
+// doesn't touch the database: an outage there should take the instance out
+// of rotation, not get it restarted.
+func (h *healthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// Readiness runs the readiness checks, answering 503 if any of them fails
+func (h *healthHandler) Readiness(c *gin.Context) {
+	report := h.ready.Check(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status != health.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/logging"
+	"gorm-reference/internal/tenant"
+	"gorm-reference/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+var logger = logging.For("handler")
+
+// RequestID reuses the caller's X-Request-ID or generates one, echoes it in
+// the response and puts it on the request context, so every log record of
+// the request carries it
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// RequestLogger logs every request once it has been served, at warn for 4xx
+// and error for 5xx responses
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		logger.Log(c.Request.Context(), level, "request served",
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// ErrorHandler renders the last error attached to the context with c.Error
+// as an RFC 7807 problem+json response. Handlers only need to call c.Error
+// and return.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		problem := apperror.NewProblem(err, c.Request.URL.Path)
+		if problem.Code == apperror.CodeInternal {
+			// The cause is hidden from the client, so keep it in the logs
+			logger.ErrorContext(c.Request.Context(), "request failed",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"error", err,
+			)
+		}
+
+		c.Header("Content-Type", apperror.ProblemContentType)
+		c.JSON(problem.Status, problem)
+	}
+}
+
+// Tenant resolves the tenant of the request and puts it on the request
+// context, which scopes every statement run for the request to it
+func Tenant(resolver tenant.Resolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := resolver.Resolve(c.Request)
+		switch {
+		case errors.Is(err, tenant.ErrMissing):
+			_ = c.Error(apperror.ErrTenantRequired.Wrap(err))
+			c.Abort()
+			return
+		case err != nil:
+			_ = c.Error(apperror.ErrInvalidTenant.Wrap(err))
+			c.Abort()
+			return
+		}
+
+		c.Request = c.Request.WithContext(tenant.WithID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// Tracing starts a server span for every request, continuing the trace of
+// the caller when it sent a traceparent header. The span rides on the
+// request context, so the service and GORM spans become its children.
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if len(c.Errors) > 0 {
+			span.RecordError(c.Errors.Last().Err)
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/models"
+	"gorm-reference/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+var _ PrivacyHandler = (*privacyHandler)(nil)
+
+type PrivacyHandler interface {
+	Export(*gin.Context)
+	Erase(*gin.Context)
+	Requests(*gin.Context)
+}
+
+type privacyHandler struct {
+	svc *service.Service
+}
+
+// Export downloads everything stored about a user, as one JSON document or,
+// with ?format=zip, as a ZIP archive of one JSON file per kind of record
+func (h *privacyHandler) Export(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		_ = c.Error(apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "format must be json or zip"))
+		return
+	}
+
+	data, err := h.svc.Privacy.Export(c.Request.Context(), id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	filename := fmt.Sprintf("user-%d-export.%s", id, format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "json" {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+
+	// Built in memory, so that a failure is still reported as an error
+	var archive bytes.Buffer
+	if err := writeArchive(&archive, data); err != nil {
+		_ = c.Error(apperror.ErrInternal.Wrap(err))
+		return
+	}
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// Erase anonymizes the personal data of a user and returns the record of
+// the request
+func (h *privacyHandler) Erase(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	request, err := h.svc.Privacy.Erase(c.Request.Context(), id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
+
+// Requests lists the data requests made for a user
+func (h *privacyHandler) Requests(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	requests, err := h.svc.Privacy.Requests(c.Request.Context(), id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": requests})
+}
+
+// writeArchive writes data to w as a ZIP archive
+func writeArchive(w io.Writer, data *models.UserData) error {
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"export.json", gin.H{"exportedAt": data.ExportedAt, "requestId": data.RequestID}},
+		{"user.json", data.User},
+		{"profiles.json", data.Profiles},
+		{"posts.json", data.Posts},
+		{"comments.json", data.Comments},
+		{"tags_added.json", data.TagsAdded},
+		{"audit_log.json", data.AuditLog},
+		{"requests.json", data.Requests},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, f := range files {
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: data.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(f.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of listings
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+var _ TrashHandler = (*trashHandler)(nil)
+
+type TrashHandler interface {
+	List(*gin.Context)
+	Restore(*gin.Context)
+	Purge(*gin.Context)
+}
+
+type trashHandler struct {
+	svc *service.Service
+}
+
+// List returns a page of the deleted records of an entity, e.g.
+// /trash/users?page=2&perPage=50
+func (h *trashHandler) List(c *gin.Context) {
+	page, perPage, err := pageParams(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	items, total, err := h.svc.Trash.List(c.Request.Context(), c.Param("entity"), page, perPage)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":   items,
+		"total":   total,
+		"page":    page,
+		"perPage": perPage,
+	})
+}
+
+// Restore takes a record and the records deleted with it out of the trash
+func (h *trashHandler) Restore(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := h.svc.Trash.Restore(c.Request.Context(), c.Param("entity"), id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Purge permanently deletes a record in the trash
+func (h *trashHandler) Purge(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := h.svc.Trash.Purge(c.Request.Context(), c.Param("entity"), id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// pageParams parses the page and perPage query parameters
+func pageParams(c *gin.Context) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "page must be a positive integer")
+		}
+	}
+	if v := c.Query("perPage"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput,
+				"perPage must be between 1 and "+strconv.Itoa(maxPerPage))
+		}
+	}
+	return page, perPage, nil
+}
@@ -1,6 +1,16 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/bulk"
+	"gorm-reference/internal/models"
 	"gorm-reference/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -10,11 +20,372 @@ var _ UserHandler = (*userHandler)(nil)
 
 type UserHandler interface {
 	Create(*gin.Context)
+	Get(*gin.Context)
+	Update(*gin.Context)
+	Patch(*gin.Context)
+	GetPreference(*gin.Context)
+	SetPreference(*gin.Context)
+	DeletePreference(*gin.Context)
+	MergePreferences(*gin.Context)
+	Delete(*gin.Context)
+	Restore(*gin.Context)
+	Import(*gin.Context)
+	Export(*gin.Context)
 }
 
 type userHandler struct {
 	svc *service.Service
 }
 
+// Create creates a user from a models.NewUser, which carries the password
 func (h *userHandler) Create(c *gin.Context) {
+	var input models.NewUser
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(apperror.ErrInvalidInput.Wrap(err))
+		return
+	}
+
+	user, err := h.svc.User.Create(c.Request.Context(), input)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("ETag", etag(user.Version))
+	c.JSON(http.StatusCreated, user)
+}
+
+// Get returns a user with its version as ETag, or 304 when the client's
+// copy, named by If-None-Match, is current
+func (h *userHandler) Get(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	user, err := h.svc.User.Get(c.Request.Context(), id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	tag := etag(user.Version)
+	c.Header("ETag", tag)
+	if notModified(c, tag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// Update applies the fields in the body to a user. The version to update
+// comes from If-Match, or else from the body; without either the update is
+// unconditional. A stale version is answered with 412 when it came from
+// If-Match and 409 otherwise.
+func (h *userHandler) Update(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var updates models.User
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		_ = c.Error(apperror.ErrInvalidInput.Wrap(err))
+		return
+	}
+	version, conditional, err := ifMatch(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if conditional {
+		updates.Version = version
+	}
+
+	user, err := h.svc.User.Update(c.Request.Context(), id, updates)
+	if conditional && errors.Is(err, apperror.ErrVersionConflict) {
+		err = apperror.ErrPreconditionFailed.Wrap(err)
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("ETag", etag(user.Version))
+	c.JSON(http.StatusOK, user)
+}
+
+// MergePatchContentType is the media type of RFC 7396 merge patches
+const MergePatchContentType = "application/merge-patch+json"
+
+// Patch changes only the fields it names, zero values and nulls included.
+// The body is either a merge patch, sent as application/merge-patch+json, or
+// a user whose fields listed in the update_mask query parameter are written,
+// e.g. ?update_mask=firstName,isActive. Versions work as in Update.
+func (h *userHandler) Patch(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	version, conditional, err := ifMatch(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var user *models.User
+	switch mask := c.Query("update_mask"); {
+	case c.ContentType() == MergePatchContentType:
+		patch, readErr := c.GetRawData()
+		if readErr != nil {
+			_ = c.Error(apperror.ErrInvalidInput.Wrap(readErr))
+			return
+		}
+		user, err = h.svc.User.MergePatch(c.Request.Context(), id, patch, version)
+	case mask != "":
+		var updates models.User
+		if err := c.ShouldBindJSON(&updates); err != nil {
+			_ = c.Error(apperror.ErrInvalidInput.Wrap(err))
+			return
+		}
+		if conditional {
+			updates.Version = version
+		}
+		fields := strings.Split(mask, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		user, err = h.svc.User.UpdateFields(c.Request.Context(), id, updates, fields)
+	default:
+		err = apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput,
+			"send a merge patch as "+MergePatchContentType+" or name the fields in update_mask")
+	}
+
+	if conditional && errors.Is(err, apperror.ErrVersionConflict) {
+		err = apperror.ErrPreconditionFailed.Wrap(err)
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("ETag", etag(user.Version))
+	c.JSON(http.StatusOK, user)
+}
+
+// GetPreference returns the value of one preference
+func (h *userHandler) GetPreference(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	value, err := h.svc.User.GetPreference(c.Request.Context(), id, c.Param("key"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", value)
+}
+
+// SetPreference stores the JSON value in the body as one preference
+func (h *userHandler) SetPreference(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var value any
+	if err := decodeJSON(c, &value); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := h.svc.User.SetPreference(c.Request.Context(), id, c.Param("key"), value); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeletePreference removes one preference
+func (h *userHandler) DeletePreference(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := h.svc.User.DeletePreference(c.Request.Context(), id, c.Param("key")); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// MergePreferences deep merges the object in the body into the preferences
+// and returns them. Null members remove preferences.
+func (h *userHandler) MergePreferences(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var patch map[string]any
+	if err := decodeJSON(c, &patch); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	prefs, err := h.svc.User.MergePreferences(c.Request.Context(), id, patch)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// Delete moves a user to the trash
+func (h *userHandler) Delete(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	if err := h.svc.User.Delete(c.Request.Context(), id); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Restore takes a user out of the trash and returns it
+func (h *userHandler) Restore(c *gin.Context) {
+	id, err := idParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	user, err := h.svc.User.Restore(c.Request.Context(), id)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("ETag", etag(user.Version))
+	c.JSON(http.StatusOK, user)
+}
+
+// Import upserts the users of a CSV or NDJSON body by email and reports the
+// rows that failed, see bulk for the columns. The format is given by
+// ?format=csv|ndjson, or else by the media type of the body. The body is
+// read as it arrives and the report describes at most
+// models.MaxImportErrors failed rows, so imports of any size take the same
+// memory.
+func (h *userHandler) Import(c *gin.Context) {
+	name := c.Query("format")
+	if name == "" {
+		name = importFormats[c.ContentType()]
+	}
+	format, err := bulk.ParseFormat(name)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	report, err := h.svc.User.Import(c.Request.Context(), bulk.NewDecoder(c.Request.Body, format))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// importFormats are the formats of the media types of import bodies
+var importFormats = map[string]string{
+	"text/csv":             string(bulk.CSV),
+	"application/x-ndjson": string(bulk.NDJSON),
+	"application/ndjson":   string(bulk.NDJSON),
+}
+
+// Export downloads the users matching the isActive, username and
+// createdAfter query parameters, as CSV or, with ?format=ndjson, NDJSON.
+// Users are written as they are read from the database. A failure before
+// the first of them is answered with an error; after, the download ends
+// early.
+func (h *userHandler) Export(c *gin.Context) {
+	format, err := bulk.ParseFormat(c.DefaultQuery("format", string(bulk.CSV)))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	filters, err := userFilters(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", `attachment; filename="users.`+string(format)+`"`)
+	encoder := bulk.NewEncoder(c.Writer, format)
+	err = h.svc.User.Export(c.Request.Context(), filters, encoder.Encode)
+	if err == nil {
+		err = encoder.Flush()
+	}
+	if err == nil {
+		return
+	}
+
+	if !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Disposition")
+		_ = c.Error(err)
+		return
+	}
+	// Too late to answer with an error
+	logger.ErrorContext(c.Request.Context(), "export failed", "error", err)
+}
+
+// userFilters parses the filters of user listings from the query
+func userFilters(c *gin.Context) (models.UserFilters, error) {
+	filters := models.UserFilters{Username: c.Query("username")}
+	if v := c.Query("isActive"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			return filters, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "isActive must be true or false")
+		}
+		filters.IsActive = &active
+	}
+	if v := c.Query("createdAfter"); v != "" {
+		after, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filters, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "createdAfter must be an RFC 3339 time")
+		}
+		filters.CreatedAfter = after
+	}
+	return filters, nil
+}
+
+// decodeJSON decodes the request body into v, keeping numbers exact
+func decodeJSON(c *gin.Context, v any) error {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return apperror.ErrInvalidInput.Wrap(err)
+	}
+	return nil
+}
+
+// idParam parses the :id path parameter
+func idParam(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil || id == 0 {
+		return 0, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "invalid id")
+	}
+	return uint(id), nil
 }
@@ -0,0 +1,132 @@
+// Package health runs readiness checks and caches their outcome, so that
+// frequent probes from the orchestrator don't hammer the dependencies.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a check or of the whole report
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// Check is a single readiness check. Run returns optional details that are
+// included in the report, and an error when the dependency is not ready.
+// An Optional check is reported but does not take the instance down, for
+// dependencies the instance can work without, such as a read replica.
+type Check struct {
+	Name     string
+	Run      func(ctx context.Context) (details any, err error)
+	Optional bool
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+	Details   any     `json:"details,omitempty"`
+	Optional  bool    `json:"optional,omitempty"`
+}
+
+// Report is the outcome of every check. Status is down if any check that
+// is not optional is.
+type Report struct {
+	Status    Status    `json:"status"`
+	Checks    []Result  `json:"checks"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Cached    bool      `json:"cached"`
+}
+
+// Checker runs its checks concurrently, each bounded by timeout, and reuses
+// the last report until it is older than ttl
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu   sync.Mutex
+	last *Report
+}
+
+// NewChecker returns a checker for the given checks
+func NewChecker(timeout, ttl time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout, ttl: ttl}
+}
+
+// Add registers another check. It must be called before the checker is used.
+func (c *Checker) Add(checks ...Check) {
+	c.checks = append(c.checks, checks...)
+}
+
+// Check returns the cached report if it is still fresh and runs the checks
+// otherwise. Concurrent callers wait for a single run.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last != nil && time.Since(c.last.CheckedAt) < c.ttl {
+		report := *c.last
+		report.Cached = true
+		return report
+	}
+
+	report := c.run(ctx)
+	c.last = &report
+	return report
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	// A probe that disconnects must not leave a cancelled result in the cache
+	ctx = context.WithoutCancel(ctx)
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.runCheck(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Checks: results, CheckedAt: time.Now()}
+	for _, r := range results {
+		if r.Status == StatusDown && !r.Optional {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func (c *Checker) runCheck(ctx context.Context, check Check) (result Result) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	result = Result{Name: check.Name, Status: StatusUp, Optional: check.Optional}
+	defer func() {
+		if r := recover(); r != nil {
+			result.Status = StatusDown
+			result.Error = fmt.Sprintf("check panicked: %v", r)
+		}
+		result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	details, err := check.Run(ctx)
+	result.Details = details
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
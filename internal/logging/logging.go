@@ -0,0 +1,225 @@
+// Package logging configures the application's log/slog loggers. Records
+// carry the request ID, tenant, user ID and trace ID found in their context,
+// and each package logs through For so its level can be tuned on its own.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"gorm-reference/internal/tenant"
+
+	"go.opentelemetry.io/otel/trace"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// Config configures the loggers
+type Config struct {
+	// Format is "json" or "text"
+	Format string
+
+	// Level applies to packages without an entry in Levels
+	Level string
+
+	// Levels maps a package name, as passed to For, to its level
+	Levels map[string]string
+
+	// Output defaults to stderr
+	Output io.Writer
+}
+
+var (
+	mu     sync.RWMutex
+	base   slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	level  slog.Level   = slog.LevelInfo
+	levels              = map[string]slog.Level{}
+)
+
+// Setup installs cfg and makes slog.Default log through it. Loggers already
+// returned by For pick up the new configuration.
+func Setup(cfg Config) error {
+	defaultLevel, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	packageLevels := make(map[string]slog.Level, len(cfg.Levels))
+	for pkg, l := range cfg.Levels {
+		if packageLevels[pkg], err = ParseLevel(l); err != nil {
+			return fmt.Errorf("package %s: %w", pkg, err)
+		}
+	}
+
+	out := cfg.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	// The base handler lets everything through; packageHandler filters per package
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug, AddSource: true}
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		h = slog.NewJSONHandler(out, opts)
+	case "text":
+		h = slog.NewTextHandler(out, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	mu.Lock()
+	base, level, levels = h, defaultLevel, packageLevels
+	mu.Unlock()
+
+	slog.SetDefault(For("app"))
+	return nil
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error". An empty string is
+// info.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return l, nil
+}
+
+// For returns the logger of a package. Its records carry a "logger" attribute
+// and are filtered by the package's level.
+func For(pkg string) *slog.Logger {
+	return slog.New(&packageHandler{pkg: pkg}).With("logger", pkg)
+}
+
+// GORM adapts the "gorm" logger for use as GORM's logger. Statements are
+// logged with their placeholders, never the values, and only at debug;
+// errors other than record not found are logged at error. Slow statements
+// are reported by the slowquery plugin instead.
+func GORM() gormlogger.Interface {
+	gormLevel := gormlogger.Warn
+	switch l := levelOf("gorm"); {
+	case l <= slog.LevelDebug:
+		gormLevel = gormlogger.Info
+	case l >= slog.LevelError:
+		gormLevel = gormlogger.Error
+	}
+
+	return gormlogger.NewSlogLogger(For("gorm"), gormlogger.Config{
+		LogLevel:                  gormLevel,
+		ParameterizedQueries:      true,
+		IgnoreRecordNotFoundError: true,
+	})
+}
+
+func levelOf(pkg string) slog.Level {
+	mu.RLock()
+	defer mu.RUnlock()
+	if l, ok := levels[pkg]; ok {
+		return l
+	}
+	return level
+}
+
+func handler() slog.Handler {
+	mu.RLock()
+	defer mu.RUnlock()
+	return base
+}
+
+// packageHandler resolves the base handler and the level on every record, so
+// loggers created before Setup still follow it. ops replays the WithAttrs and
+// WithGroup calls on the base handler, in order.
+type packageHandler struct {
+	pkg string
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *packageHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= levelOf(h.pkg)
+}
+
+func (h *packageHandler) Handle(ctx context.Context, r slog.Record) error {
+	next := handler()
+	// Context attributes go first so they stay outside any group
+	if attrs := contextAttrs(ctx); len(attrs) > 0 {
+		next = next.WithAttrs(attrs)
+	}
+	for _, op := range h.ops {
+		next = op(next)
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *packageHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *packageHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *packageHandler) with(op func(slog.Handler) slog.Handler) *packageHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &packageHandler{pkg: h.pkg, ops: append(ops, op)}
+}
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userIDKey
+)
+
+// WithRequestID returns ctx carrying the request ID logged with every record
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithUserID returns ctx carrying the ID of the authenticated user. Nothing
+// authenticates requests yet; whatever does should call it.
+func WithUserID(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+// UserID returns the user ID carried by ctx
+func UserID(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(userIDKey).(uint)
+	return id, ok
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+
+	var attrs []slog.Attr
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if id, ok := tenant.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("tenant_id", id))
+	}
+	if id, ok := UserID(ctx); ok {
+		attrs = append(attrs, slog.Uint64("user_id", uint64(id)))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	return attrs
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm-reference/internal/db/gormutil"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const startKey = "metrics:start"
+
+// GORMPlugin times every statement GORM runs and counts its errors and
+// affected rows, labelled by table and operation
+type GORMPlugin struct {
+	duration     *prometheus.HistogramVec
+	errors       *prometheus.CounterVec
+	rowsAffected *prometheus.CounterVec
+}
+
+var _ gorm.Plugin = (*GORMPlugin)(nil)
+
+// NewGORMPlugin creates the plugin and registers its metrics with reg
+func NewGORMPlugin(reg prometheus.Registerer) *GORMPlugin {
+	p := &GORMPlugin{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Latency of the statements run through GORM.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"table", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "Statements run through GORM that failed, by SQLSTATE class.",
+		}, []string{"table", "operation", "sqlstate_class"}),
+		rowsAffected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_rows_affected_total",
+			Help: "Rows returned or changed by the statements run through GORM.",
+		}, []string{"table", "operation"}),
+	}
+	reg.MustRegister(p.duration, p.errors, p.rowsAffected)
+	return p
+}
+
+// Name implements gorm.Plugin
+func (p *GORMPlugin) Name() string {
+	return "metrics"
+}
+
+// Initialize times each of GORM's operations
+func (p *GORMPlugin) Initialize(db *gorm.DB) error {
+	before := func(string) func(*gorm.DB) { return p.before }
+	return gormutil.Register(gormutil.Around(db, "metrics", before, p.after))
+}
+
+func (p *GORMPlugin) before(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p *GORMPlugin) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		start, _ := value.(time.Time)
+
+		table := db.Statement.Table
+		if table == "" {
+			// Raw statements aren't parsed, so the table is unknown
+			table = "unknown"
+		}
+
+		p.duration.WithLabelValues(table, operation).Observe(time.Since(start).Seconds())
+		if db.RowsAffected > 0 {
+			p.rowsAffected.WithLabelValues(table, operation).Add(float64(db.RowsAffected))
+		}
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			p.errors.WithLabelValues(table, operation, sqlstateClass(db.Error)).Inc()
+		}
+	}
+}
+
+// sqlstateClass returns the first two characters of the Postgres SQLSTATE,
+// e.g. "23" for integrity violations, "context" when the caller gave up, and
+// "unknown" for errors that never reached the database
+func sqlstateClass(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && len(pgErr.Code) >= 2 {
+		return pgErr.Code[:2]
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "context"
+	}
+	return "unknown"
+}
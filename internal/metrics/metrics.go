@@ -0,0 +1,58 @@
+// Package metrics exposes Prometheus metrics for the connection pool and for
+// every query GORM runs.
+//
+// Typical wiring:
+//
+//	reg := metrics.NewRegistry()
+//	if err := metrics.RegisterPool(reg, "primary", db); err != nil { ... }
+//	if err := db.Use(metrics.NewGORMPlugin(reg)); err != nil { ... }
+//	handler := metrics.Handler(reg) // served on /metrics
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// NewRegistry returns a registry with the Go runtime and process collectors
+// already registered
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// Handler serves the metrics gathered by reg in the Prometheus text format
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+// RegisterPool exports the sql.DBStats of db's pool as the go_sql_* metrics:
+// open, in-use and idle connections, wait count and wait duration, and the
+// connections closed by each limit. The stats are read on every scrape, and
+// name tells pools apart through the db_name label. Pools that keep
+// statistics of their own, such as rls.Pool, report those.
+func RegisterPool(reg prometheus.Registerer, name string, db *gorm.DB) error {
+	if pool, ok := db.ConnPool.(StatsReporter); ok {
+		return RegisterStats(reg, name, pool.Stats)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	return RegisterSQLPool(reg, name, sqlDB)
+}
+
+// RegisterSQLPool is RegisterPool for a pool opened without GORM
+func RegisterSQLPool(reg prometheus.Registerer, name string, db *sql.DB) error {
+	return RegisterStats(reg, name, db.Stats)
+}
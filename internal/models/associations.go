@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 
+	"gorm-reference/internal/tenant"
+
 	"gorm.io/gorm"
 )
 
@@ -14,7 +16,8 @@ import (
 
 // AssociationOperations demonstrates working with relationships
 func AssociationOperations(db *gorm.DB) {
-	ctx := context.Background()
+	// Every statement on a tenant's tables needs the tenant in its context
+	ctx := tenant.WithID(context.Background(), tenant.Default)
 
 	// Create a post with tags in a single operation
 	post := Post{
@@ -30,7 +33,7 @@ func AssociationOperations(db *gorm.DB) {
 
 	// Append new tags to an existing post
 	var existingPost Post
-	db.First(&existingPost, 1)
+	db.WithContext(ctx).First(&existingPost, 1)
 
 	newTag := Tag{Name: "tutorial", Slug: "tutorial"}
 	db.WithContext(ctx).Model(&existingPost).Association("Tags").Append(&newTag)
@@ -43,7 +46,7 @@ func AssociationOperations(db *gorm.DB) {
 
 	// Remove a specific tag from a post
 	var tagToRemove Tag
-	db.Where("slug = ?", "go").First(&tagToRemove)
+	db.WithContext(ctx).Where("slug = ?", "go").First(&tagToRemove)
 	db.WithContext(ctx).Model(&existingPost).Association("Tags").Delete(&tagToRemove)
 
 	// Clear all tags from a post
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm-reference/internal/db/datatypes"
+)
+
+// =================================================================
+// Audit Log
+// Append-only entries recording what was done to or for a user.
+// =================================================================
+
+// Audit actions
+const (
+	AuditUserExported = "user.exported"
+	AuditUserErased   = "user.erased"
+)
+
+// AuditLog is one entry of the audit log. Entries outlive the users they
+// concern, so UserID is not a foreign key, and they never hold personal
+// data: Details only carries identifiers such as the data request.
+type AuditLog struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	TenantScoped
+	CreatedAt time.Time `json:"createdAt"`
+
+	// The user the entry concerns
+	UserID uint   `gorm:"not null;index" json:"userId"`
+	Action string `gorm:"type:varchar(100);not null" json:"action"`
+
+	Details datatypes.JSONB[map[string]any] `gorm:"type:jsonb" json:"details"`
+}
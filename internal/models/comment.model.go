@@ -5,6 +5,7 @@ import "gorm.io/gorm"
 // Comment belongs to both User and Post
 type Comment struct {
 	gorm.Model
+	TenantScoped
 	Content string `gorm:"type:text;not null" json:"content"`
 
 	// Foreign keys
@@ -0,0 +1,31 @@
+package models
+
+import "gorm-reference/internal/apperror"
+
+// MaxImportErrors is the number of failed rows an ImportReport describes.
+// Failed still counts them all.
+const MaxImportErrors = 100
+
+// ImportReport is the outcome of a bulk import of users
+type ImportReport struct {
+	// Rows is the number of rows read, Imported of those created or updated
+	Rows     int `json:"rows"`
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+
+	// Errors holds why the first MaxImportErrors failed rows failed, in the
+	// order read. ErrorsTruncated is set when more rows failed.
+	Errors          []ImportError `json:"errors"`
+	ErrorsTruncated bool          `json:"errorsTruncated"`
+}
+
+// ImportError is why a row of an import failed. Its members follow the
+// problem details of failed requests.
+type ImportError struct {
+	// Row is the number of the row, see bulk.Decoder.Row
+	Row    int                   `json:"row"`
+	Email  string                `json:"email,omitempty"`
+	Code   apperror.Code         `json:"code"`
+	Detail string                `json:"detail"`
+	Errors []apperror.FieldError `json:"errors,omitempty"`
+}
@@ -8,3 +8,17 @@ type ListOptions struct {
 	Order    string // "asc" or "desc"
 	Filters  map[string]any
 }
+
+// All returns every model that is backed by its own table. Join tables are
+// reached through the many-to-many associations of these models.
+func All() []any {
+	return []any{
+		&User{},
+		&Profile{},
+		&Post{},
+		&Comment{},
+		&Tag{},
+		&AuditLog{},
+		&DataRequest{},
+	}
+}
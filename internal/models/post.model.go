@@ -3,6 +3,9 @@ package models
 import (
 	"time"
 
+	"gorm-reference/internal/db/cascade"
+	"gorm-reference/internal/db/indexes"
+
 	"gorm.io/gorm"
 )
 
@@ -14,6 +17,7 @@ import (
 // Post belongs to a User (many-to-one relationship)
 type Post struct {
 	gorm.Model
+	TenantScoped
 	Title   string `gorm:"type:varchar(255);not null" json:"title"`
 	Content string `gorm:"type:text" json:"content"`
 
@@ -29,6 +33,31 @@ type Post struct {
 	// Many-to-many with Tags
 	// GORM automatically creates the join table 'post_tags'
 	Tags []Tag `gorm:"many2many:post_tags;"`
+
+	// Has Many relationship
+	Comments []Comment `gorm:"foreignKey:PostID" json:"comments"`
+}
+
+// OnDelete declares what deleting a post does to its associations: its
+// comments go with it, and hard deletes remove its tag links
+func (Post) OnDelete() map[string]cascade.Policy {
+	return map[string]cascade.Policy{
+		"Comments": cascade.Cascade,
+		"Tags":     cascade.Cascade,
+	}
+}
+
+// Indexes declares the indexes struct tags can't express
+func (Post) Indexes() []indexes.Index {
+	return []indexes.Index{
+		// GIN index for full-text search over title and content
+		{
+			Name:       "idx_posts_search",
+			Columns:    []string{"to_tsvector('english', title || ' ' || coalesce(content, ''))"},
+			Using:      "gin",
+			Concurrent: true,
+		},
+	}
 }
 
 type PostSummary struct {
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// =================================================================
+// User Preferences
+// Each preference key has its own schema, checked on every write.
+// =================================================================
+
+// Preferences are the settings of a user, keyed by preference name
+type Preferences map[string]any
+
+// Validate validates every preference
+func (p Preferences) Validate() error {
+	return ValidatePreferences(p)
+}
+
+// preferenceRules holds the rules the value of each known preference must
+// satisfy. Keys that are not listed can't be set.
+var preferenceRules = map[string][]validation.Rule{
+	"theme":    {isString, validation.Required, validation.In("light", "dark", "system")},
+	"language": {isString, validation.Required, validation.Match(regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`))},
+	"timezone": {isString, validation.By(isTimezone)},
+	"pageSize": {isInteger(10, 100)},
+	"notifications": {isObject(map[string][]validation.Rule{
+		"email":  {isBool},
+		"push":   {isBool},
+		"digest": {isString, validation.Required, validation.In("never", "daily", "weekly")},
+	})},
+}
+
+// ValidatePreference validates the value of a single preference
+func ValidatePreference(key string, value any) error {
+	rules, ok := preferenceRules[key]
+	if !ok {
+		return errors.New("unknown preference")
+	}
+	if value == nil {
+		return errors.New("cannot be null")
+	}
+	return validation.Validate(value, rules...)
+}
+
+// ValidatePreferences validates every preference in prefs. Null values are
+// accepted, since merges use them to remove preferences.
+func ValidatePreferences(prefs map[string]any) error {
+	errs := validation.Errors{}
+	for key, value := range prefs {
+		if value == nil {
+			if _, ok := preferenceRules[key]; !ok {
+				errs[key] = errors.New("unknown preference")
+			}
+			continue
+		}
+		if err := ValidatePreference(key, value); err != nil {
+			errs[key] = err
+		}
+	}
+	return errs.Filter()
+}
+
+var (
+	isString = validation.By(func(value any) error {
+		if _, ok := value.(string); !ok {
+			return errors.New("must be a string")
+		}
+		return nil
+	})
+
+	isBool = validation.By(func(value any) error {
+		if _, ok := value.(bool); !ok {
+			return errors.New("must be true or false")
+		}
+		return nil
+	})
+)
+
+// isInteger accepts whole numbers between min and max, as decoded from JSON
+func isInteger(min, max int64) validation.Rule {
+	return validation.By(func(value any) error {
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				return errors.New("must be a number")
+			}
+			n = f
+		case int:
+			n = float64(v)
+		case int64:
+			n = float64(v)
+		default:
+			return errors.New("must be a number")
+		}
+		if n != math.Trunc(n) || n < float64(min) || n > float64(max) {
+			return fmt.Errorf("must be a whole number between %d and %d", min, max)
+		}
+		return nil
+	})
+}
+
+// isObject accepts objects whose members satisfy their rules. Members may be
+// left out, so partial objects can be merged into stored ones.
+func isObject(members map[string][]validation.Rule) validation.Rule {
+	return validation.By(func(value any) error {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return errors.New("must be an object")
+		}
+
+		errs := validation.Errors{}
+		for key, member := range object {
+			rules, ok := members[key]
+			switch {
+			case !ok:
+				errs[key] = errors.New("unknown field")
+			case member != nil:
+				if err := validation.Validate(member, rules...); err != nil {
+					errs[key] = err
+				}
+			}
+		}
+		return errs.Filter()
+	})
+}
+
+func isTimezone(value any) error {
+	name, _ := value.(string)
+	if _, err := time.LoadLocation(name); err != nil || name == "" || name == "Local" {
+		return errors.New("must be an IANA time zone such as Europe/Paris")
+	}
+	return nil
+}
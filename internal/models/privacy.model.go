@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// =================================================================
+// Data Subject Requests
+// Exports and erasures of everything tied to a user.
+// =================================================================
+
+// Kinds of data requests
+const (
+	DataRequestExport  = "export"
+	DataRequestErasure = "erasure"
+)
+
+// Statuses of data requests
+const (
+	DataRequestPending   = "pending"
+	DataRequestCompleted = "completed"
+	DataRequestFailed    = "failed"
+)
+
+// DataRequest records a data subject request and its outcome. Like audit
+// entries, requests are kept after the user is erased or purged.
+type DataRequest struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	TenantScoped
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	UserID uint   `gorm:"not null;index" json:"userId"`
+	Kind   string `gorm:"type:varchar(20);not null" json:"kind"`
+	Status string `gorm:"type:varchar(20);not null" json:"status"`
+
+	// Error holds why a failed request failed
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CompletedAt *time.Time `json:"completedAt"`
+}
+
+// UserData is everything stored about a user, soft-deleted records
+// included, as exported for a data request
+type UserData struct {
+	ExportedAt time.Time `json:"exportedAt"`
+	RequestID  uint      `json:"requestId"`
+
+	User User `json:"user"`
+
+	// The live profile and the deleted ones
+	Profiles []Profile `json:"profiles"`
+	Posts    []Post    `json:"posts"`
+	Comments []Comment `json:"comments"`
+
+	// The tags the user added to posts, theirs or not
+	TagsAdded []PostTag     `json:"tagsAdded"`
+	AuditLog  []AuditLog    `json:"auditLog"`
+	Requests  []DataRequest `json:"requests"`
+}
+
+// ErasedEmail is the email an erasure leaves a user with. Emails are unique
+// and required, so it is derived from the ID.
+func ErasedEmail(id uint) string {
+	return ErasedUsername(id) + "@deleted.invalid"
+}
+
+// ErasedUsername is the username an erasure leaves a user with
+func ErasedUsername(id uint) string {
+	return "erased-" + strconv.FormatUint(uint64(id), 10)
+}
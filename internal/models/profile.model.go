@@ -1,6 +1,14 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"errors"
+
+	"gorm-reference/internal/db/datatypes"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"gorm.io/gorm"
+)
 
 // =======================================================================================
 // Has One Relationship
@@ -10,15 +18,37 @@ import "gorm.io/gorm"
 // Profile has a one-to-one relationship with User
 type Profile struct {
 	gorm.Model
+	TenantScoped
 
 	// Foreign key to User
-	UserID uint `gorm:"uniqueIndex;not null" json:"userId"` // uniqueIndex ensures one profile per user
+	// The unique index ensures one live profile per user
+	UserID uint `gorm:"uniqueIndex:idx_profiles_user_id,where:deleted_at IS NULL;not null" json:"userId"`
 
 	Bio       string `gorm:"type:text" json:"bio"`
 	AvatarURL string `gorm:"type:varchar(500)" json:"avatarURL"`
 	Website   string `gorm:"type:varchar(255)" json:"website"`
-	Location  string `gorm:"type:varchar(100)" json:"location"`
+
+	// Encrypted, see package encryption
+	Location string `gorm:"type:text;serializer:encrypted" json:"location"`
 
 	// Social links stored as JSON
-	SocialLinks map[string]string `gorm:"type:jsonb" json:"socialLinks"`
+	SocialLinks datatypes.JSONB[SocialLinks] `gorm:"type:jsonb" json:"socialLinks"`
+}
+
+// SocialLinks maps a network name, e.g. github, to a profile URL
+type SocialLinks map[string]string
+
+// Validate checks that every link names its network and is a URL
+func (l SocialLinks) Validate() error {
+	errs := validation.Errors{}
+	for network, link := range l {
+		if network == "" || len(network) > 50 {
+			errs[network] = errors.New("network names must be 1 to 50 characters")
+			continue
+		}
+		if err := validation.Validate(link, validation.Required, is.URL); err != nil {
+			errs[network] = err
+		}
+	}
+	return errs.Filter()
 }
@@ -3,6 +3,8 @@ package models
 import (
 	"time"
 
+	"gorm-reference/internal/db/indexes"
+
 	"gorm.io/gorm"
 )
 
@@ -14,19 +16,31 @@ import (
 // Tag can be associated with many Posts and vice versa
 type Tag struct {
 	gorm.Model
-	Name string `gorm:"type:varchar(50);uniqueIndex;not null" json:"name"`
-	Slug string `gorm:"type:varchar(50);uniqueIndex;not null" json:"slug"`
+	TenantScoped
+
+	// Names and slugs are unique per tenant, see Indexes
+	Name string `gorm:"type:varchar(50);not null" json:"name"`
+	Slug string `gorm:"type:varchar(50);not null" json:"slug"`
 
 	// Many-to-many with Posts
 	Posts []Post `gorm:"many2many:post_tags;" json:"posts"`
 }
 
+// Indexes declares the per-tenant unique indexes of the tags table. They
+// only cover live tags, so a deleted tag's name can be used again.
+func (Tag) Indexes() []indexes.Index {
+	return []indexes.Index{
+		{Name: "idx_tags_tenant_name", Columns: []string{"tenant_id", "name"}, Unique: true, Where: "deleted_at IS NULL"},
+		{Name: "idx_tags_tenant_slug", Columns: []string{"tenant_id", "slug"}, Unique: true, Where: "deleted_at IS NULL"},
+	}
+}
+
 // PostTag custom join table with additional fields
 type PostTag struct {
 	PostID    uint      `gorm:"primaryKey" json:"postId"`
 	TagID     uint      `gorm:"primaryKey" json:"tagId"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"createdAt"`
-	AddedBy   uint      // Who added this tag
+	AddedBy   uint      `json:"addedBy"` // Who added this tag
 }
 
 // SetupJoinTable configures the custom join table
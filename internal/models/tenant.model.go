@@ -0,0 +1,13 @@
+package models
+
+// =================================================================
+// Multi-Tenancy
+// Every row belongs to one tenant; the tenant plugin keeps them apart.
+// =================================================================
+
+// TenantScoped is embedded by every model whose rows belong to a tenant. The
+// tenant plugin fills TenantID in on create and filters every statement by
+// it, so it is never read from or written to JSON.
+type TenantScoped struct {
+	TenantID string `gorm:"type:varchar(63);not null;index" json:"-"`
+}
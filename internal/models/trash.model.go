@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// TrashItem is a soft-deleted record, as listed by the trash
+type TrashItem struct {
+	// Entity is the table of the record, e.g. users
+	Entity    string    `json:"entity"`
+	ID        uint      `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+	Record    any       `json:"record"`
+}
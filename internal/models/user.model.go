@@ -2,30 +2,44 @@
 package models
 
 import (
+	"errors"
+	"maps"
 	"time"
 
+	"gorm-reference/internal/db/cascade"
+	"gorm-reference/internal/db/datatypes"
+	"gorm-reference/internal/db/encryption"
+	"gorm-reference/internal/db/indexes"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // User represents the users table with common fields
 type User struct {
 	// Embed gorm.Model for ID, CreatedAt, UpdatedAt, DeletedAt
 	gorm.Model
+	TenantScoped
+	Versioned
 
-	// Use index tag for better query performance
-	FirstName *string `gorm:"type:varchar(100);index" json:"firstName"`
-	LastName  *string `gorm:"type:varchar(100);index" json:"lastName"`
+	// Names and emails are encrypted, see package encryption
+	FirstName *string `gorm:"type:text;serializer:encrypted" json:"firstName"`
+	LastName  *string `gorm:"type:text;serializer:encrypted" json:"lastName"`
 
-	// Use column tag to customize the database column name
-	Email *string `gorm:"column:email;type:varchar(255);uniqueIndex;not null" json:"email"`
+	// Use column tag to customize the database column name. Emails are
+	// looked up by their blind index, EmailIndex; emails and usernames are
+	// unique per tenant, see Indexes.
+	Email      *string `gorm:"column:email;type:text;not null;serializer:encrypted;blindIndex:email_index" json:"email"`
+	EmailIndex *string `gorm:"type:varchar(64)" json:"-"`
 
 	// Add size constraint directly in the type
-	Username *string `gorm:"type:varchar(100);uniqueIndex;not null" json:"username"`
+	Username *string `gorm:"type:varchar(100);not null" json:"username"`
 
-	// Password hash should never be selected by default
-	PasswordHash string `gorm:"type:varchar(255);not null;->:false;<-" json:"password"`
+	// Password hash should never be selected by default, nor serialized.
+	// Clients send passwords, see NewUser.
+	PasswordHash string `gorm:"type:varchar(255);not null;->:false;<-" json:"-"`
 
 	// Boolean field with default value
 	IsActive bool `gorm:"default:true" json:"isActive"`
@@ -33,8 +47,9 @@ type User struct {
 	// Timestamp fields with auto-update
 	LastLoginAt *time.Time `gorm:"index" json:"lastLoginAt"`
 
-	// JSON field for flexible data storage
-	Preferences map[string]any `gorm:"type:jsonb" json:"preferences"`
+	// JSON field for flexible data storage. Each key has a schema of its
+	// own, see preferenceRules.
+	Preferences datatypes.JSONB[Preferences] `gorm:"type:jsonb" json:"preferences"`
 
 	// Has Many relationships
 	Posts    []Post    `gorm:"foreignKey:UserID" json:"posts"`
@@ -49,6 +64,114 @@ func (User) TableName() string {
 	return "users"
 }
 
+// Indexes declares the indexes struct tags can't express
+func (User) Indexes() []indexes.Index {
+	return []indexes.Index{
+		// Emails and usernames only need to be unique within a tenant, and
+		// among live users so that deleted ones can be registered again.
+		// Emails are compared through their blind index.
+		{Name: "idx_users_tenant_email", Columns: []string{"tenant_id", "email_index"}, Unique: true, Where: "deleted_at IS NULL"},
+		{Name: "idx_users_tenant_username", Columns: []string{"tenant_id", "username"}, Unique: true, Where: "deleted_at IS NULL"},
+
+		// Partial index covering only the rows sign-in looks up
+		{
+			Name:       "idx_active_users",
+			Columns:    []string{"email_index"},
+			Where:      "is_active = true AND deleted_at IS NULL",
+			Concurrent: true,
+		},
+
+		// GIN index for preference lookups with the containment operator @>
+		{
+			Name:       "idx_users_preferences",
+			Columns:    []string{"preferences jsonb_path_ops"},
+			Using:      "gin",
+			Concurrent: true,
+		},
+	}
+}
+
+// OnDelete declares what deleting a user does to its associations: its
+// profile, posts and comments go with it. DB_CASCADE overrides these, e.g.
+// to hand comments over to the placeholder user instead.
+func (User) OnDelete() map[string]cascade.Policy {
+	return map[string]cascade.Policy{
+		"Profile":  cascade.Cascade,
+		"Posts":    cascade.Cascade,
+		"Comments": cascade.Cascade,
+	}
+}
+
+// PlaceholderUsername names the user that takes over the records of deleted
+// users whose associations are anonymized
+const PlaceholderUsername = "deleted-user"
+
+// Placeholder returns the ID of the tenant's placeholder user, creating it on
+// first use. It can't log in: no password hashes to its password hash.
+func (User) Placeholder(tx *gorm.DB) (any, error) {
+	email := PlaceholderUsername + "@deleted.invalid"
+	username := PlaceholderUsername
+	placeholder := User{Email: &email, Username: &username, PasswordHash: "!", IsActive: false}
+
+	// Concurrent deletes may both create it; the second one finds the first's
+	err := tx.Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "tenant_id"}, {Name: "email_index"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "deleted_at IS NULL"}}},
+		DoNothing:   true,
+	}).
+		Select("TenantID", "Email", "Username", "PasswordHash", "IsActive", "CreatedAt", "UpdatedAt").
+		Create(&placeholder).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	if err := tx.Model(&User{}).Where("email_index = ?", encryption.BlindIndex(email)).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("placeholder user not found after creating it")
+	}
+	return ids[0], nil
+}
+
+// NewUser is a user to create as clients send it: with a password, which is
+// only ever stored hashed, in place of the password hash
+type NewUser struct {
+	FirstName   *string                      `json:"firstName"`
+	LastName    *string                      `json:"lastName"`
+	Email       *string                      `json:"email"`
+	Username    *string                      `json:"username"`
+	Password    string                       `json:"password"`
+	Preferences datatypes.JSONB[Preferences] `json:"preferences"`
+}
+
+// User returns the user to create, without a password hash
+func (n NewUser) User() User {
+	return User{
+		FirstName:   n.FirstName,
+		LastName:    n.LastName,
+		Email:       n.Email,
+		Username:    n.Username,
+		Preferences: n.Preferences,
+	}
+}
+
+// Validate validates the user's fields and the password. bcrypt hashes at
+// most 72 bytes.
+func (n NewUser) Validate() error {
+	errs := validation.Errors{}
+	if err := n.User().Validate(); err != nil {
+		fields, ok := err.(validation.Errors)
+		if !ok {
+			return err
+		}
+		maps.Copy(errs, fields)
+	}
+	errs["password"] = validation.Validate(n.Password, validation.Required, validation.Length(6, 72))
+	return errs.Filter()
+}
+
 // UserFilters contains optional filters for querying users
 type UserFilters struct {
 	IsActive     *bool
@@ -56,12 +179,65 @@ type UserFilters struct {
 	CreatedAfter time.Time
 }
 
+// UserPatchColumns maps the JSON fields of a user that a patch may change to
+// their columns
+var UserPatchColumns = map[string]string{
+	"firstName":   "first_name",
+	"lastName":    "last_name",
+	"email":       "email",
+	"username":    "username",
+	"isActive":    "is_active",
+	"preferences": "preferences",
+}
+
+// UserProtectedFields are the JSON fields of a user that no patch may change:
+// keys, timestamps the database keeps, and the password, which has a flow of
+// its own
+var UserProtectedFields = map[string]bool{
+	"ID":          true,
+	"CreatedAt":   true,
+	"UpdatedAt":   true,
+	"DeletedAt":   true,
+	"password":    true,
+	"lastLoginAt": true,
+}
+
 func (u User) Validate() error {
 	return validation.ValidateStruct(&u,
 		validation.Field(&u.FirstName, validation.Required, validation.Length(1, 100)),
 		validation.Field(&u.LastName, validation.Required, validation.Length(1, 100)),
 		validation.Field(&u.Username, validation.Required, validation.Length(3, 100)),
 		validation.Field(&u.Email, validation.Required, is.Email),
-		validation.Field(&u.PasswordHash, validation.Required, validation.Length(6, 0)),
 	)
 }
+
+// ValidateUpdate validates the fields set in a partial update. Fields left
+// out keep their stored values, so none is required.
+func (u User) ValidateUpdate() error {
+	return validation.ValidateStruct(&u,
+		validation.Field(&u.FirstName, validation.NilOrNotEmpty, validation.Length(1, 100)),
+		validation.Field(&u.LastName, validation.NilOrNotEmpty, validation.Length(1, 100)),
+		validation.Field(&u.Username, validation.NilOrNotEmpty, validation.Length(3, 100)),
+		validation.Field(&u.Email, validation.NilOrNotEmpty, is.Email),
+	)
+}
+
+// ValidatePatch validates the given JSON fields of a patched user. Names and
+// emails may be cleared; the email and username columns may not.
+func (u User) ValidatePatch(fields []string) error {
+	rules := map[string]*validation.FieldRules{
+		"firstName":   validation.Field(&u.FirstName, validation.NilOrNotEmpty, validation.Length(1, 100)),
+		"lastName":    validation.Field(&u.LastName, validation.NilOrNotEmpty, validation.Length(1, 100)),
+		"username":    validation.Field(&u.Username, validation.Required, validation.Length(3, 100)),
+		"email":       validation.Field(&u.Email, validation.Required, is.Email),
+		"preferences": validation.Field(&u.Preferences),
+	}
+
+	var selected []*validation.FieldRules
+	for _, field := range fields {
+		if r, ok := rules[field]; ok {
+			selected = append(selected, r)
+		}
+	}
+	return validation.ValidateStruct(&u, selected...)
+}
@@ -0,0 +1,13 @@
+package models
+
+// =================================================================
+// Optimistic Locking
+// Updates check the version they were read at; see internal/db/locking.
+// =================================================================
+
+// Versioned is embedded by models whose updates are optimistically locked.
+// Every update increments Version, and an update made from a record that
+// carries one fails with a conflict if the row moved on since it was read.
+type Versioned struct {
+	Version int64 `gorm:"not null;default:1" json:"version"`
+}
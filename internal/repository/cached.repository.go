@@ -0,0 +1,329 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/cache"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/db/encryption"
+	"gorm-reference/internal/models"
+	"gorm-reference/internal/tenant"
+)
+
+// CacheTTLs is how long each kind of entity stays cached. Zero disables
+// caching for that entity.
+type CacheTTLs struct {
+	User time.Duration
+	Post time.Duration
+}
+
+// WithCache returns r with its user and post reads served cache-aside from c.
+// Writes through the returned repository invalidate what they change; writes
+// made any other way are seen once the entries they affect expire.
+func WithCache(r *Repository, c *cache.Cache, ttls CacheTTLs) *Repository {
+	cached := *r
+	cached.User = &cachedUserRepository{UserRepository: r.User, cache: c, ttl: ttls.User}
+	cached.Post = &cachedPostRepository{PostRepository: r.Post, cache: c, ttl: ttls.Post}
+	cached.Trash = &cachedTrashRepository{TrashRepository: r.Trash, users: cached.User.(*cachedUserRepository)}
+	cached.Privacy = &cachedPrivacyRepository{PrivacyRepository: r.Privacy, users: cached.User.(*cachedUserRepository)}
+	return &cached
+}
+
+// cacheScope returns the prefix of the cache keys of ctx's tenant. Unscoped
+// contexts read every tenant's rows, so they bypass the cache.
+func cacheScope(ctx context.Context) (string, bool) {
+	if tenant.Unscoped(ctx) {
+		return "", false
+	}
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return tenantScope(id), true
+}
+
+func tenantScope(id string) string {
+	return "tenant:" + id + ":"
+}
+
+// postsGeneration names the generation of every cached post listing of a
+// tenant. Posts embed their author, so user writes invalidate it too.
+func postsGeneration(scope string) string {
+	return scope + "posts"
+}
+
+// cachedUserRepository caches users by ID, and the ID of each email looked up
+type cachedUserRepository struct {
+	UserRepository
+	cache *cache.Cache
+	ttl   time.Duration
+}
+
+func (r *cachedUserRepository) idKey(scope string, id uint) string {
+	return fmt.Sprintf("%suser:id:%d", scope, id)
+}
+
+// emailKey names the email by its blind index, so that keys don't give
+// emails away
+func (r *cachedUserRepository) emailKey(scope, email string) string {
+	return scope + "user:email:" + encryption.BlindIndex(email)
+}
+
+// FindByID implements UserRepository. Misses are read from the primary, so
+// that a lagging replica never fills the cache with a row just overwritten.
+func (r *cachedUserRepository) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	scope, ok := cacheScope(ctx)
+	if !ok {
+		return r.UserRepository.FindByID(ctx, id)
+	}
+	user, err := cache.Load(ctx, r.cache, r.idKey(scope, id), r.ttl, func(ctx context.Context) (*models.User, error) {
+		return r.UserRepository.FindByID(db.ReadPrimary(ctx), id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The tenant is not part of the encoded user
+	user.TenantID, _ = tenant.FromContext(ctx)
+	return user, nil
+}
+
+// FindByEmail implements UserRepository. Only the user's ID is cached under
+// its email, so that invalidating the user by ID is enough.
+func (r *cachedUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	scope, ok := cacheScope(ctx)
+	if !ok {
+		return r.UserRepository.FindByEmail(ctx, email)
+	}
+
+	key := r.emailKey(scope, email)
+	id, err := cache.Load(ctx, r.cache, key, r.ttl, func(ctx context.Context) (uint, error) {
+		user, err := r.UserRepository.FindByEmail(db.ReadPrimary(ctx), email)
+		if err != nil {
+			return 0, err
+		}
+		return user.ID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := r.FindByID(ctx, id)
+	if err == nil && user.Email != nil && *user.Email == email {
+		return user, nil
+	}
+	if err != nil && !errors.Is(err, apperror.ErrNotFound) {
+		return nil, err
+	}
+
+	// The user changed their email or was deleted since the ID was cached
+	r.cache.Delete(ctx, key)
+	return r.UserRepository.FindByEmail(ctx, email)
+}
+
+// Create implements UserRepository
+func (r *cachedUserRepository) Create(ctx context.Context, user *models.User) error {
+	if err := r.UserRepository.Create(ctx, user); err != nil {
+		return err
+	}
+	r.invalidate(ctx, user.TenantID, user.ID)
+	return nil
+}
+
+// Upsert implements UserRepository
+func (r *cachedUserRepository) Upsert(ctx context.Context, user *models.User) error {
+	if err := r.UserRepository.Upsert(ctx, user); err != nil {
+		return err
+	}
+	r.invalidate(ctx, user.TenantID, user.ID)
+	return nil
+}
+
+// Update implements UserRepository
+func (r *cachedUserRepository) Update(ctx context.Context, id uint, updates models.User) error {
+	if err := r.UserRepository.Update(ctx, id, updates); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// UpdateFields implements UserRepository
+func (r *cachedUserRepository) UpdateFields(ctx context.Context, id uint, updates models.User, columns []string) error {
+	if err := r.UserRepository.UpdateFields(ctx, id, updates, columns); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// Save implements UserRepository
+func (r *cachedUserRepository) Save(ctx context.Context, user *models.User) error {
+	if err := r.UserRepository.Save(ctx, user); err != nil {
+		return err
+	}
+	r.invalidate(ctx, user.TenantID, user.ID)
+	return nil
+}
+
+// UpdateLastLogin implements UserRepository
+func (r *cachedUserRepository) UpdateLastLogin(ctx context.Context, id uint) error {
+	if err := r.UserRepository.UpdateLastLogin(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// IncreaseLoginCount implements UserRepository
+func (r *cachedUserRepository) IncreaseLoginCount(ctx context.Context, id uint) error {
+	if err := r.UserRepository.IncreaseLoginCount(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// SetPreference implements UserRepository
+func (r *cachedUserRepository) SetPreference(ctx context.Context, id uint, key string, value any) error {
+	if err := r.UserRepository.SetPreference(ctx, id, key, value); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// DeletePreference implements UserRepository
+func (r *cachedUserRepository) DeletePreference(ctx context.Context, id uint, key string) error {
+	if err := r.UserRepository.DeletePreference(ctx, id, key); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// MergePreferences implements UserRepository
+func (r *cachedUserRepository) MergePreferences(ctx context.Context, id uint, patch map[string]any) error {
+	if err := r.UserRepository.MergePreferences(ctx, id, patch); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// Delete implements UserRepository
+func (r *cachedUserRepository) Delete(ctx context.Context, id uint) error {
+	if err := r.UserRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// HardDelete implements UserRepository
+func (r *cachedUserRepository) HardDelete(ctx context.Context, id uint) error {
+	if err := r.UserRepository.HardDelete(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// Restore implements UserRepository
+func (r *cachedUserRepository) Restore(ctx context.Context, id uint) error {
+	if err := r.UserRepository.Restore(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, "", id)
+	return nil
+}
+
+// invalidate drops the cached user id and the post listings embedding it.
+// The tenant comes from ctx, or from the record for unscoped contexts; an
+// unscoped write by ID alone can't name its tenant and relies on the TTL.
+func (r *cachedUserRepository) invalidate(ctx context.Context, tenantID string, id uint) {
+	scope, ok := cacheScope(ctx)
+	if !ok {
+		if tenantID == "" {
+			return
+		}
+		scope = tenantScope(tenantID)
+	}
+	r.cache.Delete(ctx, r.idKey(scope, id))
+	r.cache.Invalidate(ctx, postsGeneration(scope))
+}
+
+// cachedPostRepository caches pages of FindPostsWithDetails under the
+// tenant's posts generation
+type cachedPostRepository struct {
+	PostRepository
+	cache *cache.Cache
+	ttl   time.Duration
+}
+
+// FindPostsWithDetails implements PostRepository
+func (r *cachedPostRepository) FindPostsWithDetails(ctx context.Context, page, pageSize int) ([]models.Post, error) {
+	scope, ok := cacheScope(ctx)
+	if !ok || r.ttl <= 0 {
+		return r.PostRepository.FindPostsWithDetails(ctx, page, pageSize)
+	}
+	gen := r.cache.Generation(ctx, postsGeneration(scope))
+	key := fmt.Sprintf("%sposts:%s:details:%d:%d", scope, gen, page, pageSize)
+	return cache.Load(ctx, r.cache, key, r.ttl, func(ctx context.Context) ([]models.Post, error) {
+		return r.PostRepository.FindPostsWithDetails(db.ReadPrimary(ctx), page, pageSize)
+	})
+}
+
+// cachedTrashRepository invalidates what restores and purges bring back or
+// remove. Every entity in the trash can appear in post listings.
+type cachedTrashRepository struct {
+	TrashRepository
+	users *cachedUserRepository
+}
+
+// Restore implements TrashRepository
+func (r *cachedTrashRepository) Restore(ctx context.Context, entity string, id uint) error {
+	if err := r.TrashRepository.Restore(ctx, entity, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, entity, id)
+	return nil
+}
+
+// Purge implements TrashRepository
+func (r *cachedTrashRepository) Purge(ctx context.Context, entity string, id uint) error {
+	if err := r.TrashRepository.Purge(ctx, entity, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, entity, id)
+	return nil
+}
+
+func (r *cachedTrashRepository) invalidate(ctx context.Context, entity string, id uint) {
+	if entity == "users" {
+		r.users.invalidate(ctx, "", id)
+		return
+	}
+	if scope, ok := cacheScope(ctx); ok {
+		r.users.cache.Invalidate(ctx, postsGeneration(scope))
+	}
+}
+
+// cachedPrivacyRepository invalidates the users erasures anonymize
+type cachedPrivacyRepository struct {
+	PrivacyRepository
+	users *cachedUserRepository
+}
+
+// Erase implements PrivacyRepository
+func (r *cachedPrivacyRepository) Erase(ctx context.Context, userID uint, entry *models.AuditLog) error {
+	if err := r.PrivacyRepository.Erase(ctx, userID, entry); err != nil {
+		return err
+	}
+	r.users.invalidate(ctx, entry.TenantID, userID)
+	return nil
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"context"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/db/encryption"
+	"gorm-reference/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var _ EncryptionRepository = (*encryptionRepository)(nil)
+
+// EncryptionRepository maintains the encrypted columns of every model
+type EncryptionRepository interface {
+	Reencrypt(ctx context.Context, batchSize int) (int64, error)
+}
+
+type encryptionRepository struct {
+	db *gorm.DB
+}
+
+// Reencrypt moves the encrypted columns of every model to the primary key
+// and fills in missing blind indexes, see encryption.Reencrypt. It returns
+// how many rows it rewrote.
+func (e *encryptionRepository) Reencrypt(ctx context.Context, batchSize int) (int64, error) {
+	rewritten, err := encryption.Reencrypt(ctx, e.db, batchSize, models.All()...)
+	return rewritten, db.AppError(err, apperror.ErrNotFound)
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"context"
 
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/db/encryption"
 	"gorm-reference/internal/models"
 
 	"gorm.io/gorm"
@@ -22,6 +25,11 @@ type postRepository struct {
 	db *gorm.DB
 }
 
+// postError converts database errors into the shared error taxonomy
+func postError(err error) error {
+	return db.AppError(err, apperror.ErrPostNotFound)
+}
+
 // ===================================================================================
 // Query Optimization
 // Eager Loading with Preload
@@ -45,7 +53,7 @@ func (r *postRepository) FindPostsWithDetails(ctx context.Context, page, pageSiz
 		Limit(pageSize).
 		Find(&posts)
 
-	return posts, result.Error
+	return posts, postError(result.Error)
 }
 
 // Conditional Preload loads associations only when certain conditions are met
@@ -59,7 +67,7 @@ func (r *postRepository) FindPostsWithActiveComments(ctx context.Context) ([]mod
 		}).
 		Find(&posts)
 
-	return posts, result.Error
+	return posts, postError(result.Error)
 }
 
 // =============================================================
@@ -74,10 +82,10 @@ func (r *postRepository) FindPostsByUserEmail(ctx context.Context, email string)
 	// Joins is more efficient when filtering by associated table columns
 	result := r.db.WithContext(ctx).
 		Joins("JOIN users ON users.id = posts.user_id").
-		Where("users.email = ?", email).
+		Where("users.email_index = ?", encryption.BlindIndex(email)).
 		Find(&posts)
 
-	return posts, result.Error
+	return posts, postError(result.Error)
 }
 
 // FindPostsWithUserData loads posts with user data in a single query
@@ -89,7 +97,7 @@ func (r *postRepository) FindPostsWithUserData(ctx context.Context) ([]models.Po
 		Joins("User"). // Smart join that populates the User field
 		Find(&posts)
 
-	return posts, result.Error
+	return posts, postError(result.Error)
 }
 
 // ComplexJoinQuery demonstrates multi-table joins
@@ -104,7 +112,7 @@ func (r *postRepository) FindPopularPosts(ctx context.Context, minComments int)
 		Order("comment_count DESC").
 		Find(&posts)
 
-	return posts, result.Error
+	return posts, postError(result.Error)
 }
 
 // ===========================================================================
@@ -122,12 +130,23 @@ func (r *postRepository) FindPostSummaries(ctx context.Context) ([]models.PostSu
 		Joins("JOIN users ON users.id = posts.user_id").
 		Scan(&summaries)
 
-	return summaries, result.Error
+	return summaries, postError(result.Error)
 }
 
-// Pluck extracts a single column into a slice
+// GetAllEmails selects a single column. Pluck would return the ciphertext
+// of encrypted columns, which only decrypt when scanned into their model.
 func (r *userRepository) GetAllEmails(ctx context.Context) ([]string, error) {
-	var emails []string
-	result := r.db.WithContext(ctx).Model(&models.User{}).Pluck("email", &emails)
-	return emails, result.Error
+	var users []models.User
+	result := r.db.WithContext(ctx).Select("email").Find(&users)
+	if result.Error != nil {
+		return nil, userError(result.Error)
+	}
+
+	emails := make([]string, 0, len(users))
+	for _, user := range users {
+		if user.Email != nil {
+			emails = append(emails, *user.Email)
+		}
+	}
+	return emails, nil
 }
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var _ PrivacyRepository = (*privacyRepository)(nil)
+
+// PrivacyRepository collects and erases the data of users for data subject
+// requests, and keeps the record of the requests and the audit log
+type PrivacyRepository interface {
+	Collect(ctx context.Context, userID uint) (*models.UserData, error)
+	Erase(ctx context.Context, userID uint, entry *models.AuditLog) error
+	CreateRequest(ctx context.Context, request *models.DataRequest) error
+	FinishRequest(ctx context.Context, request *models.DataRequest, failure error) error
+	FindRequests(ctx context.Context, userID uint) ([]models.DataRequest, error)
+	Audit(ctx context.Context, entry *models.AuditLog) error
+}
+
+type privacyRepository struct {
+	db *gorm.DB
+}
+
+// privacyError converts database errors into the shared error taxonomy
+func privacyError(err error) error {
+	return db.AppError(err, apperror.ErrUserNotFound)
+}
+
+// ===================================================================
+// Export and Erasure
+// Everything tied to a user, soft-deleted records included.
+// ===================================================================
+
+// Collect gathers everything stored about a user: the user, their profiles,
+// posts and comments, the tags they added to posts, their audit log and
+// their data requests. Records in the trash are included, since they are
+// still stored.
+func (p *privacyRepository) Collect(ctx context.Context, userID uint) (*models.UserData, error) {
+	db := p.db.WithContext(ctx).Unscoped()
+
+	data := &models.UserData{ExportedAt: time.Now()}
+	if err := db.First(&data.User, userID).Error; err != nil {
+		return nil, privacyError(err)
+	}
+
+	queries := []struct {
+		dest   any
+		column string
+	}{
+		{&data.Profiles, "user_id"},
+		{&data.Posts, "user_id"},
+		{&data.Comments, "user_id"},
+		{&data.TagsAdded, "added_by"},
+		{&data.AuditLog, "user_id"},
+		{&data.Requests, "user_id"},
+	}
+	for _, q := range queries {
+		if err := db.Where(map[string]any{q.column: userID}).Order("created_at").Find(q.dest).Error; err != nil {
+			return nil, privacyError(err)
+		}
+	}
+	return data, nil
+}
+
+// Erase anonymizes the personal data of a user and their profiles and
+// records entry in the audit log, in one transaction. The rows stay, so
+// everything that references them stays valid: posts and comments remain,
+// attributed to the erased user. The user can no longer sign in.
+func (p *privacyRepository) Erase(ctx context.Context, userID uint, entry *models.AuditLog) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Updates(map[string]any{
+			"first_name":    nil,
+			"last_name":     nil,
+			"email":         models.ErasedEmail(userID),
+			"username":      models.ErasedUsername(userID),
+			"password_hash": "!",
+			"is_active":     false,
+			"last_login_at": nil,
+			"preferences":   nil,
+		})
+		if result.Error != nil {
+			return privacyError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.ErrUserNotFound
+		}
+
+		err := tx.Unscoped().Model(&models.Profile{}).Where("user_id = ?", userID).Updates(map[string]any{
+			"bio":          "",
+			"avatar_url":   "",
+			"website":      "",
+			"location":     "",
+			"social_links": nil,
+		}).Error
+		if err != nil {
+			return privacyError(err)
+		}
+
+		return privacyError(tx.Create(entry).Error)
+	})
+}
+
+// ===================================================================
+// Request Record and Audit Log
+// ===================================================================
+
+// CreateRequest records a data request as pending
+func (p *privacyRepository) CreateRequest(ctx context.Context, request *models.DataRequest) error {
+	request.Status = models.DataRequestPending
+	return privacyError(p.db.WithContext(ctx).Create(request).Error)
+}
+
+// FinishRequest records the outcome of a data request: completed, or failed
+// with failure
+func (p *privacyRepository) FinishRequest(ctx context.Context, request *models.DataRequest, failure error) error {
+	now := time.Now()
+	request.Status = models.DataRequestCompleted
+	request.CompletedAt = &now
+	if failure != nil {
+		request.Status = models.DataRequestFailed
+		request.Error = failure.Error()
+	}
+
+	err := p.db.WithContext(ctx).Model(request).
+		Select("Status", "Error", "CompletedAt", "UpdatedAt").
+		Updates(request).Error
+	return privacyError(err)
+}
+
+// FindRequests returns the data requests of a user, oldest first
+func (p *privacyRepository) FindRequests(ctx context.Context, userID uint) ([]models.DataRequest, error) {
+	var requests []models.DataRequest
+	err := p.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&requests).Error
+	return requests, privacyError(err)
+}
+
+// Audit appends entry to the audit log
+func (p *privacyRepository) Audit(ctx context.Context, entry *models.AuditLog) error {
+	return privacyError(p.db.WithContext(ctx).Create(entry).Error)
+}
@@ -4,13 +4,21 @@ package repository
 import "gorm.io/gorm"
 
 type Repository struct {
-	User  UserRepository
-	Query QueryRepository
+	User       UserRepository
+	Post       PostRepository
+	Query      QueryRepository
+	Trash      TrashRepository
+	Privacy    PrivacyRepository
+	Encryption EncryptionRepository
 }
 
 func NewRepository(db *gorm.DB) *Repository {
 	return &Repository{
-		User:  &userRepository{db: db},
-		Query: &queryRepository{db: db},
+		User:       &userRepository{db: db},
+		Post:       &postRepository{db: db},
+		Query:      &queryRepository{db: db},
+		Trash:      &trashRepository{db: db},
+		Privacy:    &privacyRepository{db: db},
+		Encryption: &encryptionRepository{db: db},
 	}
 }
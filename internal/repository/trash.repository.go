@@ -0,0 +1,340 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var _ TrashRepository = (*trashRepository)(nil)
+
+// TrashRepository lists, restores and purges the soft-deleted records of
+// every entity built on gorm.Model. Entities are named by their table.
+type TrashRepository interface {
+	List(ctx context.Context, entity string, page, perPage int) ([]models.TrashItem, int64, error)
+	Restore(ctx context.Context, entity string, id uint) error
+	Purge(ctx context.Context, entity string, id uint) error
+	PurgeBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+type trashRepository struct {
+	db *gorm.DB
+}
+
+// trashError converts database errors into the shared error taxonomy
+func trashError(err error) error {
+	return db.AppError(err, apperror.ErrNotInTrash)
+}
+
+// trashEntity describes an entity whose records are soft deleted
+type trashEntity struct {
+	// model returns a pointer to a new record, records a pointer to a new
+	// slice of records
+	model   func() any
+	records func() any
+
+	// children reference the records of the entity. Restores and purges
+	// cascade to the children that are in the trash.
+	children []trashLink
+
+	// joins are the join tables referencing the records of the entity. Their
+	// rows are not soft deleted, so purges remove them.
+	joins []trashLink
+}
+
+// trashLink names the rows of table that reference a record through column
+type trashLink struct {
+	table  string
+	column string
+}
+
+// trashEntities holds every soft-deleted entity by table, and trashOrder
+// lists them parents first
+var (
+	trashOrder    = []string{"users", "posts", "comments", "profiles", "tags"}
+	trashEntities = map[string]trashEntity{
+		"users": {
+			model:    newRecord[models.User],
+			records:  newRecords[models.User],
+			children: []trashLink{{"profiles", "user_id"}, {"posts", "user_id"}, {"comments", "user_id"}},
+		},
+		"posts": {
+			model:    newRecord[models.Post],
+			records:  newRecords[models.Post],
+			children: []trashLink{{"posts", "parent_id"}, {"comments", "post_id"}},
+			joins:    []trashLink{{"post_tags", "post_id"}},
+		},
+		"comments": {
+			model:   newRecord[models.Comment],
+			records: newRecords[models.Comment],
+		},
+		"profiles": {
+			model:   newRecord[models.Profile],
+			records: newRecords[models.Profile],
+		},
+		"tags": {
+			model:   newRecord[models.Tag],
+			records: newRecords[models.Tag],
+			joins:   []trashLink{{"post_tags", "tag_id"}},
+		},
+	}
+)
+
+func newRecord[T any]() any  { return new(T) }
+func newRecords[T any]() any { return new([]T) }
+
+func trashEntityOf(name string) (trashEntity, error) {
+	e, ok := trashEntities[name]
+	if !ok {
+		return trashEntity{}, apperror.ErrUnknownEntity
+	}
+	return e, nil
+}
+
+// trashed returns the query of the records of e that are in the trash
+func trashed(db *gorm.DB, e trashEntity) *gorm.DB {
+	return db.Unscoped().Model(e.model()).Where("deleted_at IS NOT NULL")
+}
+
+// ===================================================================
+// Trash Operations
+// Soft-deleted records stay in the trash until restored or purged.
+// ===================================================================
+
+// List returns a page of the records of entity in the trash, most recently
+// deleted first
+func (t *trashRepository) List(ctx context.Context, entity string, page, perPage int) ([]models.TrashItem, int64, error) {
+	e, err := trashEntityOf(entity)
+	if err != nil {
+		return nil, 0, err
+	}
+	db := t.db.WithContext(ctx)
+
+	var total int64
+	if err := trashed(db, e).Count(&total).Error; err != nil {
+		return nil, 0, trashError(err)
+	}
+
+	records := e.records()
+	err = trashed(db, e).
+		Order("deleted_at DESC, id DESC").
+		Offset((page - 1) * perPage).
+		Limit(perPage).
+		Find(records).Error
+	if err != nil {
+		return nil, 0, trashError(err)
+	}
+
+	// Every entity embeds gorm.Model
+	rv := reflect.ValueOf(records).Elem()
+	items := make([]models.TrashItem, rv.Len())
+	for i := range items {
+		record := rv.Index(i)
+		base := record.FieldByName("Model").Interface().(gorm.Model)
+		items[i] = models.TrashItem{
+			Entity:    entity,
+			ID:        base.ID,
+			DeletedAt: base.DeletedAt.Time,
+			Record:    record.Interface(),
+		}
+	}
+	return items, total, nil
+}
+
+// Restore takes a record of entity out of the trash, with the children that
+// were deleted with it or after it. Children deleted before it were deleted
+// on their own and stay in the trash. A record whose parent is in the trash
+// can't be restored before its parent.
+func (t *trashRepository) Restore(ctx context.Context, entity string, id uint) error {
+	return restore(t.db.WithContext(ctx), entity, id)
+}
+
+func restore(db *gorm.DB, entity string, id uint) error {
+	e, err := trashEntityOf(entity)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var deletedAt []time.Time
+		if err := trashed(tx, e).Where("id = ?", id).Pluck("deleted_at", &deletedAt).Error; err != nil {
+			return trashError(err)
+		}
+		if len(deletedAt) == 0 {
+			return apperror.ErrNotInTrash
+		}
+		if err := checkParents(tx, entity, id); err != nil {
+			return err
+		}
+		return trashError(restoreRecords(tx, entity, []uint{id}, deletedAt[0]))
+	})
+}
+
+// restoreRecords restores ids and their children deleted since
+func restoreRecords(tx *gorm.DB, entity string, ids []uint, since time.Time) error {
+	e := trashEntities[entity]
+	err := tx.Unscoped().Model(e.model()).Where("id IN ?", ids).Update("deleted_at", nil).Error
+	if err != nil {
+		return err
+	}
+
+	for _, link := range e.children {
+		var childIDs []uint
+		err := trashed(tx, trashEntities[link.table]).
+			Where("? IN ? AND deleted_at >= ?", clause.Column{Name: link.column}, ids, since).
+			Pluck("id", &childIDs).Error
+		if err != nil {
+			return err
+		}
+		if len(childIDs) == 0 {
+			continue
+		}
+		if err := restoreRecords(tx, link.table, childIDs, since); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParents fails with apperror.ErrParentDeleted when a record that the
+// record of entity references is in the trash
+func checkParents(tx *gorm.DB, entity string, id uint) error {
+	e := trashEntities[entity]
+	for _, name := range trashOrder {
+		parent := trashEntities[name]
+		for _, link := range parent.children {
+			if link.table != entity {
+				continue
+			}
+
+			var parentIDs []*uint
+			err := tx.Unscoped().Model(e.model()).Where("id = ?", id).Pluck(link.column, &parentIDs).Error
+			if err != nil {
+				return trashError(err)
+			}
+			if len(parentIDs) == 0 || parentIDs[0] == nil {
+				continue
+			}
+
+			var deleted int64
+			if err := trashed(tx, parent).Where("id = ?", *parentIDs[0]).Count(&deleted).Error; err != nil {
+				return trashError(err)
+			}
+			if deleted > 0 {
+				return apperror.ErrParentDeleted
+			}
+		}
+	}
+	return nil
+}
+
+// Purge permanently deletes a record of entity that is in the trash, along
+// with its children in the trash. Records that are not deleted and still
+// reference it are dealt with by the delete policies of its model, see
+// package cascade; without one, the purge fails with apperror.ErrReferenced.
+func (t *trashRepository) Purge(ctx context.Context, entity string, id uint) error {
+	_, err := purge(t.db.WithContext(ctx), entity, id)
+	return err
+}
+
+func purge(db *gorm.DB, entity string, id uint) (int64, error) {
+	e, err := trashEntityOf(entity)
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		if err := trashed(tx, e).Where("id = ?", id).Pluck("id", &ids).Error; err != nil {
+			return trashError(err)
+		}
+		if len(ids) == 0 {
+			return apperror.ErrNotInTrash
+		}
+		purged, err = purgeRecords(tx, entity, ids)
+		return trashError(err)
+	})
+	if err != nil {
+		// Rolled back
+		return 0, err
+	}
+	return purged, nil
+}
+
+// purgeRecords deletes ids and their children in the trash, children first,
+// and returns how many records it deleted
+func purgeRecords(tx *gorm.DB, entity string, ids []uint) (int64, error) {
+	e := trashEntities[entity]
+
+	var purged int64
+	for _, link := range e.children {
+		var childIDs []uint
+		err := trashed(tx, trashEntities[link.table]).
+			Where("? IN ?", clause.Column{Name: link.column}, ids).
+			Pluck("id", &childIDs).Error
+		if err != nil {
+			return purged, err
+		}
+		if len(childIDs) == 0 {
+			continue
+		}
+		n, err := purgeRecords(tx, link.table, childIDs)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	for _, join := range e.joins {
+		err := tx.Exec("DELETE FROM ? WHERE ? IN ?", clause.Table{Name: join.table}, clause.Column{Name: join.column}, ids).Error
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	result := tx.Unscoped().Where("id IN ?", ids).Delete(e.model())
+	return purged + result.RowsAffected, result.Error
+}
+
+// PurgeBefore purges every record deleted before the given time, with its
+// children in the trash, and returns how many records it deleted. Records
+// still referenced by records that are not deleted are skipped.
+func (t *trashRepository) PurgeBefore(ctx context.Context, before time.Time) (int64, error) {
+	db := t.db.WithContext(ctx)
+
+	var purged int64
+	for _, entity := range trashOrder {
+		var ids []uint
+		err := trashed(db, trashEntities[entity]).
+			Where("deleted_at < ?", before).
+			Order("id").
+			Pluck("id", &ids).Error
+		if err != nil {
+			return purged, trashError(err)
+		}
+
+		// One transaction per record, so that a referenced record does not
+		// hold back the others
+		for _, id := range ids {
+			n, err := purge(db, entity, id)
+			purged += n
+			switch {
+			case err == nil:
+			case errors.Is(err, apperror.ErrReferenced), errors.Is(err, apperror.ErrNotInTrash):
+				// Purged with its parent already, or still in use
+			default:
+				return purged, err
+			}
+		}
+	}
+	return purged, nil
+}
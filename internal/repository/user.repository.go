@@ -2,19 +2,21 @@ package repository
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
+	"strings"
 	"time"
 
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/db"
+	"gorm-reference/internal/db/datatypes"
+	"gorm-reference/internal/db/encryption"
 	"gorm-reference/internal/models"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-var (
-	_           UserRepository = (*userRepository)(nil)
-	ErrNotFound                = errors.New("user not found")
-)
+var _ UserRepository = (*userRepository)(nil)
 
 type UserRepository interface {
 	Create(context.Context, *models.User) error
@@ -24,10 +26,20 @@ type UserRepository interface {
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
 	FindAll(ctx context.Context, page, perPage int) ([]models.User, int64, error)
 	FindWithFilters(ctx context.Context, filters models.UserFilters) ([]models.User, error)
+	StreamWithFilters(ctx context.Context, filters models.UserFilters, fn func(*models.User) error) error
 	Update(ctx context.Context, id uint, updates models.User) error
+	UpdateFields(ctx context.Context, id uint, updates models.User, columns []string) error
 	Save(ctx context.Context, user *models.User) error
 	UpdateLastLogin(ctx context.Context, id uint) error
 	IncreaseLoginCount(ctx context.Context, id uint) error
+	GetPreference(ctx context.Context, id uint, key string) (json.RawMessage, error)
+	SetPreference(ctx context.Context, id uint, key string, value any) error
+	DeletePreference(ctx context.Context, id uint, key string) error
+	MergePreferences(ctx context.Context, id uint, patch map[string]any) error
+	FindByPreference(ctx context.Context, key string, value any) ([]models.User, error)
+	Delete(ctx context.Context, id uint) error
+	HardDelete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) error
 }
 
 // UserRepository handles user database operations
@@ -39,6 +51,11 @@ func (u *userRepository) userQuery(opts ...clause.Expression) gorm.Interface[mod
 	return gorm.G[models.User](u.db, opts...)
 }
 
+// userError converts database errors into the shared error taxonomy
+func userError(err error) error {
+	return db.AppError(err, apperror.ErrUserNotFound)
+}
+
 // ===================================================================================
 // Create Operations
 // Insert records into the database with various methods for single and batch inserts.
@@ -46,23 +63,35 @@ func (u *userRepository) userQuery(opts ...clause.Expression) gorm.Interface[mod
 
 // Create inserts a single user into the database
 func (u *userRepository) Create(ctx context.Context, user *models.User) error {
-	return u.userQuery().Create(ctx, user)
+	return userError(u.userQuery().Create(ctx, user))
 }
 
 // CreateBatch inserts multiple users in a single query for better performance
 func (u *userRepository) CreateBatch(ctx context.Context, users *[]models.User) error {
 	// CreateInBatches processes records in batches to avoid memory issues
 	// Second parameter is the batch size
-	return u.userQuery().CreateInBatches(ctx, users, 100)
+	return userError(u.userQuery().CreateInBatches(ctx, users, 100))
 }
 
-// Upsert creates or updates a user based on conflict columns
+// Upsert creates a user, or updates the live user of the tenant with the
+// same email. An update sets every column imports carry and increments the
+// version, as updates do, so that ETags of the user go stale.
 func (u *userRepository) Upsert(ctx context.Context, user *models.User) error {
-	// Clauses for handling conflicts (upsert)
-	return u.userQuery(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "email"}},
-		DoUpdates: clause.AssignmentColumns([]string{"username", "updated_at"}),
-	}).Create(ctx, user)
+	// Clauses for handling conflicts (upsert). The columns and predicate
+	// must match a unique index, and emails are unique per tenant among
+	// live users, by their blind index.
+	updates := clause.AssignmentColumns([]string{
+		"email", "username", "first_name", "last_name", "password_hash", "updated_at",
+	})
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "version"},
+		Value:  gorm.Expr("users.version + 1"),
+	})
+	return userError(u.userQuery(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "tenant_id"}, {Name: "email_index"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "deleted_at IS NULL"}}},
+		DoUpdates:   updates,
+	}).Create(ctx, user))
 }
 
 // ================================================================
@@ -74,22 +103,17 @@ func (u *userRepository) Upsert(ctx context.Context, user *models.User) error {
 func (u *userRepository) FindByID(ctx context.Context, id uint) (*models.User, error) {
 	user, err := u.userQuery().Where("id = ?", id).First(ctx)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrNotFound
-		}
-		return nil, err
+		return nil, userError(err)
 	}
 	return &user, nil
 }
 
-// FindByEmail retrieves a user by their email
+// FindByEmail retrieves a user by their email. Emails are encrypted, so the
+// lookup goes through their blind index.
 func (u *userRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
-	user, err := u.userQuery().Where("email = ?", email).First(ctx)
+	user, err := u.userQuery().Where("email_index = ?", encryption.BlindIndex(email)).First(ctx)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrNotFound
-		}
-		return nil, err
+		return nil, userError(err)
 	}
 	return &user, nil
 }
@@ -98,7 +122,7 @@ func (u *userRepository) FindAll(ctx context.Context, page, perPage int) ([]mode
 	// Count total records for pagination
 	total, err := u.userQuery().Count(ctx, "*")
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, userError(err)
 	}
 
 	// calculate offset for pagination
@@ -111,7 +135,7 @@ func (u *userRepository) FindAll(ctx context.Context, page, perPage int) ([]mode
 		Limit(perPage).
 		Find(ctx)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, userError(err)
 	}
 
 	return users, total, nil
@@ -119,21 +143,48 @@ func (u *userRepository) FindAll(ctx context.Context, page, perPage int) ([]mode
 
 // FindWithFilters retrieves users matching multiple conditions
 func (u *userRepository) FindWithFilters(ctx context.Context, filters models.UserFilters) ([]models.User, error) {
-	// Start building the query
-	query := u.userQuery()
+	var users []models.User
+	result := whereFilters(u.db.WithContext(ctx).Model(&models.User{}), filters).Find(&users)
+	return users, userError(result.Error)
+}
+
+// StreamWithFilters calls fn with each user matching filters, in ID order,
+// reading one row at a time with Rows so that memory stays flat however
+// many match. It stops at the first error fn returns and returns it.
+func (u *userRepository) StreamWithFilters(ctx context.Context, filters models.UserFilters, fn func(*models.User) error) error {
+	db := u.db.WithContext(ctx)
+	rows, err := whereFilters(db.Model(&models.User{}), filters).Order("id").Rows()
+	if err != nil {
+		return userError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		// ScanRows decrypts the encrypted columns, which scanning the columns
+		// directly would not
+		var user models.User
+		if err := db.ScanRows(rows, &user); err != nil {
+			return userError(err)
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+	return userError(rows.Err())
+}
 
-	// Apply filters conditionally
+// whereFilters applies the filters that are set to query
+func whereFilters(query *gorm.DB, filters models.UserFilters) *gorm.DB {
 	if filters.IsActive != nil {
-		query.Where("is_active = ?", *filters.IsActive)
+		query = query.Where("is_active = ?", *filters.IsActive)
 	}
 	if filters.Username != "" {
-		query.Where("username ILIKE ?", "%"+filters.Username+"%")
+		query = query.Where("username ILIKE ?", "%"+filters.Username+"%")
 	}
 	if !filters.CreatedAfter.IsZero() {
-		query.Where("created_at > ?", filters.CreatedAfter)
+		query = query.Where("created_at > ?", filters.CreatedAfter)
 	}
-
-	return query.Find(ctx)
+	return query
 }
 
 // ====================================================================
@@ -146,10 +197,32 @@ func (u *userRepository) Update(ctx context.Context, id uint, updates models.Use
 	// Updates only the specified fields
 	rowsAffected, err := u.userQuery().Where("id = ?", id).Updates(ctx, updates)
 	if err != nil {
-		return err
+		return userError(err)
 	}
 	if rowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+		return apperror.ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateFields updates the given columns of a user to their values in
+// updates, including zero values and nulls
+func (u *userRepository) UpdateFields(ctx context.Context, id uint, updates models.User, columns []string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	// Selecting the columns makes Updates write them even when zero
+	selected := make([]any, 0, len(columns)-1)
+	for _, column := range columns[1:] {
+		selected = append(selected, column)
+	}
+	rowsAffected, err := u.userQuery().Where("id = ?", id).Select(columns[0], selected...).Updates(ctx, updates)
+	if err != nil {
+		return userError(err)
+	}
+	if rowsAffected == 0 {
+		return apperror.ErrUserNotFound
 	}
 	return nil
 }
@@ -159,7 +232,7 @@ func (u *userRepository) Save(ctx context.Context, user *models.User) error {
 	// Save will update all fields, including zero values
 	// Use this when you want to explicitly set fields to zero/empty
 	result := u.db.WithContext(ctx).Save(user)
-	return result.Error
+	return userError(result.Error)
 }
 
 // UpdateLastLogin updates a single column without running hooks
@@ -167,10 +240,10 @@ func (u *userRepository) UpdateLastLogin(ctx context.Context, id uint) error {
 	now := time.Now()
 	rowsAffected, err := u.userQuery().Where("id = ?", id).Update(ctx, "last_login_at", now)
 	if err != nil {
-		return err
+		return userError(err)
 	}
 	if rowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+		return apperror.ErrUserNotFound
 	}
 	return nil
 }
@@ -182,7 +255,88 @@ func (u *userRepository) IncreaseLoginCount(ctx context.Context, id uint) error
 		Model(&models.User{}).
 		Where("id = ?", id).
 		Update("login_count", gorm.Expr("login_count + ?", 1))
-	return result.Error
+	return userError(result.Error)
+}
+
+// ===========================================================================
+// Preference Operations
+// Change single keys of the preferences document with jsonb operators, so
+// concurrent writers to different keys don't overwrite each other.
+// ===========================================================================
+
+// GetPreference returns the JSON value of one preference of a user
+func (u *userRepository) GetPreference(ctx context.Context, id uint, key string) (json.RawMessage, error) {
+	var rows []struct {
+		Value json.RawMessage
+	}
+	err := u.db.WithContext(ctx).
+		Model(&models.User{}).
+		Select("? AS value", datatypes.Path("preferences", key).JSON()).
+		Where("id = ?", id).
+		Limit(1).
+		Find(&rows).Error
+	if err != nil {
+		return nil, userError(err)
+	}
+	if len(rows) == 0 {
+		return nil, apperror.ErrUserNotFound
+	}
+	if rows[0].Value == nil {
+		return nil, apperror.ErrPreferenceNotFound
+	}
+	return rows[0].Value, nil
+}
+
+// SetPreference sets one preference of a user, leaving the others as they are
+func (u *userRepository) SetPreference(ctx context.Context, id uint, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return apperror.ErrInvalidInput.Wrap(err)
+	}
+	// jsonb_set adds the key when missing; users without preferences start
+	// from an empty document
+	return u.updatePreferences(ctx, id,
+		gorm.Expr("jsonb_set(coalesce(preferences, '{}'), ARRAY[?::text], ?::jsonb)", key, string(data)))
+}
+
+// DeletePreference removes one preference of a user
+func (u *userRepository) DeletePreference(ctx context.Context, id uint, key string) error {
+	return u.updatePreferences(ctx, id, gorm.Expr("preferences #- ARRAY[?::text]", key))
+}
+
+// MergePreferences deep merges patch into the preferences of a user, as an
+// RFC 7396 merge patch: nested objects merge and null values remove keys
+func (u *userRepository) MergePreferences(ctx context.Context, id uint, patch map[string]any) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return apperror.ErrInvalidInput.Wrap(err)
+	}
+	// jsonb_merge_patch is defined by migration 00011
+	return u.updatePreferences(ctx, id, gorm.Expr("jsonb_merge_patch(preferences, ?::jsonb)", string(data)))
+}
+
+// FindByPreference returns the users whose preference key has value. Keys
+// may be dotted paths into nested preferences, e.g. notifications.email. The
+// GIN index on preferences of migration 00015 serves the lookup.
+func (u *userRepository) FindByPreference(ctx context.Context, key string, value any) ([]models.User, error) {
+	path := datatypes.Path("preferences", strings.Split(key, ".")...)
+	users, err := u.userQuery().Where(path.Equals(value)).Find(ctx)
+	return users, userError(err)
+}
+
+// updatePreferences sets the preferences column to expr in one statement
+func (u *userRepository) updatePreferences(ctx context.Context, id uint, expr clause.Expr) error {
+	result := u.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Update("preferences", expr)
+	if result.Error != nil {
+		return userError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.ErrUserNotFound
+	}
+	return nil
 }
 
 // =======================================================================
@@ -195,19 +349,26 @@ func (u *userRepository) Delete(ctx context.Context, id uint) error {
 	// With gorm.Model, Delete sets deleted_at instead of removing the row
 	rowsAffected, err := u.userQuery().Where("id = ?", id).Delete(ctx)
 	if err != nil {
-		return err
+		return userError(err)
 	}
 	if rowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+		return apperror.ErrUserNotFound
 	}
 	return nil
 }
 
-// HardDelete permanently removes a record from the database
+// HardDelete permanently removes a record from the database, deleted or
+// not. It fails with apperror.ErrReferenced while other records reference it.
 func (u *userRepository) HardDelete(ctx context.Context, id uint) error {
 	// Unscoped bypasses soft delete and permanently removes the record
 	result := u.db.WithContext(ctx).Unscoped().Delete(&models.User{}, id)
-	return result.Error
+	if result.Error != nil {
+		return userError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.ErrUserNotFound
+	}
+	return nil
 }
 
 // DeleteByCondition deletes multiple records matching a condition
@@ -216,17 +377,13 @@ func (u *userRepository) DeleteInactiveUsers(ctx context.Context, before time.Ti
 		Where("is_active = ? AND last_login_at < ?", false, before).
 		Delete(ctx)
 	if err != nil {
-		return 0, err
+		return 0, userError(err)
 	}
 	return rowsAffected, nil
 }
 
-// Restore recovers a soft-deleted record
+// Restore recovers a soft-deleted user along with the profile, posts and
+// comments deleted with it, see TrashRepository.Restore
 func (u *userRepository) Restore(ctx context.Context, id uint) error {
-	result := u.db.WithContext(ctx).
-		Unscoped().
-		Model(&models.User{}).
-		Where("id = ?", id).
-		Update("deleted_at", nil)
-	return result.Error
+	return restore(u.db.WithContext(ctx), "users", id)
 }
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+
+	"gorm-reference/internal/repository"
+	"gorm-reference/internal/tenant"
+	"gorm-reference/internal/tracing"
+)
+
+var _ EncryptionService = (*encryptionService)(nil)
+
+// EncryptionService maintains the encrypted columns of every tenant
+type EncryptionService interface {
+	Reencrypt(ctx context.Context, batchSize int) (int64, error)
+}
+
+type encryptionService struct {
+	repo *repository.Repository
+}
+
+// Reencrypt moves the encrypted values of every tenant to the primary key,
+// batchSize rows at a time, and returns how many rows it rewrote
+func (s *encryptionService) Reencrypt(ctx context.Context, batchSize int) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "EncryptionService.Reencrypt")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.Encryption.Reencrypt(tenant.AllowUnscoped(ctx), batchSize)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/models"
+)
+
+// versionField names the expected version in patches and field masks. It is
+// checked by optimistic locking rather than written.
+const versionField = "version"
+
+// mergePatch applies the RFC 7396 merge patch to doc: members of patch
+// replace those of doc, objects merge recursively and nulls remove members
+func mergePatch(doc, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	docObject, ok := doc.(map[string]any)
+	if !ok {
+		docObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(docObject, key)
+			continue
+		}
+		docObject[key] = mergePatch(docObject[key], value)
+	}
+	return docObject
+}
+
+// decodeObject decodes a JSON object, keeping numbers exact
+func decodeObject(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "patch must be a JSON object")
+	}
+	return object, nil
+}
+
+// userPatchColumns returns the columns behind the JSON fields of a user
+// patch, in a stable order. Protected and unknown fields fail validation.
+func userPatchColumns(fields []string) ([]string, error) {
+	var (
+		columns []string
+		invalid []apperror.FieldError
+	)
+	for _, field := range fields {
+		if field == versionField {
+			continue
+		}
+		column, ok := models.UserPatchColumns[field]
+		switch {
+		case models.UserProtectedFields[field]:
+			invalid = append(invalid, apperror.FieldError{Field: field, Message: "cannot be changed"})
+		case !ok:
+			invalid = append(invalid, apperror.FieldError{Field: field, Message: "unknown field"})
+		default:
+			columns = append(columns, column)
+		}
+	}
+
+	if len(invalid) > 0 {
+		err := apperror.ErrValidation.Wrap(nil)
+		err.Fields = invalid
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "patch changes no fields")
+	}
+	slices.Sort(columns)
+	return slices.Compact(columns), nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+
+	"gorm-reference/internal/db/datatypes"
+	"gorm-reference/internal/models"
+	"gorm-reference/internal/repository"
+	"gorm-reference/internal/tracing"
+)
+
+var _ PrivacyService = (*privacyService)(nil)
+
+// PrivacyService answers data subject requests: exports of everything tied
+// to a user and erasures of their personal data. Every request is recorded
+// with its outcome, and completed ones are audited.
+type PrivacyService interface {
+	Export(ctx context.Context, userID uint) (*models.UserData, error)
+	Erase(ctx context.Context, userID uint) (*models.DataRequest, error)
+	Requests(ctx context.Context, userID uint) ([]models.DataRequest, error)
+}
+
+type privacyService struct {
+	repo *repository.Repository
+}
+
+// Export collects everything stored about a user
+func (s *privacyService) Export(ctx context.Context, userID uint) (_ *models.UserData, err error) {
+	ctx, span := tracing.Start(ctx, "PrivacyService.Export")
+	defer func() { tracing.End(span, err) }()
+
+	request := &models.DataRequest{UserID: userID, Kind: models.DataRequestExport}
+	if err := s.repo.Privacy.CreateRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	data, err := s.repo.Privacy.Collect(ctx, userID)
+	if err == nil {
+		err = s.repo.Privacy.Audit(ctx, auditEntry(request, models.AuditUserExported))
+	}
+	if err := s.finish(ctx, request, err); err != nil {
+		return nil, err
+	}
+
+	// The export includes its own request, as it ended
+	data.RequestID = request.ID
+	for i := range data.Requests {
+		if data.Requests[i].ID == request.ID {
+			data.Requests[i] = *request
+		}
+	}
+	return data, nil
+}
+
+// Erase anonymizes the personal data of a user, see
+// repository.PrivacyRepository.Erase, and returns the record of the request
+func (s *privacyService) Erase(ctx context.Context, userID uint) (_ *models.DataRequest, err error) {
+	ctx, span := tracing.Start(ctx, "PrivacyService.Erase")
+	defer func() { tracing.End(span, err) }()
+
+	request := &models.DataRequest{UserID: userID, Kind: models.DataRequestErasure}
+	if err := s.repo.Privacy.CreateRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	err = s.repo.Privacy.Erase(ctx, userID, auditEntry(request, models.AuditUserErased))
+	if err := s.finish(ctx, request, err); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// Requests returns the data requests of a user, oldest first
+func (s *privacyService) Requests(ctx context.Context, userID uint) (_ []models.DataRequest, err error) {
+	ctx, span := tracing.Start(ctx, "PrivacyService.Requests")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.Privacy.FindRequests(ctx, userID)
+}
+
+// finish records the outcome of request and returns the error of the
+// request, or else the one of recording it
+func (s *privacyService) finish(ctx context.Context, request *models.DataRequest, failure error) error {
+	if err := s.repo.Privacy.FinishRequest(ctx, request, failure); failure == nil {
+		return err
+	}
+	return failure
+}
+
+// auditEntry returns the audit log entry of a data request. It names the
+// request rather than copying anything from it.
+func auditEntry(request *models.DataRequest, action string) *models.AuditLog {
+	return &models.AuditLog{
+		UserID:  request.UserID,
+		Action:  action,
+		Details: datatypes.NewJSONB(map[string]any{"requestId": request.ID}),
+	}
+}
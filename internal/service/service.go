@@ -4,11 +4,17 @@ package service
 import "gorm-reference/internal/repository"
 
 type Service struct {
-	User UserService
+	User       UserService
+	Trash      TrashService
+	Privacy    PrivacyService
+	Encryption EncryptionService
 }
 
 func NewService(r *repository.Repository) *Service {
 	return &Service{
-		User: userService{repo: r},
+		User:       &userService{repo: r},
+		Trash:      &trashService{repo: r},
+		Privacy:    &privacyService{repo: r},
+		Encryption: &encryptionService{repo: r},
 	}
 }
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"gorm-reference/internal/models"
+	"gorm-reference/internal/repository"
+	"gorm-reference/internal/tenant"
+	"gorm-reference/internal/tracing"
+)
+
+var _ TrashService = (*trashService)(nil)
+
+type TrashService interface {
+	List(ctx context.Context, entity string, page, perPage int) ([]models.TrashItem, int64, error)
+	Restore(ctx context.Context, entity string, id uint) error
+	Purge(ctx context.Context, entity string, id uint) error
+	PurgeExpired(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+type trashService struct {
+	repo *repository.Repository
+}
+
+func (s *trashService) List(ctx context.Context, entity string, page, perPage int) (_ []models.TrashItem, _ int64, err error) {
+	ctx, span := tracing.Start(ctx, "TrashService.List")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.Trash.List(ctx, entity, page, perPage)
+}
+
+// Restore takes a record out of the trash along with the records deleted
+// with it
+func (s *trashService) Restore(ctx context.Context, entity string, id uint) (err error) {
+	ctx, span := tracing.Start(ctx, "TrashService.Restore")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.Trash.Restore(ctx, entity, id)
+}
+
+// Purge permanently deletes a record in the trash
+func (s *trashService) Purge(ctx context.Context, entity string, id uint) (err error) {
+	ctx, span := tracing.Start(ctx, "TrashService.Purge")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.Trash.Purge(ctx, entity, id)
+}
+
+// PurgeExpired purges the records of every tenant that have been in the
+// trash for longer than retention, and returns how many it deleted
+func (s *trashService) PurgeExpired(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "TrashService.PurgeExpired")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.Trash.PurgeBefore(tenant.AllowUnscoped(ctx), time.Now().Add(-retention))
+}
@@ -2,21 +2,339 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"slices"
 
+	"gorm-reference/internal/apperror"
+	"gorm-reference/internal/bulk"
+	"gorm-reference/internal/db"
 	"gorm-reference/internal/models"
 	"gorm-reference/internal/repository"
+	"gorm-reference/internal/tracing"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var _ UserService = (*userService)(nil)
 
 type UserService interface {
-	Create(ctx context.Context, user *models.User) error
+	Create(ctx context.Context, input models.NewUser) (*models.User, error)
+	Get(ctx context.Context, id uint) (*models.User, error)
+	Update(ctx context.Context, id uint, updates models.User) (*models.User, error)
+	MergePatch(ctx context.Context, id uint, patch []byte, version int64) (*models.User, error)
+	UpdateFields(ctx context.Context, id uint, updates models.User, fields []string) (*models.User, error)
+	GetPreference(ctx context.Context, id uint, key string) (json.RawMessage, error)
+	SetPreference(ctx context.Context, id uint, key string, value any) error
+	DeletePreference(ctx context.Context, id uint, key string) error
+	MergePreferences(ctx context.Context, id uint, patch map[string]any) (map[string]any, error)
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) (*models.User, error)
+	Import(ctx context.Context, users *bulk.Decoder) (*models.ImportReport, error)
+	Export(ctx context.Context, filters models.UserFilters, fn func(*models.User) error) error
 }
 
 type userService struct {
 	repo *repository.Repository
 }
 
-func (s *userService) Create(ctx context.Context, user *models.User) error {
-	return s.repo.User.Create(ctx, user)
+// Create creates a user with the hash of the input's password
+func (s *userService) Create(ctx context.Context, input models.NewUser) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Create")
+	defer func() { tracing.End(span, err) }()
+
+	if err := apperror.Validation(input.Validate()); err != nil {
+		return nil, err
+	}
+	user := input.User()
+	if user.PasswordHash, err = hashPassword(input.Password); err != nil {
+		return nil, err
+	}
+	if err := s.repo.User.Create(ctx, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// hashPassword returns the bcrypt hash of a password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", apperror.ErrInternal.Wrap(err)
+	}
+	return string(hash), nil
+}
+
+func (s *userService) Get(ctx context.Context, id uint) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Get")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.User.FindByID(ctx, id)
+}
+
+// Update applies the fields set in updates and returns the updated user. A
+// non-zero updates.Version must match the stored one, or the update fails
+// with apperror.ErrVersionConflict.
+func (s *userService) Update(ctx context.Context, id uint, updates models.User) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Update")
+	defer func() { tracing.End(span, err) }()
+
+	if err := apperror.Validation(updates.ValidateUpdate()); err != nil {
+		return nil, err
+	}
+	if err := s.repo.User.Update(ctx, id, updates); err != nil {
+		return nil, err
+	}
+	// A replica may not have the update yet
+	return s.repo.User.FindByID(db.ReadPrimary(ctx), id)
+}
+
+// MergePatch applies an RFC 7396 merge patch to a user and returns the
+// result. Only the fields in the patch are written, nulls and zero values
+// included. The version to patch is version if non-zero, else the patch's
+// own version member, else the version the user was read at.
+func (s *userService) MergePatch(ctx context.Context, id uint, patch []byte, version int64) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.MergePatch")
+	defer func() { tracing.End(span, err) }()
+
+	changes, err := decodeObject(patch)
+	if err != nil {
+		return nil, apperror.ErrInvalidInput.Wrap(err)
+	}
+	fields := slices.Sorted(maps.Keys(changes))
+	columns, err := userPatchColumns(fields)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		if v, ok := changes[versionField].(json.Number); ok {
+			if version, err = v.Int64(); err != nil {
+				return nil, apperror.ErrInvalidInput.Wrap(err)
+			}
+		}
+	}
+	delete(changes, versionField)
+
+	// The patch applies to the current document, so read it from the primary
+	current, err := s.repo.User.FindByID(db.ReadPrimary(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = current.Version
+	}
+
+	doc, err := toObject(current)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := json.Marshal(mergePatch(doc, changes))
+	if err != nil {
+		return nil, apperror.ErrInternal.Wrap(err)
+	}
+	var updates models.User
+	if err := json.Unmarshal(merged, &updates); err != nil {
+		return nil, apperror.ErrInvalidInput.Wrap(err)
+	}
+	updates.Version = version
+
+	return s.updateFields(ctx, id, updates, fields, columns)
+}
+
+// UpdateFields sets the fields named by the mask to their values in updates,
+// zero values included. A non-zero updates.Version must match the stored
+// one.
+func (s *userService) UpdateFields(ctx context.Context, id uint, updates models.User, fields []string) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateFields")
+	defer func() { tracing.End(span, err) }()
+
+	fields = slices.Sorted(slices.Values(fields))
+	columns, err := userPatchColumns(fields)
+	if err != nil {
+		return nil, err
+	}
+	return s.updateFields(ctx, id, updates, fields, columns)
+}
+
+func (s *userService) updateFields(ctx context.Context, id uint, updates models.User, fields, columns []string) (*models.User, error) {
+	if err := apperror.Validation(updates.ValidatePatch(fields)); err != nil {
+		return nil, err
+	}
+	if err := s.repo.User.UpdateFields(ctx, id, updates, columns); err != nil {
+		return nil, err
+	}
+	return s.repo.User.FindByID(db.ReadPrimary(ctx), id)
+}
+
+func (s *userService) GetPreference(ctx context.Context, id uint, key string) (_ json.RawMessage, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetPreference")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.User.GetPreference(ctx, id, key)
+}
+
+// SetPreference validates value against the schema of key and stores it
+func (s *userService) SetPreference(ctx context.Context, id uint, key string, value any) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetPreference")
+	defer func() { tracing.End(span, err) }()
+
+	if err := models.ValidatePreference(key, value); err != nil {
+		return apperror.Validation(validation.Errors{key: err})
+	}
+	return s.repo.User.SetPreference(ctx, id, key, value)
+}
+
+func (s *userService) DeletePreference(ctx context.Context, id uint, key string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DeletePreference")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.User.DeletePreference(ctx, id, key)
+}
+
+// MergePreferences deep merges patch into the preferences of a user and
+// returns the result. Null values remove preferences.
+func (s *userService) MergePreferences(ctx context.Context, id uint, patch map[string]any) (_ map[string]any, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.MergePreferences")
+	defer func() { tracing.End(span, err) }()
+
+	if patch == nil {
+		return nil, apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, "preferences must be a JSON object")
+	}
+	if err := apperror.Validation(models.ValidatePreferences(patch)); err != nil {
+		return nil, err
+	}
+	if err := s.repo.User.MergePreferences(ctx, id, patch); err != nil {
+		return nil, err
+	}
+	user, err := s.repo.User.FindByID(db.ReadPrimary(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	return user.Preferences.Data, nil
+}
+
+// Delete moves a user to the trash, see TrashService
+func (s *userService) Delete(ctx context.Context, id uint) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Delete")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.User.Delete(ctx, id)
+}
+
+// Restore takes a user out of the trash, with the profile, posts and
+// comments deleted with it, and returns the user
+func (s *userService) Restore(ctx context.Context, id uint) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Restore")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.repo.User.Restore(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.repo.User.FindByID(db.ReadPrimary(ctx), id)
+}
+
+// Import validates each user read from users and upserts it by email, see
+// repository.UserRepository.Upsert, one row at a time. Rows that can't be
+// decoded, fail validation or conflict with another user are reported and
+// skipped, up to models.MaxImportErrors of them; other errors end the
+// import, leaving the rows before them imported.
+func (s *userService) Import(ctx context.Context, users *bulk.Decoder) (_ *models.ImportReport, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Import")
+	defer func() { tracing.End(span, err) }()
+
+	report := &models.ImportReport{Errors: []models.ImportError{}}
+	for {
+		user, err := users.Next()
+		if err == io.EOF {
+			return report, nil
+		}
+		var rowErr *bulk.RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return nil, err
+		}
+		report.Rows++
+
+		if rowErr != nil {
+			// Decoding errors name what is wrong with the row
+			err = apperror.New(apperror.KindInvalidInput, apperror.CodeInvalidInput, rowErr.Err.Error())
+		} else {
+			err = s.importUser(ctx, user)
+		}
+		if err != nil {
+			if !rowFailure(err) {
+				return nil, err
+			}
+			report.Failed++
+			if len(report.Errors) < models.MaxImportErrors {
+				report.Errors = append(report.Errors, importError(users.Row(), user, err))
+			} else {
+				report.ErrorsTruncated = true
+			}
+			continue
+		}
+		report.Imported++
+	}
+}
+
+// importUser upserts a user read by Import with the hash of its password
+func (s *userService) importUser(ctx context.Context, input *models.NewUser) (err error) {
+	if err := apperror.Validation(input.Validate()); err != nil {
+		return err
+	}
+	user := input.User()
+	if user.PasswordHash, err = hashPassword(input.Password); err != nil {
+		return err
+	}
+	return s.repo.User.Upsert(ctx, &user)
+}
+
+// rowFailure reports whether err fails a row of an import alone, rather than
+// the whole import
+func rowFailure(err error) bool {
+	switch apperror.As(err).Kind {
+	case apperror.KindValidation, apperror.KindInvalidInput, apperror.KindConflict:
+		return true
+	default:
+		return false
+	}
+}
+
+// importError describes why a row of an import failed
+func importError(row int, user *models.NewUser, err error) models.ImportError {
+	appErr := apperror.As(err)
+	failure := models.ImportError{
+		Row:    row,
+		Code:   appErr.Code,
+		Detail: appErr.Message,
+		Errors: appErr.Fields,
+	}
+	if user != nil && user.Email != nil {
+		failure.Email = *user.Email
+	}
+	return failure
+}
+
+// Export calls fn with each user matching filters, in ID order, streaming
+// them from the database
+func (s *userService) Export(ctx context.Context, filters models.UserFilters, fn func(*models.User) error) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Export")
+	defer func() { tracing.End(span, err) }()
+
+	return s.repo.User.StreamWithFilters(ctx, filters, fn)
+}
+
+// toObject returns the JSON object form of v
+func toObject(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, apperror.ErrInternal.Wrap(err)
+	}
+	object, err := decodeObject(data)
+	if err != nil {
+		return nil, apperror.ErrInternal.Wrap(err)
+	}
+	return object, nil
 }
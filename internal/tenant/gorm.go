@@ -0,0 +1,199 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm-reference/internal/db/gormutil"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// GORMPlugin scopes every statement on a model with a tenant_id column to
+// the tenant of the statement's context. Queries, updates and deletes are
+// filtered by it; creates and updates fill it in, and fail on a record that
+// names another tenant. A statement without a tenant in its context fails
+// unless the context comes from AllowUnscoped.
+//
+// Only the model's own table is filtered. Rows reached through joins belong
+// to the same tenant as the rows referencing them, since every record is
+// created within its tenant. Raw SQL can't be filtered: raw statements on a
+// tenant model, such as db.Model(&models.User{}).Exec(...), fail unless
+// unscoped, and must filter by tenant_id themselves. Raw statements without
+// a model aren't seen by the plugin at all.
+type GORMPlugin struct{}
+
+var _ gorm.Plugin = (*GORMPlugin)(nil)
+
+// NewGORMPlugin creates the plugin
+func NewGORMPlugin() *GORMPlugin {
+	return &GORMPlugin{}
+}
+
+// Name implements gorm.Plugin
+func (p *GORMPlugin) Name() string {
+	return "tenant"
+}
+
+// Initialize assigns the tenant of creates and updates, scopes queries,
+// updates, deletes and row queries to it and rejects raw statements
+func (p *GORMPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return gormutil.Register([]gormutil.Hook{
+		{Register: cb.Create().Before("gorm:create").Register, Name: "tenant:assign_create", Fn: p.assign(true)},
+		{Register: cb.Query().Before("gorm:query").Register, Name: "tenant:scope_query", Fn: p.scope},
+		{Register: cb.Update().Before("gorm:update").Register, Name: "tenant:assign_update", Fn: p.assign(false)},
+		{Register: cb.Update().Before("gorm:update").Register, Name: "tenant:scope_update", Fn: p.scope},
+		{Register: cb.Delete().Before("gorm:delete").Register, Name: "tenant:scope_delete", Fn: p.scope},
+		{Register: cb.Row().Before("gorm:row").Register, Name: "tenant:scope_row", Fn: p.scope},
+		{Register: cb.Raw().Before("gorm:raw").Register, Name: "tenant:scope_raw", Fn: p.scope},
+	})
+}
+
+// tenantOf returns the tenant column of the statement's model and the
+// tenant to scope it to. field is nil when the statement is not tenant
+// scoped; id is empty when it is allowed to see every tenant.
+func tenantOf(db *gorm.DB) (field *schema.Field, id string, err error) {
+	stmt := db.Statement
+	if stmt.Schema == nil {
+		return nil, "", nil
+	}
+	if field = stmt.Schema.LookUpField(Column); field == nil {
+		return nil, "", nil
+	}
+	if Unscoped(stmt.Context) {
+		return field, "", nil
+	}
+
+	id, ok := FromContext(stmt.Context)
+	if !ok {
+		return nil, "", fmt.Errorf("%w in context for %s; use tenant.WithID or tenant.AllowUnscoped", ErrMissing, stmt.Table)
+	}
+	return field, id, nil
+}
+
+func (p *GORMPlugin) scope(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	field, id, err := tenantOf(db)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if field == nil || id == "" {
+		return
+	}
+
+	stmt := db.Statement
+	if stmt.SQL.Len() > 0 {
+		_ = db.AddError(fmt.Errorf("raw SQL on %s can't be scoped to tenant %q; filter by %s and use tenant.AllowUnscoped",
+			stmt.Table, id, Column))
+		return
+	}
+	// Group the caller's conditions, so that an OR among them can't reach
+	// past the tenant filter, as GORM does for soft deletes
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 1 {
+			where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+			c.Expression = where
+			stmt.Clauses["WHERE"] = c
+		}
+	}
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id},
+	}})
+}
+
+// assign fills in the tenant of the records being written. Records that are
+// created from maps get the column added; maps of updates are only checked.
+func (p *GORMPlugin) assign(create bool) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil {
+			return
+		}
+		field, id, err := tenantOf(db)
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		if field == nil || id == "" {
+			return
+		}
+
+		stmt := db.Statement
+		ctx := stmt.Context
+		switch rv := stmt.ReflectValue; rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				if err := assignRecord(ctx, field, reflect.Indirect(rv.Index(i)), id); err != nil {
+					_ = db.AddError(err)
+					return
+				}
+			}
+		case reflect.Struct:
+			if err := assignRecord(ctx, field, rv, id); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+		}
+
+		switch dest := stmt.Dest.(type) {
+		case map[string]any:
+			if err := assignMap(dest, field, id, create); err != nil {
+				_ = db.AddError(err)
+			}
+		case []map[string]any:
+			for _, m := range dest {
+				if err := assignMap(m, field, id, create); err != nil {
+					_ = db.AddError(err)
+					return
+				}
+			}
+		default:
+			// Updates may come in a record other than the model
+			rv := reflect.Indirect(reflect.ValueOf(dest))
+			if rv.Kind() == reflect.Struct && rv.Type() == stmt.Schema.ModelType {
+				if err := assignRecord(ctx, field, rv, id); err != nil {
+					_ = db.AddError(err)
+				}
+			}
+		}
+	}
+}
+
+func assignRecord(ctx context.Context, field *schema.Field, rv reflect.Value, id string) error {
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	value, zero := field.ValueOf(ctx, rv)
+	if zero {
+		// Updates passed by value can't be filled in, and needn't be
+		if !rv.CanAddr() {
+			return nil
+		}
+		return field.Set(ctx, rv, id)
+	}
+	if value != id {
+		return fmt.Errorf("%w: %v, not %s", ErrMismatch, value, id)
+	}
+	return nil
+}
+
+func assignMap(m map[string]any, field *schema.Field, id string, create bool) error {
+	for _, key := range []string{field.DBName, field.Name} {
+		if value, ok := m[key]; ok {
+			if value != id {
+				return fmt.Errorf("%w: %v, not %s", ErrMismatch, value, id)
+			}
+			return nil
+		}
+	}
+	if create {
+		m[field.DBName] = id
+	}
+	return nil
+}
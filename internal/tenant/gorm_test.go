@@ -0,0 +1,221 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm-reference/internal/tenant"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// note is the tenant model of the plugin tests, soft deleted so that GORM's
+// Unscoped has something to drop
+type note struct {
+	ID        uint
+	TenantID  string
+	Body      string
+	DeletedAt gorm.DeletedAt
+}
+
+// setting has no tenant column, so the plugin leaves it alone
+type setting struct {
+	ID   uint
+	Name string
+}
+
+const scoped = `"notes"."tenant_id" = $`
+
+func TestReadsAreScoped(t *testing.T) {
+	db := openDryRun(t).WithContext(tenant.WithID(context.Background(), "acme"))
+
+	statements := map[string]*gorm.Statement{
+		"find":          db.Find(&[]note{}).Statement,
+		"first":         db.First(&note{}, 1).Statement,
+		"count":         db.Model(&note{}).Count(new(int64)).Statement,
+		"pluck":         db.Model(&note{}).Pluck("body", &[]string{}).Statement,
+		"where":         db.Where("body = ?", "x").Find(&[]note{}).Statement,
+		"or":            db.Where("body = ?", "x").Or("body = ?", "y").Find(&[]note{}).Statement,
+		"gorm unscoped": db.Unscoped().Find(&[]note{}).Statement,
+	}
+	for name, stmt := range statements {
+		t.Run(name, func(t *testing.T) {
+			assertScoped(t, stmt, "acme")
+		})
+	}
+
+	// The tenant filter stays outside the caller's OR
+	sql := statements["or"].SQL.String()
+	if !strings.Contains(sql, "(body = $1 OR body = $2) AND "+scoped) {
+		t.Errorf("OR escapes the tenant filter: %s", sql)
+	}
+}
+
+func TestWritesAreScoped(t *testing.T) {
+	db := openDryRun(t).WithContext(tenant.WithID(context.Background(), "acme"))
+
+	statements := map[string]*gorm.Statement{
+		"update":          db.Model(&note{ID: 1}).Update("body", "x").Statement,
+		"updates map":     db.Model(&note{}).Where("id = ?", 1).Updates(map[string]any{"body": "x"}).Statement,
+		"updates struct":  db.Model(&note{ID: 1}).Updates(note{Body: "x"}).Statement,
+		"delete":          db.Delete(&note{ID: 1}).Statement,
+		"unscoped delete": db.Unscoped().Delete(&note{ID: 1}).Statement,
+	}
+	for name, stmt := range statements {
+		t.Run(name, func(t *testing.T) {
+			assertScoped(t, stmt, "acme")
+		})
+	}
+}
+
+func TestCreateAssignsTenant(t *testing.T) {
+	db := openDryRun(t).WithContext(tenant.WithID(context.Background(), "acme"))
+
+	record := note{Body: "x"}
+	if err := db.Create(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	if record.TenantID != "acme" {
+		t.Errorf("TenantID = %q, want acme", record.TenantID)
+	}
+
+	batch := []note{{Body: "a"}, {Body: "b", TenantID: "acme"}}
+	if err := db.Create(&batch).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range batch {
+		if r.TenantID != "acme" {
+			t.Errorf("batch[%d].TenantID = %q, want acme", i, r.TenantID)
+		}
+	}
+
+	values := map[string]any{"body": "x"}
+	if err := db.Model(&note{}).Create(values).Error; err != nil {
+		t.Fatal(err)
+	}
+	if values[tenant.Column] != "acme" {
+		t.Errorf("map created without the tenant: %v", values)
+	}
+}
+
+func TestCrossTenantWritesFail(t *testing.T) {
+	db := openDryRun(t).WithContext(tenant.WithID(context.Background(), "acme"))
+
+	errs := map[string]error{
+		"create":      db.Create(&note{TenantID: "globex"}).Error,
+		"batch":       db.Create(&[]note{{TenantID: "acme"}, {TenantID: "globex"}}).Error,
+		"create map":  db.Model(&note{}).Create(map[string]any{"tenant_id": "globex"}).Error,
+		"update":      db.Model(&note{ID: 1}).Updates(&note{TenantID: "globex"}).Error,
+		"update map":  db.Model(&note{ID: 1}).Updates(map[string]any{"TenantID": "globex"}).Error,
+		"move record": db.Save(&note{ID: 1, TenantID: "globex"}).Error,
+	}
+	for name, err := range errs {
+		if !errors.Is(err, tenant.ErrMismatch) {
+			t.Errorf("%s error = %v, want %v", name, err, tenant.ErrMismatch)
+		}
+	}
+}
+
+func TestMissingTenantFails(t *testing.T) {
+	db := openDryRun(t).WithContext(context.Background())
+
+	errs := map[string]error{
+		"find":   db.Find(&[]note{}).Error,
+		"create": db.Create(&note{}).Error,
+		"update": db.Model(&note{ID: 1}).Update("body", "x").Error,
+		"delete": db.Delete(&note{ID: 1}).Error,
+	}
+	for name, err := range errs {
+		if !errors.Is(err, tenant.ErrMissing) {
+			t.Errorf("%s error = %v, want %v", name, err, tenant.ErrMissing)
+		}
+	}
+
+	// Models without a tenant column need none
+	if err := db.Find(&[]setting{}).Error; err != nil {
+		t.Errorf("find on a model without tenants: %v", err)
+	}
+}
+
+func TestAllowUnscoped(t *testing.T) {
+	ctx := tenant.AllowUnscoped(context.Background())
+	db := openDryRun(t).WithContext(ctx)
+
+	stmt := db.Find(&[]note{}).Statement
+	if stmt.Error != nil || strings.Contains(stmt.SQL.String(), "tenant_id") {
+		t.Errorf("unscoped find = %s, %v, want no tenant filter", stmt.SQL.String(), stmt.Error)
+	}
+
+	// Records created unscoped keep the tenant they carry
+	record := note{TenantID: "globex"}
+	if err := db.Create(&record).Error; err != nil || record.TenantID != "globex" {
+		t.Errorf("unscoped create = %q, %v", record.TenantID, err)
+	}
+
+	rescoped := openDryRun(t).WithContext(tenant.Rescope(ctx, "acme"))
+	assertScoped(t, rescoped.Find(&[]note{}).Statement, "acme")
+}
+
+func TestRawSQLIsRejected(t *testing.T) {
+	db := openDryRun(t).WithContext(tenant.WithID(context.Background(), "acme"))
+
+	errs := map[string]error{
+		"exec": db.Model(&note{}).Exec("UPDATE notes SET body = ?", "x").Error,
+		"raw":  db.Model(&note{}).Raw("SELECT * FROM notes").Scan(&[]note{}).Error,
+	}
+	for name, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), `can't be scoped to tenant "acme"`) {
+			t.Errorf("%s error = %v, want raw SQL refused", name, err)
+		}
+	}
+
+	unscoped := db.WithContext(tenant.AllowUnscoped(context.Background()))
+	if err := unscoped.Model(&note{}).Exec("UPDATE notes SET body = ? WHERE tenant_id = ?", "x", "acme").Error; err != nil {
+		t.Errorf("unscoped exec: %v", err)
+	}
+
+	// Raw SQL without a model isn't seen at all
+	if err := db.Exec("UPDATE notes SET body = ?", "x").Error; err != nil {
+		t.Errorf("exec without a model: %v", err)
+	}
+}
+
+// openDryRun returns a database that builds statements without running them
+func openDryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(tenant.NewGORMPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// assertScoped checks that stmt filters by the tenant id
+func assertScoped(t *testing.T, stmt *gorm.Statement, id string) {
+	t.Helper()
+	if stmt.Error != nil {
+		t.Fatal(stmt.Error)
+	}
+	sql := stmt.SQL.String()
+	if !strings.Contains(sql, scoped) {
+		t.Fatalf("statement isn't scoped to its tenant: %s", sql)
+	}
+	for _, v := range stmt.Vars {
+		if v == id {
+			return
+		}
+	}
+	t.Errorf("vars %v lack the tenant %q", stmt.Vars, id)
+}
@@ -0,0 +1,130 @@
+// Package tenant isolates the data of the customers sharing a deployment.
+// The tenant of a request rides on its context; the GORM plugin scopes every
+// statement on a table with a tenant_id column to it.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Column is the column that holds the tenant of a row
+const Column = "tenant_id"
+
+// Default is the tenant that owned every row before tenancy was introduced
+const Default = "default"
+
+var (
+	ErrMissing   = errors.New("no tenant")
+	ErrInvalid   = errors.New("invalid tenant")
+	ErrAmbiguous = errors.New("header and host name different tenants")
+	ErrMismatch  = errors.New("record belongs to another tenant")
+)
+
+// idPattern is a DNS label, so that every tenant can have a subdomain
+var idPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Valid reports whether id can name a tenant: lower-case letters, digits and
+// inner hyphens, at most 63 characters
+func Valid(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+type contextKey int
+
+const (
+	idKey contextKey = iota
+	unscopedKey
+)
+
+// WithID returns ctx carrying the tenant every statement is scoped to
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey, id)
+}
+
+// FromContext returns the tenant carried by ctx
+func FromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok := ctx.Value(idKey).(string)
+	return id, ok && id != ""
+}
+
+// AllowUnscoped returns ctx whose statements are not scoped to any tenant,
+// for the few callers that must see every tenant, such as maintenance jobs.
+// Records they create must carry their tenant themselves.
+func AllowUnscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unscopedKey, true)
+}
+
+// Rescope returns ctx scoped to tenant id, even if it comes from
+// AllowUnscoped, for unscoped callers that act on one tenant at a time
+func Rescope(ctx context.Context, id string) context.Context {
+	return WithID(context.WithValue(ctx, unscopedKey, false), id)
+}
+
+// Unscoped reports whether ctx was returned by AllowUnscoped
+func Unscoped(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	unscoped, _ := ctx.Value(unscopedKey).(bool)
+	return unscoped
+}
+
+// Resolver finds the tenant of an HTTP request, from a header or from the
+// subdomain of Domain the request was sent to
+type Resolver struct {
+	// Header names the tenant, e.g. X-Tenant-ID
+	Header string
+
+	// Domain is the base domain: a request to acme.example.com belongs to
+	// tenant acme when Domain is example.com. Empty disables subdomains.
+	Domain string
+}
+
+// Resolve returns the tenant of r. A request naming a tenant both ways must
+// name the same one.
+func (res Resolver) Resolve(r *http.Request) (string, error) {
+	var fromHeader, fromHost string
+	if res.Header != "" {
+		fromHeader = strings.TrimSpace(r.Header.Get(res.Header))
+	}
+	if res.Domain != "" {
+		fromHost = res.subdomain(r.Host)
+	}
+
+	id := fromHeader
+	switch {
+	case fromHeader == "" && fromHost == "":
+		return "", ErrMissing
+	case fromHeader == "":
+		id = fromHost
+	case fromHost != "" && fromHost != fromHeader:
+		return "", ErrAmbiguous
+	}
+
+	if !Valid(id) {
+		return "", ErrInvalid
+	}
+	return id, nil
+}
+
+// subdomain returns the label in front of Domain in host, or "" if host is
+// not a direct subdomain of it
+func (res Resolver) subdomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	label, ok := strings.CutSuffix(host, "."+strings.ToLower(res.Domain))
+	if !ok || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"errors"
+
+	"gorm-reference/internal/db/gormutil"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// Attributes set on every statement span
+const (
+	AttrStatement = attribute.Key("db.statement")
+	AttrTable     = attribute.Key("db.sql.table")
+	AttrRows      = attribute.Key("db.rows_affected")
+)
+
+// GORMPlugin starts a span for every statement GORM runs. The statement is
+// recorded with its placeholders; bind values never leave the process.
+type GORMPlugin struct{}
+
+var _ gorm.Plugin = (*GORMPlugin)(nil)
+
+// NewGORMPlugin returns the tracing plugin
+func NewGORMPlugin() *GORMPlugin {
+	return &GORMPlugin{}
+}
+
+// Name implements gorm.Plugin
+func (p *GORMPlugin) Name() string {
+	return "tracing"
+}
+
+// Initialize opens a span around each of GORM's operations
+func (p *GORMPlugin) Initialize(db *gorm.DB) error {
+	after := func(string) func(*gorm.DB) { return p.after }
+	return gormutil.Register(gormutil.Around(db, "tracing", p.before, after))
+}
+
+func (p *GORMPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		name := "gorm." + operation
+		if db.Statement.Table != "" {
+			name += " " + db.Statement.Table
+		}
+
+		// The span only lives between the callbacks, so the statement's
+		// context is left as it is
+		_, span := Tracer().Start(db.Statement.Context, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemNamePostgreSQL,
+				semconv.DBOperationName(operation),
+			),
+		)
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func (p *GORMPlugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		AttrStatement.String(db.Statement.SQL.String()),
+		AttrTable.String(db.Statement.Table),
+		AttrRows.Int64(db.RowsAffected),
+	)
+
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Not finding a row is an answer, not a failure
+		err = nil
+	}
+	End(span, err)
+}